@@ -33,24 +33,36 @@ func (s Sub) Ch() chan []model.Action {
 }
 
 // Dispatcher tracks agent subscriptions and emits actions to the subscriptions.
+//
+// Agents with actions to deliver are served in turn, each turn dispatches at most batchSize actions to an agent.
+// The actions over batchSize are not dispatched, the agent fetches them on its next checkin as they follow the last
+// action dispatched. An agent with a backlog of actions therefore delays the delivery to another agent by one turn.
 type Dispatcher struct {
-	am    monitor.SimpleMonitor
-	limit *rate.Limiter
+	am        monitor.SimpleMonitor
+	limit     *rate.Limiter
+	batchSize int
+
+	// pending and queue are only accessed by the Run loop.
+	pending map[string][]model.Action
+	queue   []string
 
 	mx   sync.RWMutex
 	subs map[string]Sub
 }
 
 // NewDispatcher creates a Dispatcher using the provided monitor.
-func NewDispatcher(am monitor.SimpleMonitor, throttle time.Duration, i int) *Dispatcher {
+// batchSize is the maximum number of actions dispatched to an agent in a turn, 0 is unlimited.
+func NewDispatcher(am monitor.SimpleMonitor, throttle time.Duration, i int, batchSize int) *Dispatcher {
 	r := rate.Inf
 	if throttle > 0 {
 		r = rate.Every(throttle)
 	}
 	return &Dispatcher{
-		am:    am,
-		limit: rate.NewLimiter(r, i),
-		subs:  make(map[string]Sub),
+		am:        am,
+		limit:     rate.NewLimiter(r, i),
+		batchSize: batchSize,
+		pending:   make(map[string][]model.Action),
+		subs:      make(map[string]Sub),
 	}
 }
 
@@ -59,6 +71,21 @@ func NewDispatcher(am monitor.SimpleMonitor, throttle time.Duration, i int) *Dis
 // Subscribe may be called before or after Run.
 func (d *Dispatcher) Run(ctx context.Context) (err error) {
 	for {
+		// New actions are queued between turns so they are not held behind a backlog.
+		if len(d.queue) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case hits := <-d.am.Output():
+				d.process(ctx, hits)
+			default:
+				if lErr := d.dispatchNext(ctx); lErr != nil {
+					zerolog.Ctx(ctx).Error().Err(lErr).Msg("action dispatcher rate limit error")
+					d.drop()
+				}
+			}
+			continue
+		}
 		select {
 		case <-ctx.Done():
 			return
@@ -104,7 +131,7 @@ func (d *Dispatcher) Unsubscribe(sub *Sub) {
 	zerolog.Ctx(context.TODO()).Trace().Str(logger.AgentID, sub.agentID).Int("sz", sz).Msg("Unsubscribed from action dispatcher")
 }
 
-// process gathers actions from the monitor and dispatches them to the corresponding subscriptions.
+// process gathers actions from the monitor and queues them for dispatch to the corresponding subscriptions.
 func (d *Dispatcher) process(ctx context.Context, hits []es.HitT) {
 	// Parse hits into map of agent -> actions
 	// Actions are ordered by sequence
@@ -128,13 +155,46 @@ func (d *Dispatcher) process(ctx context.Context, hits []es.HitT) {
 		}
 	}
 
+	if d.pending == nil {
+		d.pending = make(map[string][]model.Action)
+	}
 	for agentID, actions := range agentActions {
-		if err := d.limit.Wait(ctx); err != nil {
-			zerolog.Ctx(ctx).Error().Err(err).Msg("action dispatcher rate limit error")
-			return
+		if _, ok := d.pending[agentID]; !ok {
+			d.queue = append(d.queue, agentID)
 		}
-		d.dispatch(ctx, agentID, actions)
+		d.pending[agentID] = append(d.pending[agentID], actions...)
+	}
+}
+
+// drop discards the actions queued for dispatch; agents fetch them on their next checkin.
+func (d *Dispatcher) drop() {
+	d.pending = make(map[string][]model.Action)
+	d.queue = nil
+}
+
+// dispatchNext dispatches the first batchSize actions of the agent at the front of the queue.
+func (d *Dispatcher) dispatchNext(ctx context.Context) error {
+	agentID := d.queue[0]
+	d.queue = d.queue[1:]
+	actions := d.pending[agentID]
+	delete(d.pending, agentID)
+
+	// The agent fetches the actions it missed on its next checkin.
+	if _, ok := d.getSub(agentID); !ok {
+		zerolog.Ctx(ctx).Debug().Str(logger.AgentID, agentID).Int("count", len(actions)).Msg("Agent is not currently connected. Dropping queued actions.")
+		return nil
+	}
+
+	if d.batchSize > 0 && len(actions) > d.batchSize {
+		zerolog.Ctx(ctx).Debug().Str(logger.AgentID, agentID).Int("count", len(actions)-d.batchSize).Msg("Actions over the batch size are left for the next checkin.")
+		actions = actions[:d.batchSize]
+	}
+
+	if err := d.limit.Wait(ctx); err != nil {
+		return err
 	}
+	d.dispatch(ctx, agentID, actions)
+	return nil
 }
 
 // offsetStartTime will return a new start time between start:start+dur based on index i and the total number of agents
@@ -7,6 +7,7 @@ package action
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -39,7 +40,7 @@ func (m *mockMonitor) GetCheckpoint() sqn.SeqNo {
 
 func TestNewDispatcher(t *testing.T) {
 	m := &mockMonitor{}
-	d := NewDispatcher(m, 0, 0)
+	d := NewDispatcher(m, 0, 0, 0)
 
 	assert.NotNil(t, d.am)
 	assert.NotNil(t, d.subs)
@@ -306,6 +307,101 @@ func Test_Dispatcher_Run(t *testing.T) {
 	}
 }
 
+func Test_Dispatcher_Fairness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hit := func(actionID string, agents ...string) es.HitT {
+		src, err := json.Marshal(model.Action{ActionID: actionID, Agents: agents, Type: "upgrade"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return es.HitT{Source: src}
+	}
+	var backlog []es.HitT
+	for i := 0; i < 10; i++ {
+		backlog = append(backlog, hit(fmt.Sprintf("backlog-%d", i), "backlogged"))
+	}
+
+	d := NewDispatcher(nil, 0, 0, 2)
+	d.limit = rate.NewLimiter(rate.Inf, 1)
+	subs := make(map[string]*Sub)
+	for _, agentID := range []string{"backlogged", "other", "late"} {
+		subs[agentID] = d.Subscribe(agentID, sqn.SeqNo{})
+	}
+
+	turns := map[string]int{}
+	turn := 0
+	next := func() {
+		turn++
+		assert.NoError(t, d.dispatchNext(ctx))
+		for agentID, sub := range subs {
+			if _, ok := turns[agentID]; !ok && len(sub.Ch()) > 0 {
+				turns[agentID] = turn
+			}
+		}
+	}
+
+	d.process(ctx, backlog)
+	d.process(ctx, []es.HitT{hit("single", "other")})
+	next()
+	// Actions for another agent arriving while the backlog is dispatched wait for a single turn of the backlog.
+	d.process(ctx, []es.HitT{hit("late", "late")})
+	for len(d.queue) > 0 {
+		next()
+	}
+
+	// The backlog takes a single turn, its remaining actions are fetched on the next checkin of the agent.
+	assert.Equal(t, map[string]int{"backlogged": 1, "other": 2, "late": 3}, turns)
+	assert.Equal(t, 3, turn)
+	assert.Empty(t, d.pending)
+
+	batch := <-subs["backlogged"].Ch()
+	var delivered []string
+	for _, a := range batch {
+		delivered = append(delivered, a.ActionID)
+	}
+	assert.Equal(t, []string{"backlog-0", "backlog-1"}, delivered)
+	assert.Len(t, <-subs["other"].Ch(), 1)
+	assert.Len(t, <-subs["late"].Ch(), 1)
+}
+
+func Test_Dispatcher_FairnessBound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var hits []es.HitT
+	for i := 0; i < 100; i++ {
+		hits = append(hits, es.HitT{Source: json.RawMessage(fmt.Sprintf(`{"action_id":"backlog-%d","agents":["backlogged"],"type":"upgrade"}`, i))})
+	}
+	hits = append(hits, es.HitT{Source: json.RawMessage(`{"action_id":"single","agents":["other"],"type":"upgrade"}`)})
+	ch := make(chan []es.HitT, 1)
+	ch <- hits
+	var rch <-chan []es.HitT = ch
+	m := &mockMonitor{}
+	m.On("Output").Return(rch)
+
+	d := NewDispatcher(m, 0, 0, 2)
+	backlogged := d.Subscribe("backlogged", sqn.SeqNo{})
+	other := d.Subscribe("other", sqn.SeqNo{})
+
+	go func() {
+		_ = d.Run(ctx)
+	}()
+	// Both agents are served in two turns, the backlog is capped to the batch size.
+	for _, c := range []struct {
+		sub *Sub
+		len int
+	}{{backlogged, 2}, {other, 1}} {
+		select {
+		case actions := <-c.sub.Ch():
+			assert.Len(t, actions, c.len)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for subscription on %s", c.sub.agentID)
+		}
+	}
+}
+
 func Test_offsetStartTime(t *testing.T) {
 	tests := []struct {
 		name   string
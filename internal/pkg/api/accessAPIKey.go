@@ -0,0 +1,214 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// accessAPIKeyTTL returns the expiration of the access API keys of the agents at version ver in the Elasticsearch
+// time units format, empty if they never expire.
+func accessAPIKeyTTL(cfg config.AccessAPIKey, ver string) string {
+	if !cfg.Rotates(ver) {
+		return ""
+	}
+	return fmt.Sprintf("%ds", int64(cfg.Expiration/time.Second))
+}
+
+// accessAPIKeyExpiration returns the date/time an access API key created at now for an agent at version ver expires,
+// empty if it never expires.
+func accessAPIKeyExpiration(cfg config.AccessAPIKey, ver string, now time.Time) string {
+	if !cfg.Rotates(ver) {
+		return ""
+	}
+	return now.Add(cfg.Expiration).UTC().Format(time.RFC3339)
+}
+
+// rotateAccessAPIKey proactively replaces the access API key of the agent before it expires.
+//
+// The rotation happens in two steps so the agent never loses access:
+// a checkin with the current key near its expiration creates a new key, returned to be delivered in the checkin response, and
+// the first checkin with the new key makes it the access API key of the agent and invalidates the previous one.
+// The current key is accepted until then. The new key is delivered again on the following checkins of this server;
+// it is only replaced if it was issued by another server, or before a restart, at least half of rotate_before ago.
+func (ct *CheckinT) rotateAccessAPIKey(ctx context.Context, zlog zerolog.Logger, r *http.Request, agent *model.Agent, ver string, now time.Time) (*string, error) {
+	if agent.AccessAPIKeyExpiration == "" && agent.PendingAccessAPIKeyID == "" {
+		return nil, nil
+	}
+	key, err := apikey.ExtractAPIKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := apm.StartSpan(ctx, "rotateAccessAPIKey", "auth")
+	defer span.End()
+
+	if agent.PendingAccessAPIKeyID != "" && key.ID == agent.PendingAccessAPIKeyID {
+		ct.pendingKeys.delete(agent.Id)
+		return nil, ct.promoteAccessAPIKey(ctx, zlog, agent)
+	}
+	if agent.AccessAPIKeyExpiration == "" || !ct.cfg.AccessAPIKey.Rotates(ver) {
+		return nil, nil
+	}
+	expiration, err := time.Parse(time.RFC3339, agent.AccessAPIKeyExpiration)
+	if err != nil {
+		return nil, fmt.Errorf("parse access api key expiration: %w", err)
+	}
+	if now.Before(expiration.Add(-ct.cfg.AccessAPIKey.RotateBefore)) {
+		return nil, nil
+	}
+
+	// The agent did not switch to the replacement key yet.
+	if agent.PendingAccessAPIKeyID != "" {
+		if token, ok := ct.pendingKeys.get(agent.Id, agent.PendingAccessAPIKeyID, now); ok {
+			return &token, nil
+		}
+		if !ct.pendingKeyUndelivered(agent, now) {
+			return nil, nil
+		}
+		if err := ct.bulker.APIKeyInvalidate(ctx, agent.PendingAccessAPIKeyID); err != nil {
+			zlog.Warn().Err(err).Str(LogAPIKeyID, agent.PendingAccessAPIKeyID).Msg("unable to invalidate undelivered access api key")
+		}
+	}
+
+	newKey, err := generateAccessAPIKey(ctx, ct.bulker, agent.Id, accessAPIKeyTTL(ct.cfg.AccessAPIKey, ver))
+	if err != nil {
+		return nil, fmt.Errorf("create access api key: %w", err)
+	}
+	pendingExpiration := accessAPIKeyExpiration(ct.cfg.AccessAPIKey, ver, now)
+	body, err := bulk.UpdateFields{
+		dl.FieldPendingAccessAPIKeyID:         newKey.ID,
+		dl.FieldPendingAccessAPIKeyExpiration: pendingExpiration,
+		dl.FieldUpdatedAt:                     now.UTC().Format(time.RFC3339),
+	}.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := ct.bulker.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
+		if ierr := ct.bulker.APIKeyInvalidate(ctx, newKey.ID); ierr != nil {
+			zlog.Warn().Err(ierr).Str(LogAPIKeyID, newKey.ID).Msg("unable to invalidate undelivered access api key")
+		}
+		return nil, fmt.Errorf("update agent pending access api key: %w", err)
+	}
+	agent.PendingAccessAPIKeyID = newKey.ID
+	agent.PendingAccessAPIKeyExpiration = pendingExpiration
+
+	token := newKey.Token()
+	ct.pendingKeys.set(agent.Id, newKey.ID, token, now.Add(ct.cfg.AccessAPIKey.Expiration), now)
+
+	zlog.Info().
+		Str(LogAPIKeyID, newKey.ID).
+		Str("expiration", agent.AccessAPIKeyExpiration).
+		Msg("rotating expiring access api key")
+	return &token, nil
+}
+
+// pendingKeyUndelivered returns true if the pending access API key of the agent, unknown to this server, was issued at
+// least half of rotate_before ago; the agent is then assumed not to have received it.
+func (ct *CheckinT) pendingKeyUndelivered(agent *model.Agent, now time.Time) bool {
+	pendingExpiration, err := time.Parse(time.RFC3339, agent.PendingAccessAPIKeyExpiration)
+	if err != nil {
+		return true
+	}
+	issuedAt := pendingExpiration.Add(-ct.cfg.AccessAPIKey.Expiration)
+	return now.Sub(issuedAt) >= ct.cfg.AccessAPIKey.RotateBefore/2
+}
+
+// promoteAccessAPIKey makes the pending access API key the access API key of the agent and invalidates the previous one.
+func (ct *CheckinT) promoteAccessAPIKey(ctx context.Context, zlog zerolog.Logger, agent *model.Agent) error {
+	var expiration interface{}
+	if agent.PendingAccessAPIKeyExpiration != "" {
+		expiration = agent.PendingAccessAPIKeyExpiration
+	}
+	body, err := bulk.UpdateFields{
+		dl.FieldAccessAPIKeyID:                agent.PendingAccessAPIKeyID,
+		dl.FieldAccessAPIKeyExpiration:        expiration,
+		dl.FieldPendingAccessAPIKeyID:         nil,
+		dl.FieldPendingAccessAPIKeyExpiration: nil,
+		dl.FieldUpdatedAt:                     time.Now().UTC().Format(time.RFC3339),
+	}.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := ct.bulker.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
+		return fmt.Errorf("update agent access api key: %w", err)
+	}
+
+	previousID := agent.AccessAPIKeyID
+	agent.AccessAPIKeyID = agent.PendingAccessAPIKeyID
+	agent.AccessAPIKeyExpiration = agent.PendingAccessAPIKeyExpiration
+	agent.PendingAccessAPIKeyID = ""
+	agent.PendingAccessAPIKeyExpiration = ""
+
+	if previousID != "" {
+		if err := ct.bulker.APIKeyInvalidate(ctx, previousID); err != nil {
+			zlog.Warn().Err(err).Str(LogAPIKeyID, previousID).Msg("unable to invalidate rotated access api key")
+		}
+	}
+	zlog.Info().
+		Str(LogAPIKeyID, agent.AccessAPIKeyID).
+		Str("previous_api_key_id", previousID).
+		Msg("agent switched to the rotated access api key")
+	return nil
+}
+
+// pendingAccessAPIKeys holds the replacement access API keys this server issued until the agents switch to them,
+// so a key is delivered again rather than replaced on every checkin.
+type pendingAccessAPIKeys struct {
+	mu   sync.Mutex
+	keys map[string]pendingAccessAPIKey // by agent ID
+}
+
+type pendingAccessAPIKey struct {
+	id         string
+	token      string
+	expiration time.Time
+}
+
+func newPendingAccessAPIKeys() *pendingAccessAPIKeys {
+	return &pendingAccessAPIKeys{keys: make(map[string]pendingAccessAPIKey)}
+}
+
+// get returns the token of the pending key keyID of the agent, false if this server did not issue it or it expired.
+func (p *pendingAccessAPIKeys) get(agentID, keyID string, now time.Time) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	k, ok := p.keys[agentID]
+	if !ok || k.id != keyID || !now.Before(k.expiration) {
+		return "", false
+	}
+	return k.token, true
+}
+
+// set records the pending key of the agent and drops the expired keys.
+func (p *pendingAccessAPIKeys) set(agentID, keyID, token string, expiration, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, k := range p.keys {
+		if !now.Before(k.expiration) {
+			delete(p.keys, id)
+		}
+	}
+	p.keys[agentID] = pendingAccessAPIKey{id: keyID, token: token, expiration: expiration}
+}
+
+func (p *pendingAccessAPIKeys) delete(agentID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.keys, agentID)
+}
@@ -0,0 +1,121 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+)
+
+const adminLeaseAgesPath = "/admin/leases/ages"
+
+// ErrAdminAPIKeyForbidden is returned when an admin endpoint is called with an API key lacking adminPrivileges.
+var ErrAdminAPIKeyForbidden = errors.New("api key lacks the privileges required by admin endpoints")
+
+// adminPrivileges are the privileges required on the admin endpoints, all privileges on the Fleet indices.
+var adminPrivileges = apikey.IndexPrivileges{
+	Names:                  []string{".fleet-*"},
+	Privileges:             []string{"all"},
+	AllowRestrictedIndices: true,
+}
+
+// leaseAgeBounds are the upper bounds of the lease age histogram buckets.
+// Leases are renewed every 30s, so leases in the buckets past 30s are expired.
+var leaseAgeBounds = []time.Duration{
+	10 * time.Second,
+	20 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// LeaseAgeBucket is the JSON representation of a lease age histogram bucket.
+// To is empty for the last, open-ended, bucket.
+type LeaseAgeBucket struct {
+	From  string `json:"from"`
+	To    string `json:"to,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// LeaseAgesResponse is the response of the lease age histogram admin endpoint.
+type LeaseAgesResponse struct {
+	Buckets []LeaseAgeBucket `json:"buckets"`
+}
+
+// AttachAdminEndpoints adds the operator endpoints to the local monitoring HTTP server.
+// The endpoints query Elasticsearch with bulker, the logger in ctx is used for the requests.
+// Requests must be authenticated with an Elasticsearch API key holding all privileges on the Fleet indices.
+func AttachAdminEndpoints(ctx context.Context, router metricsRouter, bulker bulk.Bulk, c cache.Cache) {
+	log := zerolog.Ctx(ctx)
+	router.AddRoute(adminLeaseAgesPath, func(w http.ResponseWriter, r *http.Request) {
+		handleLeaseAges(w, r.WithContext(log.WithContext(r.Context())), bulker, c)
+	})
+}
+
+func handleLeaseAges(w http.ResponseWriter, r *http.Request, bulker bulk.Bulk, c cache.Cache) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := authAdminAPIKey(r, bulker, c); err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	buckets, err := dl.LeaseAgeHistogram(r.Context(), bulker, leaseAgeBounds)
+	if err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	resp := LeaseAgesResponse{Buckets: make([]LeaseAgeBucket, 0, len(buckets))}
+	for _, b := range buckets {
+		bucket := LeaseAgeBucket{From: b.From.String(), Count: b.Count}
+		if b.To != 0 {
+			bucket.To = b.To.String()
+		}
+		resp.Buckets = append(resp.Buckets, bucket)
+	}
+	writeAdminResponse(w, r, resp)
+}
+
+// authAdminAPIKey authenticates the operator API key of an admin request.
+//
+// The admin endpoints expose the content of the Fleet indices, such as the policies and pending actions of any agent,
+// so the key must hold adminPrivileges. The API keys of the agents, enrollment keys and ordinary user keys are rejected.
+func authAdminAPIKey(r *http.Request, bulker bulk.Bulk, c cache.Cache) error {
+	key, err := authAPIKey(r, bulker, c)
+	if err != nil {
+		return err
+	}
+	ok, err := key.HasPrivileges(r.Context(), bulker.Client(), adminPrivileges)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAdminAPIKeyForbidden
+	}
+	return nil
+}
+
+func writeAdminResponse(w http.ResponseWriter, r *http.Request, resp interface{}) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("failed to write admin response")
+	}
+}
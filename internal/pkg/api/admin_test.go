@@ -0,0 +1,117 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/elastic-agent-libs/api"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testAdminRouter is a metricsRouter backed by a http.ServeMux.
+type testAdminRouter struct {
+	*http.ServeMux
+}
+
+func (r testAdminRouter) AddRoute(path string, h api.HandlerFunc) {
+	r.HandleFunc(path, h)
+}
+
+// mockAdminPrivileges answers the privilege checks of the admin endpoints: operator-id holds all privileges on the
+// Fleet indices while user-id, an ordinary user key, and agent-key-id, the access key of an agent, do not.
+func mockAdminPrivileges(t *testing.T, bulker *ftesting.MockBulk) {
+	client, mocktrans := esutil.MockESClient(t)
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		assert.Equal(t, "/_security/user/_has_privileges", r.URL.Path)
+		var req struct {
+			Index []apikey.IndexPrivileges `json:"index"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []apikey.IndexPrivileges{adminPrivileges}, req.Index)
+		granted := r.Header.Get("Authorization") == "ApiKey "+base64.StdEncoding.EncodeToString([]byte("operator-id:key"))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"has_all_requested":%t}`, granted))),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	bulker.On("Client").Return(client)
+}
+
+// newTestAdminRouter serves the admin endpoints, see mockAdminPrivileges for the API keys.
+func newTestAdminRouter(t *testing.T, bulker *ftesting.MockBulk) http.Handler {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	mockAdminPrivileges(t, bulker)
+	c := testcache.NewMockCache()
+	c.On("ValidAPIKey", mock.Anything).Return(true)
+	router := testAdminRouter{http.NewServeMux()}
+	AttachAdminEndpoints(ctx, router, bulker, c)
+	return router
+}
+
+func newTestAdminRequest(method, target, keyID string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if keyID != "" {
+		req.Header.Set("Authorization", "ApiKey "+base64.StdEncoding.EncodeToString([]byte(keyID+":key")))
+	}
+	return req
+}
+
+func TestAdminLeaseAges(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPoliciesLeader, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{
+			"lease_age": {Buckets: []es.Bucket{{Key: "0", DocCount: 4}, {Key: "3", DocCount: 1}}},
+		},
+	}, nil).Once()
+	router := newTestAdminRouter(t, bulker)
+
+	// The endpoint requires an API key holding all privileges on the Fleet indices.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newTestAdminRequest(http.MethodGet, adminLeaseAgesPath, ""))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	for _, keyID := range []string{"user-id", "agent-key-id"} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, newTestAdminRequest(http.MethodGet, adminLeaseAgesPath, keyID))
+		require.Equal(t, http.StatusForbidden, rec.Code, keyID)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, newTestAdminRequest(http.MethodGet, adminLeaseAgesPath, "operator-id"))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp LeaseAgesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Buckets, len(leaseAgeBounds)+1)
+	require.Equal(t, LeaseAgeBucket{From: "0s", To: "10s", Count: 4}, resp.Buckets[0])
+	require.Equal(t, LeaseAgeBucket{From: "30s", To: "1m0s", Count: 1}, resp.Buckets[3])
+	require.Equal(t, LeaseAgeBucket{From: "5m0s"}, resp.Buckets[5])
+	bulker.AssertExpectations(t)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, adminLeaseAgesPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
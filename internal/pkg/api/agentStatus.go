@@ -0,0 +1,48 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+// agentOfflineAfter is how long an agent may go without checking in before it is counted as offline.
+const agentOfflineAfter = 5 * time.Minute
+
+var agentStatuses = []string{
+	dl.AgentStatusOnline,
+	dl.AgentStatusOffline,
+	dl.AgentStatusError,
+	dl.AgentStatusDegraded,
+	dl.AgentStatusUnenrolling,
+}
+
+// AgentStatusSchedule returns the schedule that refreshes the agent status gauges every interval.
+func AgentStatusSchedule(bulker bulk.Bulk, interval time.Duration) scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:     "agent status metrics",
+		Interval: interval,
+		WorkFn: func(ctx context.Context) error {
+			return updateAgentStatusMetrics(ctx, bulker, &cntAgentStatus, time.Now())
+		},
+	}
+}
+
+func updateAgentStatusMetrics(ctx context.Context, bulker bulk.Bulk, stats *agentStatusStats, now time.Time) error {
+	counts, err := dl.CountAgentsByStatus(ctx, bulker, now.Add(-agentOfflineAfter))
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("failed to count agents by status")
+		return err
+	}
+	stats.Update(counts)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestUpdateAgentStatusMetrics(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	reg := newMetricsRegistry("test_agent_status")
+	var stats agentStatusStats
+	stats.Register(reg)
+	// A status that disappears from the aggregation must be reset.
+	stats.gauges[dl.AgentStatusUnenrolling].Set(4)
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{"status": {Buckets: []es.Bucket{
+			{Key: dl.AgentStatusOnline, DocCount: 7},
+			{Key: dl.AgentStatusOffline, DocCount: 3},
+			{Key: dl.AgentStatusDegraded, DocCount: 1},
+		}}},
+	}, nil).Once()
+
+	err := updateAgentStatusMetrics(ctx, bulker, &stats, time.Now())
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+
+	expected := map[string]uint64{
+		dl.AgentStatusOnline:      7,
+		dl.AgentStatusOffline:     3,
+		dl.AgentStatusError:       0,
+		dl.AgentStatusDegraded:    1,
+		dl.AgentStatusUnenrolling: 0,
+	}
+	for status, v := range expected {
+		require.Equal(t, v, stats.gauges[status].metric.Get(), status)
+		require.Equal(t, float64(v), testutil.ToFloat64(stats.gauges[status].gauge), status)
+	}
+}
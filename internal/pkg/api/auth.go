@@ -144,7 +144,8 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 
 	// validate that the Access ApiKey identifier stored in the agent's record
 	// is in alignment when the authenticated key provided on this transaction
-	if agent.AccessAPIKeyID != key.ID {
+	// the key replacing an expiring access ApiKey is accepted as well
+	if agent.AccessAPIKeyID != key.ID && (agent.PendingAccessAPIKeyID == "" || agent.PendingAccessAPIKeyID != key.ID) {
 		zlog.Warn().
 			Err(ErrAgentCorrupted).
 			Str("agent.AccessApiKeyId", agent.AccessAPIKeyID).
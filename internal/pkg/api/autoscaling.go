@@ -0,0 +1,165 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+)
+
+// autoscalingPath is outside of /admin as autoscalers read the hint without authentication.
+const autoscalingPath = "/autoscaling"
+
+// AutoscalingResponse is the response of the autoscaling hint endpoint.
+type AutoscalingResponse struct {
+	// CheckinRate is the number of checkins per second handled by this server over the last sample interval.
+	CheckinRate float64 `json:"checkin_rate"`
+	// InflightCheckins is the number of checkins this server is handling, long polls included.
+	InflightCheckins uint64 `json:"inflight_checkins"`
+	// BulkQueueDepth is the number of operations waiting to be flushed to Elasticsearch.
+	BulkQueueDepth int `json:"bulk_queue_depth"`
+	// AgentsServed is the number of online agents, as last counted by the agent status metrics.
+	// It is absent when the agent status metrics are disabled, rather than reporting no agents.
+	AgentsServed *uint64 `json:"agents_served,omitempty"`
+	// RecommendedReplicas is the number of fleet-server replicas the load calls for.
+	RecommendedReplicas int `json:"recommended_replicas"`
+}
+
+// bulkQueue is the queue of the bulk engine, implemented by *bulk.Bulker.
+type bulkQueue interface {
+	QueueDepth() int
+}
+
+// autoscalingT samples the load indicators of this server for the autoscaling hint.
+type autoscalingT struct {
+	cfg    config.Autoscaling
+	bulker bulkQueue
+
+	// checkins, inflight and online read the checkin route and agent status metrics, online is nil if agents are not counted.
+	checkins func() uint64
+	inflight func() uint64
+	online   func() uint64
+
+	mu           sync.Mutex
+	rate         float64
+	lastCheckins uint64
+	lastSample   time.Time
+}
+
+// AttachAutoscalingEndpoint adds the autoscaling hint endpoint to the local monitoring HTTP server.
+// The endpoint is not authenticated, it only exposes load indicators.
+// The number of online agents is only reported if countsAgents, as the agent status metrics are enabled.
+func AttachAutoscalingEndpoint(ctx context.Context, router metricsRouter, cfg config.Autoscaling, countsAgents bool, bulker bulkQueue) {
+	log := zerolog.Ctx(ctx)
+	as := newAutoscalingT(cfg, countsAgents, bulker)
+	go as.run(ctx)
+	router.AddRoute(autoscalingPath, func(w http.ResponseWriter, r *http.Request) {
+		as.handleAutoscaling(w, r.WithContext(log.WithContext(r.Context())))
+	})
+}
+
+func newAutoscalingT(cfg config.Autoscaling, countsAgents bool, bulker bulkQueue) *autoscalingT {
+	as := &autoscalingT{
+		cfg:      cfg,
+		bulker:   bulker,
+		checkins: cntCheckin.total.metric.Get,
+		inflight: cntCheckin.active.metric.Get,
+	}
+	if countsAgents {
+		as.online = cntAgentStatus.gauges[dl.AgentStatusOnline].metric.Get
+	}
+	as.lastCheckins = as.checkins()
+	as.lastSample = time.Now()
+	return as
+}
+
+func (as *autoscalingT) handleAutoscaling(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminResponse(w, r, as.hint())
+}
+
+// run samples the checkin rate every sample interval until ctx is cancelled.
+func (as *autoscalingT) run(ctx context.Context) {
+	t := time.NewTicker(as.cfg.SampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			as.sample(now)
+		}
+	}
+}
+
+// sample updates the checkin rate to the average since the previous sample at now.
+func (as *autoscalingT) sample(now time.Time) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	checkins := as.checkins()
+	as.rate = 0
+	if elapsed := now.Sub(as.lastSample); elapsed > 0 && checkins >= as.lastCheckins {
+		as.rate = float64(checkins-as.lastCheckins) / elapsed.Seconds()
+	}
+	as.lastCheckins = checkins
+	as.lastSample = now
+}
+
+// hint reads the load indicators, with the checkin rate of the last sample, and computes the recommended replicas.
+func (as *autoscalingT) hint() AutoscalingResponse {
+	as.mu.Lock()
+	rate := as.rate
+	as.mu.Unlock()
+
+	resp := AutoscalingResponse{
+		CheckinRate:      rate,
+		InflightCheckins: as.inflight(),
+		BulkQueueDepth:   as.bulker.QueueDepth(),
+	}
+	if as.online != nil {
+		online := as.online()
+		resp.AgentsServed = &online
+	}
+	resp.RecommendedReplicas = recommendReplicas(as.cfg, resp)
+	return resp
+}
+
+// recommendReplicas returns the number of replicas needed to keep each load indicator within its threshold.
+//
+// The online agents are counted across the deployment, and ignored when unknown. The other indicators are the load of this server:
+// a server over a threshold carries the load of several replicas, so at least that many are recommended.
+func recommendReplicas(cfg config.Autoscaling, load AutoscalingResponse) int {
+	replicas := cfg.MinReplicas
+	need := func(value, perReplica float64) {
+		if perReplica <= 0 {
+			return
+		}
+		if n := int(math.Ceil(value / perReplica)); n > replicas {
+			replicas = n
+		}
+	}
+	if load.AgentsServed != nil {
+		need(float64(*load.AgentsServed), float64(cfg.AgentsPerReplica))
+	}
+	need(load.CheckinRate, cfg.CheckinRatePerReplica)
+	need(float64(load.InflightCheckins), float64(cfg.InflightCheckinsPerReplica))
+	need(float64(load.BulkQueueDepth), float64(cfg.BulkQueueDepthPerReplica))
+
+	if cfg.MaxReplicas > 0 && replicas > cfg.MaxReplicas {
+		replicas = cfg.MaxReplicas
+	}
+	return replicas
+}
@@ -0,0 +1,184 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+type testBulkQueue int
+
+func (q testBulkQueue) QueueDepth() int {
+	return int(q)
+}
+
+func TestRecommendReplicas(t *testing.T) {
+	cfg := config.Autoscaling{
+		MinReplicas:                2,
+		MaxReplicas:                10,
+		AgentsPerReplica:           1000,
+		CheckinRatePerReplica:      50,
+		InflightCheckinsPerReplica: 500,
+		BulkQueueDepthPerReplica:   100,
+	}
+	tests := []struct {
+		name     string
+		load     AutoscalingResponse
+		replicas int
+	}{{
+		name:     "idle",
+		replicas: 2,
+	}, {
+		name:     "within thresholds",
+		load:     AutoscalingResponse{AgentsServed: agentsServed(2000), CheckinRate: 50, InflightCheckins: 500, BulkQueueDepth: 100},
+		replicas: 2,
+	}, {
+		name:     "agents over threshold",
+		load:     AutoscalingResponse{AgentsServed: agentsServed(2001)},
+		replicas: 3,
+	}, {
+		name:     "checkin rate over threshold",
+		load:     AutoscalingResponse{CheckinRate: 160},
+		replicas: 4,
+	}, {
+		name:     "inflight checkins over threshold",
+		load:     AutoscalingResponse{InflightCheckins: 2400},
+		replicas: 5,
+	}, {
+		name:     "bulk queue over threshold",
+		load:     AutoscalingResponse{BulkQueueDepth: 550},
+		replicas: 6,
+	}, {
+		name:     "largest indicator wins",
+		load:     AutoscalingResponse{AgentsServed: agentsServed(3500), CheckinRate: 160},
+		replicas: 4,
+	}, {
+		name:     "capped",
+		load:     AutoscalingResponse{AgentsServed: agentsServed(50000)},
+		replicas: 10,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.replicas, recommendReplicas(cfg, tc.load))
+		})
+	}
+
+	t.Run("disabled thresholds", func(t *testing.T) {
+		var cfg config.Autoscaling
+		cfg.InitDefaults()
+		load := AutoscalingResponse{AgentsServed: agentsServed(50000), CheckinRate: 1000, InflightCheckins: 50000, BulkQueueDepth: 10000}
+		require.Equal(t, 1, recommendReplicas(cfg, load))
+	})
+}
+
+func agentsServed(n uint64) *uint64 {
+	return &n
+}
+
+func TestAutoscalingHint(t *testing.T) {
+	cfg := config.Autoscaling{MinReplicas: 1, CheckinRatePerReplica: 10, InflightCheckinsPerReplica: 100, BulkQueueDepthPerReplica: 50}
+	var checkins, inflight uint64
+	queue := testBulkQueue(0)
+	as := &autoscalingT{
+		cfg:        cfg,
+		bulker:     &queue,
+		checkins:   func() uint64 { return checkins },
+		inflight:   func() uint64 { return inflight },
+		lastSample: time.Now(),
+	}
+	start := as.lastSample
+
+	as.sample(start.Add(10 * time.Second))
+	resp := as.hint()
+	require.Equal(t, 1, resp.RecommendedReplicas)
+
+	// 300 checkins in 10s is 3 times the checkin rate a replica handles.
+	checkins = 300
+	as.sample(start.Add(20 * time.Second))
+	resp = as.hint()
+	require.InDelta(t, 30, resp.CheckinRate, 0.001)
+	require.Equal(t, 3, resp.RecommendedReplicas)
+
+	// Reading the hint does not reset the rate of the last sample.
+	resp = as.hint()
+	require.InDelta(t, 30, resp.CheckinRate, 0.001)
+
+	// The rate is measured since the previous sample.
+	inflight = 450
+	queue = 20
+	as.sample(start.Add(30 * time.Second))
+	resp = as.hint()
+	require.Zero(t, resp.CheckinRate)
+	require.Equal(t, 5, resp.RecommendedReplicas)
+
+	queue = 400
+	resp = as.hint()
+	require.Equal(t, 400, resp.BulkQueueDepth)
+	require.Equal(t, 8, resp.RecommendedReplicas)
+	require.Nil(t, resp.AgentsServed)
+
+	// Once agents are counted, no online agents is reported as such.
+	as.online = func() uint64 { return 0 }
+	resp = as.hint()
+	require.Equal(t, agentsServed(0), resp.AgentsServed)
+}
+
+func TestAutoscalingSampler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var checkins atomic.Uint64
+	queue := testBulkQueue(0)
+	as := &autoscalingT{
+		cfg:        config.Autoscaling{MinReplicas: 1, SampleInterval: 10 * time.Millisecond},
+		bulker:     &queue,
+		checkins:   func() uint64 { return checkins.Add(10) },
+		inflight:   func() uint64 { return 0 },
+		lastSample: time.Now(),
+	}
+	go as.run(ctx)
+
+	// The rate is sampled on the ticker, without any request to the endpoint.
+	require.Eventually(t, func() bool {
+		return as.hint().CheckinRate > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAutoscalingEndpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+	router := testAdminRouter{http.NewServeMux()}
+	var cfg config.Autoscaling
+	cfg.InitDefaults()
+	cfg.BulkQueueDepthPerReplica = 10
+	AttachAutoscalingEndpoint(ctx, router, cfg, false, testBulkQueue(25))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, autoscalingPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp AutoscalingResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, 25, resp.BulkQueueDepth)
+	require.Equal(t, 3, resp.RecommendedReplicas)
+	// Agents are not counted, the number of agents served is absent rather than 0.
+	require.NotContains(t, w.Body.String(), "agents_served")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, autoscalingPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,396 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+)
+
+const adminCheckinDryRunPath = "/admin/checkin/dry_run"
+
+// dryRunAPIKey is the placeholder returned for the ID of the API keys a dry run would have created.
+const dryRunAPIKey = "dry-run"
+
+// redactedValue replaces the secret values and API keys in the policies returned by the admin endpoints.
+const redactedValue = "[REDACTED]"
+
+// CheckinDryRunResponse is the response of the dry run checkin admin endpoint.
+// It is the response the agent would receive on its next checkin, along with the writes that checkin would perform.
+type CheckinDryRunResponse struct {
+	CheckinResponse
+	SuppressedWrites []string `json:"suppressed_writes"`
+}
+
+// AttachCheckinDryRunEndpoint adds the dry run checkin endpoint to the local monitoring HTTP server.
+// Requests must be authenticated with an Elasticsearch API key holding all privileges on the Fleet indices,
+// output credentials and secret values are redacted.
+func AttachCheckinDryRunEndpoint(ctx context.Context, router metricsRouter, ct *CheckinT) {
+	log := zerolog.Ctx(ctx)
+	router.AddRoute(adminCheckinDryRunPath, func(w http.ResponseWriter, r *http.Request) {
+		ct.handleCheckinDryRun(w, r.WithContext(log.WithContext(r.Context())))
+	})
+}
+
+func (ct *CheckinT) handleCheckinDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := authAdminAPIKey(r, ct.bulker, ct.cache); err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		ErrorResp(w, r, ErrAgentIDMissing)
+		return
+	}
+	zlog := zerolog.Ctx(r.Context()).With().Str(LogAgentID, agentID).Logger()
+	resp, err := ct.checkinDryRun(zlog.WithContext(r.Context()), zlog, agentID)
+	if err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	writeAdminResponse(w, r, resp)
+}
+
+// checkinDryRun computes the response of the next checkin of the agent without waiting on the long poll.
+//
+// Pending actions are returned first, as on a checkin. Otherwise a newer policy is assembled for the agent the
+// way the policy monitor would deliver it. Writes are not sent to Elasticsearch but listed in the response.
+func (ct *CheckinT) checkinDryRun(ctx context.Context, zlog zerolog.Logger, agentID string) (*CheckinDryRunResponse, error) {
+	bulker := newDryRunBulk(ct.bulker)
+
+	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByID, dl.FieldID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !agent.Active {
+		return nil, ErrAgentInactive
+	}
+
+	pendingActions, err := dl.FindAgentActions(ctx, bulker, agent.ActionSeqNo, ct.gcp.GetCheckpoint(), agent.Id, ct.cfg.Checkin.MaxActions)
+	if err != nil {
+		return nil, fmt.Errorf("fetchAgentPendingActions: %w", err)
+	}
+	pendingActions = filterActions(zlog, agent.Id, pendingActions)
+	actions, ackToken := convertActions(zlog, agent.Id, pendingActions)
+
+	if len(actions) == 0 {
+		pp, err := dryRunPolicy(ctx, bulker, &agent)
+		if err != nil {
+			return nil, err
+		}
+		if pp != nil {
+			actionResp, _, err := ct.assemblePolicy(ctx, zlog, bulker, agent.Id, pp, "")
+			if err != nil {
+				return nil, fmt.Errorf("processPolicy: %w", err)
+			}
+			if err := redactPolicyChange(actionResp); err != nil {
+				return nil, err
+			}
+			actions = append(actions, *actionResp)
+		}
+	}
+
+	pollTimeoutReached := len(actions) == 0
+	return &CheckinDryRunResponse{
+		CheckinResponse: CheckinResponse{
+			AckToken:           &ackToken,
+			Action:             "checkin",
+			Actions:            &actions,
+			PollTimeoutReached: &pollTimeoutReached,
+		},
+		SuppressedWrites: bulker.suppressed(),
+	}, nil
+}
+
+// dryRunPolicy returns the latest revision of the agent policy if the policy monitor would deliver it to the agent,
+// otherwise nil.
+func dryRunPolicy(ctx context.Context, bulker bulk.Bulk, agent *model.Agent) (*policy.ParsedPolicy, error) {
+	policies, err := dl.QueryLatestPolicies(ctx, bulker)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		if p.PolicyID != agent.PolicyID {
+			continue
+		}
+		// Same condition as the policy monitor subscriptions.
+		if (p.RevisionIdx > agent.PolicyRevisionIdx && p.CoordinatorIdx > 0) ||
+			(p.RevisionIdx == agent.PolicyRevisionIdx && p.CoordinatorIdx > agent.PolicyCoordinatorIdx) {
+			return policy.NewParsedPolicy(ctx, bulker, p)
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// redactPolicyChange redacts the output API keys of the policy change action.
+func redactPolicyChange(action *Action) error {
+	pc, err := action.Data.AsActionPolicyChange()
+	if err != nil {
+		return err
+	}
+	redactPolicyData(&pc.Policy)
+	return action.Data.FromActionPolicyChange(pc)
+}
+
+// credentialKeys are the settings of an output holding a credential, at any depth such as ssl.key.
+var credentialKeys = map[string]bool{
+	"api_key":        true,
+	"service_token":  true,
+	"password":       true,
+	"passphrase":     true,
+	"key":            true,
+	"key_passphrase": true,
+	"token":          true,
+	"secret":         true,
+	"client_secret":  true,
+}
+
+// credentialSections are the settings of an output whose values are all credentials, except for the keptKeys.
+var credentialSections = map[string]bool{
+	"sasl":     true,
+	"kerberos": true,
+	"headers":  true,
+}
+
+// keptKeys are the settings of the credentialSections kept as-is as they only describe the authentication.
+var keptKeys = map[string]bool{
+	"mechanism":    true,
+	"enabled":      true,
+	"auth_type":    true,
+	"service_name": true,
+	"realm":        true,
+}
+
+// redactPolicyData replaces the credentials of each output of the policy, inline or in a nested setting.
+// Secret values are already redacted when the policy is parsed with a dryRunBulk.
+func redactPolicyData(data *PolicyData) {
+	if data.Outputs == nil {
+		return
+	}
+	for _, v := range *data.Outputs {
+		if output, ok := v.(map[string]interface{}); ok {
+			redactCredentials(output, false)
+		}
+	}
+}
+
+// redactCredentials redacts the credentialKeys of m, or all its values but the keptKeys if m is in a credential section.
+// Keys may be nested maps or dotted, such as ssl.key.
+func redactCredentials(m map[string]interface{}, section bool) {
+	for k, v := range m {
+		path := strings.Split(k, ".")
+		name := path[len(path)-1]
+		inSection := section
+		for _, p := range path[:len(path)-1] {
+			inSection = inSection || credentialSections[p]
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			redactCredentials(sub, inSection || credentialSections[name])
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		if credentialKeys[name] || credentialSections[name] || (inSection && !keptKeys[name]) {
+			m[k] = redactedValue
+			continue
+		}
+		if list, ok := v.([]interface{}); ok {
+			for _, e := range list {
+				if em, ok := e.(map[string]interface{}); ok {
+					redactCredentials(em, inSection)
+				}
+			}
+		}
+	}
+}
+
+var (
+	errDryRunClient       = errors.New("the elasticsearch client is not available in a dry run")
+	errDryRunRemoteOutput = errors.New("remote outputs can not be read in a dry run")
+)
+
+// dryRunClient is returned by dryRunBulk.Client, its requests fail so they can not escape the recorded writes.
+var dryRunClient = func() *elasticsearch.Client {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Transport: dryRunTransport{}})
+	if err != nil {
+		panic(err)
+	}
+	return client
+}()
+
+type dryRunTransport struct{}
+
+func (dryRunTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errDryRunClient
+}
+
+// dryRunBulk forwards reads to Elasticsearch and records the writes instead of performing them.
+type dryRunBulk struct {
+	bulk.Bulk
+
+	// remote is the name of the remote output the bulker stands for, empty for the local bulker.
+	// The bulkers of the remote outputs are never created in a dry run, their reads fail.
+	remote string
+
+	mu     *sync.Mutex
+	writes *[]string
+}
+
+func newDryRunBulk(bulker bulk.Bulk) *dryRunBulk {
+	return &dryRunBulk{Bulk: bulker, mu: &sync.Mutex{}, writes: &[]string{}}
+}
+
+func (b *dryRunBulk) record(format string, args ...interface{}) {
+	write := fmt.Sprintf(format, args...)
+	if b.remote != "" {
+		write += " on output " + b.remote
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*b.writes = append(*b.writes, write)
+}
+
+func (b *dryRunBulk) suppressed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, *b.writes...)
+}
+
+func (b *dryRunBulk) recordOps(op string, ops []bulk.MultiOp) []bulk.BulkIndexerResponseItem {
+	items := make([]bulk.BulkIndexerResponseItem, 0, len(ops))
+	for _, o := range ops {
+		b.record("%s %s/%s", op, o.Index, o.ID)
+		items = append(items, bulk.BulkIndexerResponseItem{DocumentID: o.ID, Index: o.Index, Status: http.StatusOK})
+	}
+	return items
+}
+
+func (b *dryRunBulk) Create(_ context.Context, index, id string, _ []byte, _ ...bulk.Opt) (string, error) {
+	b.record("create %s/%s", index, id)
+	return id, nil
+}
+
+func (b *dryRunBulk) Index(_ context.Context, index, id string, _ []byte, _ ...bulk.Opt) (string, error) {
+	b.record("index %s/%s", index, id)
+	return id, nil
+}
+
+func (b *dryRunBulk) Update(_ context.Context, index, id string, _ []byte, _ ...bulk.Opt) error {
+	b.record("update %s/%s", index, id)
+	return nil
+}
+
+func (b *dryRunBulk) Delete(_ context.Context, index, id string, _ ...bulk.Opt) error {
+	b.record("delete %s/%s", index, id)
+	return nil
+}
+
+func (b *dryRunBulk) MCreate(_ context.Context, ops []bulk.MultiOp, _ ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.recordOps("create", ops), nil
+}
+
+func (b *dryRunBulk) MIndex(_ context.Context, ops []bulk.MultiOp, _ ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.recordOps("index", ops), nil
+}
+
+func (b *dryRunBulk) MUpdate(_ context.Context, ops []bulk.MultiOp, _ ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.recordOps("update", ops), nil
+}
+
+func (b *dryRunBulk) MDelete(_ context.Context, ops []bulk.MultiOp, _ ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.recordOps("delete", ops), nil
+}
+
+func (b *dryRunBulk) APIKeyCreate(_ context.Context, name, _ string, _ []byte, _ interface{}) (*bulk.APIKey, error) {
+	b.record("create api key %s", name)
+	return &bulk.APIKey{ID: dryRunAPIKey, Key: redactedValue}, nil
+}
+
+// ReadSecrets does not read the secret values, each is replaced by redactedValue.
+func (b *dryRunBulk) ReadSecrets(_ context.Context, secretIds []string) (map[string]string, error) {
+	values := make(map[string]string, len(secretIds))
+	for _, id := range secretIds {
+		values[id] = redactedValue
+	}
+	return values, nil
+}
+
+func (b *dryRunBulk) APIKeyInvalidate(_ context.Context, ids ...string) error {
+	for _, id := range ids {
+		b.record("invalidate api key %s", id)
+	}
+	return nil
+}
+
+func (b *dryRunBulk) APIKeyUpdate(_ context.Context, id, _ string, _ []byte) error {
+	b.record("update api key %s", id)
+	return nil
+}
+
+func (b *dryRunBulk) Read(ctx context.Context, index, id string, opts ...bulk.Opt) ([]byte, error) {
+	if b.remote != "" {
+		return nil, errDryRunRemoteOutput
+	}
+	return b.Bulk.Read(ctx, index, id, opts...)
+}
+
+func (b *dryRunBulk) Search(ctx context.Context, index string, body []byte, opts ...bulk.Opt) (*es.ResultT, error) {
+	if b.remote != "" {
+		return nil, errDryRunRemoteOutput
+	}
+	return b.Bulk.Search(ctx, index, body, opts...)
+}
+
+func (b *dryRunBulk) APIKeyRead(ctx context.Context, id string, withOwner bool) (*bulk.APIKeyMetadata, error) {
+	if b.remote != "" {
+		return nil, errDryRunRemoteOutput
+	}
+	return b.Bulk.APIKeyRead(ctx, id, withOwner)
+}
+
+func (b *dryRunBulk) APIKeyAuth(ctx context.Context, key bulk.APIKey) (*bulk.SecurityInfo, error) {
+	if b.remote != "" {
+		return nil, errDryRunRemoteOutput
+	}
+	return b.Bulk.APIKeyAuth(ctx, key)
+}
+
+// Client fails all requests, a dry run only talks to Elasticsearch through the bulker.
+func (b *dryRunBulk) Client() *elasticsearch.Client {
+	return dryRunClient
+}
+
+// CreateAndGetBulker does not create nor replace the bulker of the remote output. The returned bulker records the
+// writes a checkin would send to the remote output, along with the writes of b.
+func (b *dryRunBulk) CreateAndGetBulker(_ context.Context, _ zerolog.Logger, outputName string, _ map[string]map[string]interface{}) (bulk.Bulk, bool, error) {
+	return b.GetBulker(outputName), false, nil
+}
+
+func (b *dryRunBulk) GetBulker(outputName string) bulk.Bulk {
+	return &dryRunBulk{Bulk: b.Bulk, remote: outputName, mu: b.mu, writes: b.writes}
+}
+
+func (b *dryRunBulk) GetBulkerMap() map[string]bulk.Bulk {
+	return nil
+}
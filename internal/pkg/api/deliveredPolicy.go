@@ -0,0 +1,92 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+)
+
+const adminDeliveredPolicyPath = "/admin/policy/delivered"
+
+// AttachDeliveredPolicyEndpoint adds the delivered policy endpoint to the local monitoring HTTP server.
+// Requests must be authenticated with an Elasticsearch API key holding all privileges on the Fleet indices,
+// output credentials and secret values are redacted.
+func AttachDeliveredPolicyEndpoint(ctx context.Context, router metricsRouter, ct *CheckinT) {
+	log := zerolog.Ctx(ctx)
+	router.AddRoute(adminDeliveredPolicyPath, func(w http.ResponseWriter, r *http.Request) {
+		ct.handleDeliveredPolicy(w, r.WithContext(log.WithContext(r.Context())))
+	})
+}
+
+// GetDeliveredPolicy returns the latest revision of the policy of the agent, assembled as a checkin would deliver it.
+//
+// The policy goes through the same processing as on a checkin, including the output preparation.
+// Writes are not sent to Elasticsearch, output credentials and secret values are redacted.
+func (ct *CheckinT) GetDeliveredPolicy(ctx context.Context, agentID string) (*PolicyData, error) {
+	zlog := zerolog.Ctx(ctx).With().Str(LogAgentID, agentID).Logger()
+	bulker := newDryRunBulk(ct.bulker)
+
+	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByID, dl.FieldID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !agent.Active {
+		return nil, ErrAgentInactive
+	}
+
+	policies, err := dl.QueryLatestPolicies(ctx, bulker)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		if p.PolicyID != agent.PolicyID {
+			continue
+		}
+		pp, err := policy.NewParsedPolicy(ctx, bulker, p)
+		if err != nil {
+			return nil, err
+		}
+		action, _, err := ct.assemblePolicy(ctx, zlog, bulker, agent.Id, pp, "")
+		if err != nil {
+			return nil, fmt.Errorf("processPolicy: %w", err)
+		}
+		pc, err := action.Data.AsActionPolicyChange()
+		if err != nil {
+			return nil, err
+		}
+		redactPolicyData(&pc.Policy)
+		return &pc.Policy, nil
+	}
+	return nil, ErrPolicyNotFound
+}
+
+func (ct *CheckinT) handleDeliveredPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := authAdminAPIKey(r, ct.bulker, ct.cache); err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		ErrorResp(w, r, ErrAgentIDMissing)
+		return
+	}
+	resp, err := ct.GetDeliveredPolicy(r.Context(), agentID)
+	if err != nil {
+		ErrorResp(w, r, err)
+		return
+	}
+	writeAdminResponse(w, r, resp)
+}
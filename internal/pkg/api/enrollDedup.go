@@ -0,0 +1,99 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// recentEnrollments remembers the enrollments completed on this server within the deduplication window,
+// so an agent retrying an enrollment it did not receive the response of gets the same agent and access API key
+// instead of enrolling twice.
+//
+// Enrollments are keyed by the enrollment API key, the enrollment_id of the request and a digest of the request
+// body. The responses hold the access API keys, which are not stored in Elasticsearch, so retries are only
+// deduplicated by the server that handled the original request, and the secret of each access API key stays in
+// memory for the whole window.
+type recentEnrollments struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*recentEnrollment
+	lastPrune time.Time
+}
+
+// recentEnrollment is an enrollment in progress, or completed at the time at.
+type recentEnrollment struct {
+	done chan struct{}
+	resp *EnrollResponse
+	err  error
+	at   time.Time
+}
+
+func newRecentEnrollments(window time.Duration) *recentEnrollments {
+	return &recentEnrollments{window: window, entries: make(map[string]*recentEnrollment)}
+}
+
+// do returns the response of the enrollment with the given key completed within the window, if any.
+// Otherwise it enrolls with fn and remembers the response; retries arriving meanwhile wait for it.
+// Failed enrollments are not remembered, the next retry enrolls again.
+// The returned bool is true if the response is that of a previous enrollment.
+func (re *recentEnrollments) do(ctx context.Context, key string, fn func() (*EnrollResponse, error)) (*EnrollResponse, bool, error) {
+	for {
+		re.mu.Lock()
+		now := time.Now()
+		if now.Sub(re.lastPrune) > re.window {
+			re.prune(now)
+			re.lastPrune = now
+		}
+		e, ok := re.entries[key]
+		if !ok || e.expired(now, re.window) {
+			e = &recentEnrollment{done: make(chan struct{})}
+			re.entries[key] = e
+			re.mu.Unlock()
+
+			resp, err := fn()
+			re.mu.Lock()
+			e.resp, e.err, e.at = resp, err, time.Now()
+			if err != nil {
+				delete(re.entries, key)
+			}
+			close(e.done)
+			re.mu.Unlock()
+			return resp, false, err
+		}
+		re.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if e.err == nil {
+			return e.resp, true, nil
+		}
+	}
+}
+
+// prune removes the enrollments completed before the window, re.mu must be held.
+func (re *recentEnrollments) prune(now time.Time) {
+	for key, e := range re.entries {
+		if e.expired(now, re.window) {
+			delete(re.entries, key)
+		}
+	}
+}
+
+// expired returns true if the enrollment completed before the window, the lock of its recentEnrollments must be held.
+func (e *recentEnrollment) expired(now time.Time, window time.Duration) bool {
+	select {
+	case <-e.done:
+		return now.Sub(e.at) > window
+	default:
+		return false
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"go.elastic.co/apm/v2"
 
 	"github.com/rs/zerolog"
@@ -74,6 +75,15 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			ErrAdminAPIKeyForbidden,
+			HTTPErrResp{
+				http.StatusForbidden,
+				"Forbidden",
+				"api key lacks the privileges required by admin endpoints",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			context.Canceled,
 			HTTPErrResp{
@@ -447,6 +457,24 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			policy.ErrInvalidPolicy,
+			HTTPErrResp{
+				http.StatusInternalServerError,
+				"ErrInvalidPolicy",
+				"",
+				zerolog.ErrorLevel,
+			},
+		},
+		{
+			ErrClockSkew,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ClockSkew",
+				"agent clock skew exceeds max_clock_skew, check the clock of the agent host",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			ErrPolicyNotFound,
 			HTTPErrResp{
@@ -456,6 +484,26 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			ErrPolicyAgentLimit,
+			HTTPErrResp{
+				http.StatusConflict,
+				"ErrPolicyAgentLimit",
+				"the policy reached its maximum number of agents",
+				zerolog.InfoLevel,
+			},
+		},
+	}
+
+	// Checked before the table as it wraps ErrAgentNotFound.
+	// The response is the one of ErrAgentNotFound, that agents already re-enroll on, with the configured status code.
+	var auErr *AgentUnknownError
+	if errors.As(err, &auErr) {
+		resp := NewHTTPErrResp(ErrAgentNotFound)
+		if auErr.StatusCode != 0 {
+			resp.StatusCode = auErr.StatusCode
+		}
+		return resp
 	}
 
 	for _, e := range errTable {
@@ -484,6 +532,17 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 		}
 	}
 
+	// Request body exceeded the endpoint's max_body_byte_size limit
+	var mbErr *http.MaxBytesError
+	if errors.As(err, &mbErr) {
+		return HTTPErrResp{
+			http.StatusRequestEntityTooLarge,
+			"RequestEntityTooLarge",
+			fmt.Sprintf("request body exceeds the limit of %d bytes", mbErr.Limit),
+			zerolog.InfoLevel,
+		}
+	}
+
 	// Check if we have encountered a connectivity error
 	// Predicate taken from https://github.com/golang/go/blob/go1.17.5/src/net/dial_test.go#L798
 	if strings.Contains(err.Error(), "connection refused") {
@@ -495,6 +554,27 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 		}
 	}
 
+	// Elasticsearch is shedding load or unavailable
+	esErr := &es.ErrElastic{}
+	if errors.As(err, &esErr) {
+		switch esErr.Status {
+		case http.StatusTooManyRequests:
+			return HTTPErrResp{
+				http.StatusTooManyRequests,
+				"TooManyRequests",
+				"Elasticsearch is overloaded",
+				zerolog.WarnLevel,
+			}
+		case http.StatusServiceUnavailable:
+			return HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"ServiceUnavailable",
+				"Elasticsearch is unavailable",
+				zerolog.WarnLevel,
+			}
+		}
+	}
+
 	// Default
 	return HTTPErrResp{
 		StatusCode: http.StatusInternalServerError,
@@ -504,14 +584,36 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 	}
 }
 
+// defaultRetryAfter is the Retry-After delay used when the request did not pass through the limiter middleware.
+const defaultRetryAfter = 5 * time.Second
+
+type retryAfterKey struct{}
+
+// withRetryAfter returns a copy of ctx carrying the configured Retry-After delay.
+func withRetryAfter(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, retryAfterKey{}, d)
+}
+
+// retryAfterFromContext returns the Retry-After delay carried by ctx, or defaultRetryAfter.
+func retryAfterFromContext(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(retryAfterKey{}).(time.Duration); ok {
+		return d
+	}
+	return defaultRetryAfter
+}
+
 // Write will serialize the ErrResp to an http response and include the proper headers.
-func (er HTTPErrResp) Write(w http.ResponseWriter) error {
+// retryAfter is advertised in the Retry-After header of 429 and 503 responses.
+func (er HTTPErrResp) Write(w http.ResponseWriter, retryAfter time.Duration) error {
 	data, err := json.Marshal(&er)
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if er.StatusCode == http.StatusTooManyRequests || er.StatusCode == http.StatusServiceUnavailable {
+		limit.SetRetryAfter(w.Header(), retryAfter)
+	}
 	w.WriteHeader(er.StatusCode)
 	_, err = w.Write(data)
 	return err
@@ -543,7 +645,7 @@ func ErrorResp(w http.ResponseWriter, r *http.Request, err error) {
 		apm.CaptureError(r.Context(), err).Send()
 	}
 
-	if rerr := resp.Write(w); rerr != nil {
+	if rerr := resp.Write(w, retryAfterFromContext(r.Context())); rerr != nil {
 		zlog.Error().Err(rerr).Msg("fail writing error response")
 	}
 }
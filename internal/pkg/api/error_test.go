@@ -6,12 +6,15 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 	"github.com/stretchr/testify/require"
 	"go.elastic.co/apm/v2"
@@ -92,3 +95,76 @@ func Test_ErrorResp_NoTransaction(t *testing.T) {
 	require.Len(t, payloads.Transactions, 0)
 	require.Len(t, payloads.Errors, 0)
 }
+
+func Test_ErrorResp_Backpressure(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{{
+		name:       "rate limit",
+		err:        limit.ErrRateLimit,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "5",
+	}, {
+		name:       "max limit",
+		err:        limit.ErrMaxLimit,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "5",
+	}, {
+		name:       "throttle",
+		err:        ErrorThrottle,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "5",
+	}, {
+		name:       "elasticsearch rejected execution",
+		err:        fmt.Errorf("wrapped: %w", &es.ErrElastic{Status: http.StatusTooManyRequests}),
+		status:     http.StatusTooManyRequests,
+		retryAfter: "5",
+	}, {
+		name:       "elasticsearch connection refused",
+		err:        errors.New("dial tcp 127.0.0.1:9200: connect: connection refused"),
+		status:     http.StatusServiceUnavailable,
+		retryAfter: "5",
+	}, {
+		name:       "elasticsearch unavailable",
+		err:        &es.ErrElastic{Status: http.StatusServiceUnavailable},
+		status:     http.StatusServiceUnavailable,
+		retryAfter: "5",
+	}, {
+		name:   "generic error",
+		err:    errors.New("generic error"),
+		status: http.StatusInternalServerError,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := testlog.SetLogger(t)
+			wr := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(logger.WithContext(context.Background()), "GET", "http://localhost", nil)
+			require.NoError(t, err)
+
+			ErrorResp(wr, req, tc.err)
+			resp := wr.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tc.status, resp.StatusCode)
+			require.Equal(t, tc.retryAfter, resp.Header.Get("Retry-After"))
+		})
+	}
+
+	t.Run("configured retry after", func(t *testing.T) {
+		logger := testlog.SetLogger(t)
+		ctx := withRetryAfter(logger.WithContext(context.Background()), 42*time.Second)
+		for _, err := range []error{ErrorThrottle, &es.ErrElastic{Status: http.StatusServiceUnavailable}} {
+			wr := httptest.NewRecorder()
+			req, rErr := http.NewRequestWithContext(ctx, "GET", "http://localhost", nil)
+			require.NoError(t, rErr)
+
+			ErrorResp(wr, req, err)
+			resp := wr.Result()
+			resp.Body.Close()
+			require.Equal(t, "42", resp.Header.Get("Retry-After"))
+		}
+	})
+}
@@ -175,6 +175,7 @@ func eventToActionResult(agentID, aType string, ev AckRequest_Events_Item) (acr
 			AgentID:   agentID,
 			Data:      p,
 			Error:     fromPtr(event.Error),
+			ErrorCode: ackErrorCode(event.Error, event.ErrorCode),
 			Timestamp: event.Timestamp.Format(time.RFC3339Nano),
 		}
 	case string(INPUTACTION):
@@ -188,6 +189,7 @@ func eventToActionResult(agentID, aType string, ev AckRequest_Events_Item) (acr
 			ActionData:      event.ActionData,
 			ActionResponse:  event.ActionResponse,
 			Error:           fromPtr(event.Error),
+			ErrorCode:       ackErrorCode(event.Error, event.ErrorCode),
 			Timestamp:       event.Timestamp.Format(time.RFC3339Nano),
 		}
 	default: // UPGRADE action acks are also handled by handelUpgrade (deprecated func)
@@ -196,11 +198,20 @@ func eventToActionResult(agentID, aType string, ev AckRequest_Events_Item) (acr
 			ActionID:  event.ActionId,
 			AgentID:   agentID,
 			Error:     fromPtr(event.Error),
+			ErrorCode: ackErrorCode(event.Error, event.ErrorCode),
 			Timestamp: event.Timestamp.Format(time.RFC3339Nano),
 		}
 	}
 }
 
+// ackErrorCode returns the reason code of a failed ack, the code of a successful ack is dropped.
+func ackErrorCode(errMsg, code *string) string {
+	if fromPtr(errMsg) == "" {
+		return ""
+	}
+	return fromPtr(code)
+}
+
 // handleAckEvents can return:
 // 1. AckResponse and nil error, when the whole request is successful
 // 2. AckResponse and non-nil error, when the request items had errors
@@ -80,6 +80,27 @@ func TestEventToActionResult(t *testing.T) {
 		assert.Equal(t, "2022-02-23T18:26:08.506128Z", r.Timestamp)
 		assert.Equal(t, "error message", r.Error)
 	})
+	t.Run("with error code", func(t *testing.T) {
+		r := eventToActionResult(agentID, "UPGRADE", AckRequest_Events_Item{json.RawMessage(`{
+		"action_id": "test-action-id",
+		"message": "action message",
+		"timestamp": "2022-02-23T18:26:08.506128Z",
+		"error": "failed to download the artifact",
+		"error_code": "DOWNLOAD_FAILED"
+	    }`)})
+		assert.Equal(t, "failed to download the artifact", r.Error)
+		assert.Equal(t, "DOWNLOAD_FAILED", r.ErrorCode)
+	})
+	t.Run("error code without error", func(t *testing.T) {
+		r := eventToActionResult(agentID, "INPUT_ACTION", AckRequest_Events_Item{json.RawMessage(`{
+		"action_id": "test-action-id",
+		"message": "action message",
+		"timestamp": "2022-02-23T18:26:08.506128Z",
+		"error_code": "DOWNLOAD_FAILED"
+	    }`)})
+		assert.Empty(t, r.Error)
+		assert.Empty(t, r.ErrorCode)
+	})
 	t.Run("request diagnostics", func(t *testing.T) {
 		r := eventToActionResult(agentID, "REQUEST_DIAGNOSTICS", AckRequest_Events_Item{json.RawMessage(`{
 		"action_id": "test-action-id",
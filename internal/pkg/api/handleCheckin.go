@@ -9,6 +9,8 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,6 +18,7 @@ import (
 	"math/rand"
 	"net/http"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +32,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 
 	"github.com/hashicorp/go-version"
 	"github.com/miolini/datacounter"
@@ -36,6 +40,7 @@ import (
 
 	"go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/v2"
+	"golang.org/x/sync/semaphore"
 )
 
 var (
@@ -43,12 +48,27 @@ var (
 	ErrNoPolicyOutput         = errors.New("output section not found")
 	ErrFailInjectAPIKey       = errors.New("failure to inject api key")
 	ErrInvalidUpgradeMetadata = errors.New("invalid upgrade metadata")
+	ErrClockSkew              = errors.New("agent clock skew exceeds the allowed maximum")
 )
 
 const (
 	kEncodingGzip = "gzip"
 )
 
+// AgentUnknownError is returned by checkin when the agent record no longer exists, for example after it was deleted.
+// It tells the agent to re-enroll and carries the status code configured with server.checkin.unknown_agent_status.
+type AgentUnknownError struct {
+	StatusCode int
+}
+
+func (e *AgentUnknownError) Error() string {
+	return "agent unknown, re-enroll required"
+}
+
+func (e *AgentUnknownError) Unwrap() error {
+	return ErrAgentNotFound
+}
+
 // validActionTypes is a map of action.type and if they are valid
 // unlisted or invalid types are removed with filterActions().
 // action types should have a corresponding case in convertActionData.
@@ -77,6 +97,12 @@ type CheckinT struct {
 	// effectiveness of the pool is controlled by rate limiter configured through the limit.action_limit attribute.
 	gwPool sync.Pool
 	bulker bulk.Bulk
+
+	// policyLimit bounds the number of concurrent policy assemblies, nil if unlimited.
+	policyLimit *semaphore.Weighted
+
+	// pendingKeys are the replacement access API keys not yet used by their agents.
+	pendingKeys *pendingAccessAPIKeys
 }
 
 func NewCheckinT(
@@ -108,7 +134,11 @@ func NewCheckinT(
 				return zipper
 			},
 		},
-		bulker: bulker,
+		bulker:      bulker,
+		pendingKeys: newPendingAccessAPIKeys(),
+	}
+	if cfg.Checkin.PolicyConcurrency > 0 {
+		ct.policyLimit = semaphore.NewWeighted(int64(cfg.Checkin.PolicyConcurrency))
 	}
 
 	return ct
@@ -118,6 +148,9 @@ func (ct *CheckinT) handleCheckin(zlog zerolog.Logger, w http.ResponseWriter, r
 	start := time.Now()
 
 	agent, err := authAgent(r, &id, ct.bulker, ct.cache)
+	if errors.Is(err, ErrAgentNotFound) {
+		return &AgentUnknownError{StatusCode: ct.cfg.Checkin.UnknownAgentStatus}
+	}
 	if err != nil {
 		return err
 	}
@@ -136,6 +169,22 @@ func (ct *CheckinT) handleCheckin(zlog zerolog.Logger, w http.ResponseWriter, r
 	return ct.ProcessRequest(zlog, w, r, start, agent, newVer)
 }
 
+// checkClockSkew returns ErrClockSkew if the agent reported timestamp differs from now by more than maxSkew.
+// Checkins without a timestamp, or a maxSkew of 0, are always accepted.
+func checkClockSkew(reported *time.Time, now time.Time, maxSkew time.Duration) error {
+	if reported == nil || maxSkew <= 0 {
+		return nil
+	}
+	skew := now.Sub(*reported)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return fmt.Errorf("%w: agent clock is %s off", ErrClockSkew, skew.Round(time.Second))
+	}
+	return nil
+}
+
 // validatedCheckin is a struct to wrap all the things that validateRequest returns.
 type validatedCheckin struct {
 	req     *CheckinRequest
@@ -196,6 +245,14 @@ func (ct *CheckinT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter,
 	}
 	zlog.Trace().Dur("pollDuration", pollDuration).Msg("Request poll duration set.")
 
+	if err := checkClockSkew(req.Timestamp, start, ct.cfg.Checkin.MaxClockSkew); err != nil {
+		if ct.cfg.Checkin.ClockSkewAction == config.ClockSkewActionReject {
+			zlog.Warn().Err(err).Time("agent_timestamp", *req.Timestamp).Msg("Rejecting checkin with clock skew.")
+			return val, err
+		}
+		zlog.Warn().Err(err).Time("agent_timestamp", *req.Timestamp).Msg("Checkin with clock skew.")
+	}
+
 	// Compare local_metadata content and update if different
 	rawMeta, err := parseMeta(zlog, agent, &req)
 	if err != nil {
@@ -224,6 +281,13 @@ func (ct *CheckinT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter,
 }
 
 func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, start time.Time, agent *model.Agent, ver string) error {
+	otelCtx, otelSpan := telemetry.Start(r.Context(), "checkin",
+		telemetry.AttrAgentID.String(agent.Id),
+		telemetry.AttrPolicyID.String(agent.PolicyID),
+	)
+	defer otelSpan.End()
+	r = r.WithContext(otelCtx)
+
 	validated, err := ct.validateRequest(zlog, w, r, start, agent)
 	if err != nil {
 		return err
@@ -240,6 +304,12 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 		return fmt.Errorf("failed to update upgrade_details: %w", err)
 	}
 
+	// Replace the access API key before it expires, the new key is returned in the checkin response.
+	accessAPIKey, err := ct.rotateAccessAPIKey(r.Context(), zlog, r, agent, ver, start)
+	if err != nil {
+		zlog.Warn().Err(err).Msg("unable to rotate access api key")
+	}
+
 	// Subscribe to actions dispatcher
 	aSub := ct.ad.Subscribe(agent.Id, seqno)
 	defer ct.ad.Unsubscribe(aSub)
@@ -277,7 +347,7 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	defer longPoll.Stop()
 
 	// Initial update on checkin, and any user fields that might have changed
-	err = ct.bc.CheckIn(agent.Id, string(req.Status), req.Message, rawMeta, rawComponents, seqno, ver)
+	err = ct.bc.CheckIn(r.Context(), agent.Id, string(req.Status), req.Message, rawMeta, rawComponents, seqno, ver)
 	if err != nil {
 		zlog.Error().Err(err).Str("agent_id", agent.Id).Msg("checkin failed")
 	}
@@ -296,6 +366,7 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	pendingActions = filterActions(zlog, agent.Id, pendingActions)
 	actions, ackToken = convertActions(zlog, agent.Id, pendingActions)
 
+	var pollTimeoutReached bool
 	span, ctx := apm.StartSpan(r.Context(), "longPoll", "process")
 	if len(actions) == 0 {
 	LOOP:
@@ -311,18 +382,20 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 				actions = append(actions, acs...)
 				break LOOP
 			case policy := <-sub.Output():
-				actionResp, err := processPolicy(ctx, zlog, ct.bulker, agent.Id, policy)
+				actionResp, etag, err := ct.assemblePolicy(ctx, zlog, ct.bulker, agent.Id, policy, r.Header.Get("If-None-Match"))
 				if err != nil {
 					span.End()
 					return fmt.Errorf("processPolicy: %w", err)
 				}
+				w.Header().Set("ETag", etag)
 				actions = append(actions, *actionResp)
 				break LOOP
 			case <-longPoll.C:
 				zlog.Trace().Msg("fire long poll")
+				pollTimeoutReached = true
 				break LOOP
 			case <-tick.C:
-				err := ct.bc.CheckIn(agent.Id, string(req.Status), req.Message, nil, rawComponents, nil, ver)
+				err := ct.bc.CheckIn(r.Context(), agent.Id, string(req.Status), req.Message, nil, rawComponents, nil, ver)
 				if err != nil {
 					zlog.Error().Err(err).Str("agent_id", agent.Id).Msg("checkin failed")
 				}
@@ -332,9 +405,11 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	span.End()
 
 	resp := CheckinResponse{
-		AckToken: &ackToken,
-		Action:   "checkin",
-		Actions:  &actions,
+		AccessApiKey:       accessAPIKey,
+		AckToken:           &ackToken,
+		Action:             "checkin",
+		Actions:            &actions,
+		PollTimeoutReached: &pollTimeoutReached,
 	}
 
 	return ct.writeResponse(zlog, w, r, agent, resp)
@@ -590,9 +665,12 @@ func (ct *CheckinT) resolveSeqNo(ctx context.Context, zlog zerolog.Logger, req C
 }
 
 func (ct *CheckinT) fetchAgentPendingActions(ctx context.Context, seqno sqn.SeqNo, agentID string) ([]model.Action, error) {
-	actions, err := dl.FindAgentActions(ctx, ct.bulker, seqno, ct.gcp.GetCheckpoint(), agentID)
+	ctx, span := telemetry.Start(ctx, "actions query", telemetry.AttrAgentID.String(agentID))
+	defer span.End()
+	actions, err := dl.FindAgentActions(ctx, ct.bulker, seqno, ct.gcp.GetCheckpoint(), agentID, ct.cfg.Checkin.MaxActions)
 
 	if err != nil {
+		span.RecordError(err)
 		return nil, fmt.Errorf("fetchAgentPendingActions: %w", err)
 	}
 
@@ -717,10 +795,26 @@ func convertActions(zlog zerolog.Logger, agentID string, actions []model.Action)
 	return respList, ackToken
 }
 
+// assemblePolicy calls processPolicy once the number of concurrent policy assemblies is below the configured limit.
+// The admin endpoints pass a dryRunBulk as bulker, they count against the same limit as checkins.
+func (ct *CheckinT) assemblePolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agentID string, pp *policy.ParsedPolicy, ifNoneMatch string) (*Action, string, error) {
+	if ct.policyLimit != nil {
+		if err := ct.policyLimit.Acquire(ctx, 1); err != nil {
+			return nil, "", err
+		}
+		defer ct.policyLimit.Release(1)
+	}
+	return processPolicy(ctx, zlog, bulker, agentID, pp, ifNoneMatch)
+}
+
 // A new policy exists for this agent.  Perform the following:
 //   - Generate and update default ApiKey if roles have changed.
 //   - Rewrite the policy for delivery to the agent injecting the key material.
-func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agentID string, pp *policy.ParsedPolicy) (*Action, error) {
+//
+// The entity tag of the rewritten policy is returned along with the action. If ifNoneMatch matches it the agent
+// already runs this policy, the action then only carries the policy id and revision so the agent can acknowledge
+// it without the policy body being sent again.
+func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agentID string, pp *policy.ParsedPolicy, ifNoneMatch string) (*Action, string, error) {
 	var links []apm.SpanLink = nil // set to a nil array to preserve default behaviour if no policy links are found
 	if err := pp.Links.Trace.Validate(); err == nil {
 		links = []apm.SpanLink{pp.Links}
@@ -740,18 +834,18 @@ func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, a
 	bSpan.End()
 	if err != nil {
 		zlog.Error().Err(err).Msg("fail find agent record")
-		return nil, err
+		return nil, "", err
 	}
 
 	if len(pp.Policy.Data.Outputs) == 0 {
-		return nil, ErrNoPolicyOutput
+		return nil, "", ErrNoPolicyOutput
 	}
 
 	data := model.ClonePolicyData(pp.Policy.Data)
 	for policyName, policyOutput := range data.Outputs {
 		err := policy.ProcessOutputSecret(ctx, policyOutput, bulker)
 		if err != nil {
-			return nil, fmt.Errorf("failed to process output secrets %q: %w",
+			return nil, "", fmt.Errorf("failed to process output secrets %q: %w",
 				policyName, err)
 		}
 	}
@@ -759,27 +853,37 @@ func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, a
 	for _, policyOutput := range pp.Outputs {
 		err = policyOutput.Prepare(ctx, zlog, bulker, &agent, data.Outputs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to prepare output %q: %w",
+			return nil, "", fmt.Errorf("failed to prepare output %q: %w",
 				policyOutput.Name, err)
 		}
 	}
 	// Add replace inputs with agent prepared version.
 	data.Inputs = pp.Inputs
 
+	if err := policy.ValidatePolicyData(data); err != nil {
+		zlog.Error().Err(err).Msg("invalid policy, not delivered to agent")
+		return nil, "", err
+	}
+
 	// JSON transformations to turn a model.PolicyData into an Action.data
 	p, err := json.Marshal(data)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	etag := policyETag(p)
 	d := PolicyData{}
-	err = json.Unmarshal(p, &d)
-	if err != nil {
-		return nil, err
+	if etagMatches(ifNoneMatch, etag) {
+		zlog.Debug().Str("etag", etag).Msg("agent policy is current, omitting policy body")
+		revision := int(pp.Policy.RevisionIdx)
+		d.Id = &pp.Policy.PolicyID
+		d.Revision = &revision
+	} else if err := json.Unmarshal(p, &d); err != nil {
+		return nil, "", err
 	}
 	ad := Action_Data{}
 	err = ad.FromActionPolicyChange(ActionPolicyChange{d})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	r := policy.RevisionFromPolicy(pp.Policy)
@@ -791,13 +895,35 @@ func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, a
 		Type:      POLICYCHANGE,
 	}
 
-	return &resp, nil
+	return &resp, etag, nil
+}
+
+// policyETag returns the strong entity tag of the policy data delivered to an agent.
+func policyETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header value ifNoneMatch lists etag.
+// Weak comparison is used as the header only tells which policy the agent already runs.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" && tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
 }
 
 func findAgentByAPIKeyID(ctx context.Context, bulker bulk.Bulk, id string) (*model.Agent, error) {
 	span, ctx := apm.StartSpan(ctx, "findAgentByID", "search")
 	defer span.End()
 	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByAssessAPIKeyID, dl.FieldAccessAPIKeyID, id)
+	if errors.Is(err, dl.ErrNotFound) {
+		// The agent may check in with the key that replaces its expiring access API key.
+		agent, err = dl.FindAgent(ctx, bulker, dl.QueryAgentByPendingAccessAPIKeyID, dl.FieldPendingAccessAPIKeyID, id)
+	}
 	if err != nil {
 		if errors.Is(err, dl.ErrNotFound) {
 			err = ErrAgentNotFound
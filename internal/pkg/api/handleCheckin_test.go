@@ -9,12 +9,20 @@ package api
 import (
 	"compress/flate"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
@@ -24,14 +32,20 @@ import (
 	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
 	"github.com/hashicorp/go-version"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestConvertActionData(t *testing.T) {
@@ -685,3 +699,538 @@ func mustBuildConstraints(verStr string) version.Constraints {
 	}
 	return con
 }
+
+func TestFetchAgentPendingActionsOTelSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+
+	ct := NewCheckinT(mustBuildConstraints("8.0.0"), &config.Server{}, nil, nil, nil, gcp, nil, nil, bulker)
+
+	parentCtx, parent := telemetry.Start(ctx, "checkin", telemetry.AttrAgentID.String("agent-1"))
+	_, err := ct.fetchAgentPendingActions(parentCtx, sqn.SeqNo{0}, "agent-1")
+	parent.End()
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "actions query", spans[0].Name)
+	require.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Contains(t, spans[0].Attributes, telemetry.AttrAgentID.String("agent-1"))
+	bulker.AssertExpectations(t)
+}
+
+func TestFetchAgentPendingActionsWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxActions int
+		size       float64
+	}{{
+		name: "default window",
+		size: 100,
+	}, {
+		name:       "configured window",
+		maxActions: 25,
+		size:       25,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testlog.SetLogger(t).WithContext(context.Background())
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+				var query map[string]interface{}
+				if err := json.Unmarshal(body, &query); err != nil {
+					return false
+				}
+				return query["size"] == tc.size
+			}), mock.Anything).Return(&es.ResultT{}, nil).Once()
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+
+			cfg := &config.Server{}
+			cfg.Checkin.MaxActions = tc.maxActions
+			ct := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, nil, nil, gcp, nil, nil, bulker)
+
+			_, err := ct.fetchAgentPendingActions(ctx, sqn.SeqNo{0}, "agent-1")
+			require.NoError(t, err)
+			bulker.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckinDeletedAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		statusCode int
+	}{{
+		name:       "default status",
+		statusCode: http.StatusNotFound,
+	}, {
+		name:       "configured status",
+		status:     http.StatusGone,
+		statusCode: http.StatusGone,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Server{}
+			cfg.Checkin.InitDefaults()
+			if tc.status != 0 {
+				cfg.Checkin.UnknownAgentStatus = tc.status
+			}
+
+			// The API key is still cached as valid but the agent document was deleted.
+			c := testcache.NewMockCache()
+			c.On("ValidAPIKey", mock.Anything).Return(true)
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+			ct := &CheckinT{cfg: cfg, cache: c, bulker: bulker}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/deleted-agent/checkin", nil)
+			r.Header.Set("Authorization", "ApiKey "+base64.StdEncoding.EncodeToString([]byte("key-id:key")))
+			err := ct.handleCheckin(testlog.SetLogger(t), httptest.NewRecorder(), r, "deleted-agent", "elastic agent 8.9.0")
+
+			var auErr *AgentUnknownError
+			require.ErrorAs(t, err, &auErr)
+			require.ErrorIs(t, err, ErrAgentNotFound)
+
+			resp := NewHTTPErrResp(err)
+			require.Equal(t, tc.statusCode, resp.StatusCode)
+			require.Equal(t, "AgentNotFound", resp.Error)
+			require.Equal(t, "agent could not be found", resp.Message)
+			bulker.AssertExpectations(t)
+		})
+	}
+}
+
+// pollPolicyMonitor hands out subscriptions that never output a policy.
+type pollPolicyMonitor struct{}
+
+type pollPolicySub struct{}
+
+func (pollPolicySub) Output() <-chan *policy.ParsedPolicy { return nil }
+
+func (pollPolicyMonitor) Run(context.Context) error { return nil }
+
+func (pollPolicyMonitor) Subscribe(string, string, int64, int64) (policy.Subscription, error) {
+	return pollPolicySub{}, nil
+}
+
+func (pollPolicyMonitor) Unsubscribe(policy.Subscription) error { return nil }
+
+func TestProcessRequestPollTimeoutReached(t *testing.T) {
+	pendingAction := es.HitT{ID: "1", SeqNo: 1, Source: []byte(`{"action_id":"action-1","type":"UNENROLL","agents":["agent-1"]}`)}
+	tests := []struct {
+		name    string
+		hits    []es.HitT
+		actions int
+		reached bool
+	}{{
+		name:    "pending actions return immediately",
+		hits:    []es.HitT{pendingAction},
+		actions: 1,
+	}, {
+		name:    "long poll timeout",
+		reached: true,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := testlog.SetLogger(t)
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			cfg.Timeouts.CheckinLongPoll = 50 * time.Millisecond
+			cfg.Timeouts.CheckinJitter = 0
+
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: tc.hits}}, nil)
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+			ct := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pollPolicyMonitor{}, gcp, action.NewDispatcher(nil, 0, 0, 0), nil, bulker)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`))
+			r = r.WithContext(logger.WithContext(r.Context()))
+			w := httptest.NewRecorder()
+			agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, PolicyID: "policy-1"}
+			err := ct.ProcessRequest(logger, w, r, time.Now(), agent, "")
+			require.NoError(t, err)
+
+			var resp CheckinResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Len(t, *resp.Actions, tc.actions)
+			require.NotNil(t, resp.PollTimeoutReached)
+			require.Equal(t, tc.reached, *resp.PollTimeoutReached)
+		})
+	}
+}
+
+func TestProcessPolicyETag(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	ctx := logger.WithContext(context.Background())
+
+	pp := &policy.ParsedPolicy{Policy: model.Policy{
+		PolicyID:    "policy-1",
+		RevisionIdx: 2,
+		Data: &model.PolicyData{
+			ID:      "policy-1",
+			Outputs: map[string]map[string]interface{}{"default": {"type": policy.OutputTypeElasticsearch}},
+		},
+	}}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{
+		Hits: []es.HitT{{ID: "agent-1", Source: []byte(`{"policy_id":"policy-1"}`)}},
+	}}, nil)
+
+	policyData := func(a *Action) PolicyData {
+		pc, err := a.Data.AsActionPolicyChange()
+		require.NoError(t, err)
+		return pc.Policy
+	}
+
+	// The first delivery returns the full policy along with its ETag.
+	a, etag, err := processPolicy(ctx, logger, bulker, "agent-1", pp, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, etag)
+	full := policyData(a)
+	require.NotNil(t, full.Outputs)
+
+	// A stale ETag yields the full policy.
+	a, staleEtag, err := processPolicy(ctx, logger, bulker, "agent-1", pp, `"stale"`)
+	require.NoError(t, err)
+	require.Equal(t, etag, staleEtag)
+	require.Equal(t, full, policyData(a))
+
+	// A matching ETag only confirms the current policy.
+	a, _, err = processPolicy(ctx, logger, bulker, "agent-1", pp, `W/"other", `+etag)
+	require.NoError(t, err)
+	require.Equal(t, POLICYCHANGE, a.Type)
+	current := policyData(a)
+	require.Nil(t, current.Outputs)
+	require.Nil(t, current.Inputs)
+	require.Equal(t, "policy-1", *current.Id)
+	require.Equal(t, 2, *current.Revision)
+}
+
+// concurrencyBulk blocks agent searches until released and records how many run at once.
+type concurrencyBulk struct {
+	*ftesting.MockBulk
+	release chan struct{}
+
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (b *concurrencyBulk) Search(ctx context.Context, index string, body []byte, opts ...bulk.Opt) (*es.ResultT, error) {
+	b.mu.Lock()
+	b.running++
+	if b.running > b.peak {
+		b.peak = b.running
+	}
+	b.mu.Unlock()
+
+	<-b.release
+
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1", Source: []byte(`{"policy_id":"policy-1"}`)}}}}, nil
+}
+
+func TestAssemblePolicyConcurrency(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	ctx := logger.WithContext(context.Background())
+
+	const limit, checkins = 2, 6
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Checkin.PolicyConcurrency = limit
+
+	bulker := &concurrencyBulk{MockBulk: ftesting.NewMockBulk(), release: make(chan struct{})}
+	ct := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, nil, nil, nil, nil, nil, bulker)
+	pp := &policy.ParsedPolicy{Policy: model.Policy{
+		PolicyID: "policy-1",
+		Data: &model.PolicyData{
+			ID:      "policy-1",
+			Outputs: map[string]map[string]interface{}{"default": {"type": policy.OutputTypeElasticsearch}},
+		},
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < checkins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := ct.assemblePolicy(ctx, logger, bulker, "agent-1", pp, "")
+			assert.NoError(t, err)
+		}()
+	}
+
+	running := func() int {
+		bulker.mu.Lock()
+		defer bulker.mu.Unlock()
+		return bulker.running
+	}
+	for i := 0; i < checkins; i++ {
+		// Wait until the assemblies fill all the slots, or the ones left, before letting one finish.
+		want := limit
+		if left := checkins - i; left < limit {
+			want = left
+		}
+		require.Eventually(t, func() bool { return running() == want }, time.Second, time.Millisecond)
+		bulker.release <- struct{}{}
+	}
+	wg.Wait()
+	require.Equal(t, limit, bulker.peak)
+}
+
+func TestCheckinClockSkew(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		ts := now.Add(d)
+		return &ts
+	}
+	tests := []struct {
+		name      string
+		timestamp *time.Time
+		maxSkew   time.Duration
+		rejected  bool
+	}{
+		{"no timestamp", nil, time.Minute, false},
+		{"check disabled", at(-time.Hour), 0, false},
+		{"within threshold", at(-30 * time.Second), time.Minute, false},
+		{"agent behind beyond threshold", at(-2 * time.Minute), time.Minute, true},
+		{"agent ahead beyond threshold", at(2 * time.Minute), time.Minute, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkClockSkew(tc.timestamp, now, tc.maxSkew)
+			if !tc.rejected {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrClockSkew)
+			resp := NewHTTPErrResp(err)
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			require.Equal(t, "ClockSkew", resp.Error)
+			require.NotEmpty(t, resp.Message)
+		})
+	}
+
+	// The check runs as part of the request validation, skewed checkins are only logged by default.
+	logger := testlog.SetLogger(t)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Checkin.MaxClockSkew = time.Minute
+	ct := &CheckinT{cfg: cfg}
+	body := fmt.Sprintf(`{"status":"online","message":"healthy","timestamp":%q}`, now.Add(-time.Hour).Format(time.RFC3339))
+	validate := func() error {
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(body))
+		_, err := ct.validateRequest(logger, httptest.NewRecorder(), r, now, &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}})
+		return err
+	}
+	require.NoError(t, validate())
+
+	cfg.Checkin.ClockSkewAction = config.ClockSkewActionReject
+	require.ErrorIs(t, validate(), ErrClockSkew)
+}
+
+func TestProcessRequestAccessAPIKeyRotation(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Timeouts.CheckinLongPoll = 50 * time.Millisecond
+	cfg.Timeouts.CheckinJitter = 0
+	cfg.AccessAPIKey = config.AccessAPIKey{Expiration: 30 * 24 * time.Hour, RotateBefore: 24 * time.Hour, MinAgentVersion: "8.16.0"}
+	const ver = "8.16.0"
+
+	oldKey := apikey.APIKey{ID: "old", Key: "old-secret"}
+	newKey := apikey.APIKey{ID: "new", Key: "new-secret"}
+	updated := func(fields map[string]interface{}) interface{} {
+		return mock.MatchedBy(func(body []byte) bool {
+			var doc struct {
+				Doc map[string]interface{} `json:"doc"`
+			}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				return false
+			}
+			for k, v := range fields {
+				if actual, ok := doc.Doc[k]; !ok || actual != v {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+	ct := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pollPolicyMonitor{}, gcp, action.NewDispatcher(nil, 0, 0, 0), nil, bulker)
+
+	checkinWith := func(key apikey.APIKey, agent *model.Agent) CheckinResponse {
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online"}`))
+		r.Header.Set("Authorization", "ApiKey "+key.Token())
+		r = r.WithContext(logger.WithContext(r.Context()))
+		w := httptest.NewRecorder()
+		require.NoError(t, ct.ProcessRequest(logger, w, r, time.Now(), agent, ver))
+		var resp CheckinResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// A key far from its expiration is kept.
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", nil)
+	r.Header.Set("Authorization", "ApiKey "+oldKey.Token())
+	token, err := ct.rotateAccessAPIKey(context.Background(), logger, r, &model.Agent{
+		ESDocument:             model.ESDocument{Id: "agent-1"},
+		AccessAPIKeyID:         oldKey.ID,
+		AccessAPIKeyExpiration: time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339),
+	}, ver, time.Now())
+	require.NoError(t, err)
+	require.Nil(t, token)
+
+	// The keys of agents not reading the key delivered on checkin are never rotated.
+	token, err = ct.rotateAccessAPIKey(context.Background(), logger, r, &model.Agent{
+		ESDocument:             model.ESDocument{Id: "agent-1"},
+		AccessAPIKeyID:         oldKey.ID,
+		AccessAPIKeyExpiration: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}, "8.15.0", time.Now())
+	require.NoError(t, err)
+	require.Nil(t, token)
+
+	// A key nearing its expiration is replaced, the new key is returned in the checkin response.
+	agent := &model.Agent{
+		ESDocument:             model.ESDocument{Id: "agent-1"},
+		PolicyID:               "policy-1",
+		AccessAPIKeyID:         oldKey.ID,
+		AccessAPIKeyExpiration: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+	bulker.On("APIKeyCreate", mock.Anything, "agent-1", "2592000s", mock.Anything, mock.Anything).Return(&newKey, nil).Once()
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", updated(map[string]interface{}{
+		dl.FieldPendingAccessAPIKeyID: newKey.ID,
+	}), mock.Anything).Return(nil).Once()
+	resp := checkinWith(oldKey, agent)
+	require.NotNil(t, resp.AccessApiKey)
+	require.Equal(t, newKey.Token(), *resp.AccessApiKey)
+	require.True(t, *resp.PollTimeoutReached)
+	require.Equal(t, oldKey.ID, agent.AccessAPIKeyID)
+	require.Equal(t, newKey.ID, agent.PendingAccessAPIKeyID)
+
+	// A checkin with the previous key again is delivered the same replacement key.
+	resp = checkinWith(oldKey, agent)
+	require.NotNil(t, resp.AccessApiKey)
+	require.Equal(t, newKey.Token(), *resp.AccessApiKey)
+	bulker.AssertNumberOfCalls(t, "APIKeyCreate", 1)
+
+	// A replacement key recently issued by another server is not replaced.
+	other := &CheckinT{cfg: cfg, bulker: bulker, pendingKeys: newPendingAccessAPIKeys()}
+	token, err = other.rotateAccessAPIKey(context.Background(), logger, r, &model.Agent{
+		ESDocument:                    model.ESDocument{Id: "agent-1"},
+		AccessAPIKeyID:                oldKey.ID,
+		AccessAPIKeyExpiration:        agent.AccessAPIKeyExpiration,
+		PendingAccessAPIKeyID:         "other",
+		PendingAccessAPIKeyExpiration: time.Now().Add(cfg.AccessAPIKey.Expiration - time.Hour).UTC().Format(time.RFC3339),
+	}, ver, time.Now())
+	require.NoError(t, err)
+	require.Nil(t, token)
+	bulker.AssertNumberOfCalls(t, "APIKeyCreate", 1)
+
+	// The first checkin with the new key makes it the access API key and invalidates the previous one.
+	pendingExpiration := agent.PendingAccessAPIKeyExpiration
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", updated(map[string]interface{}{
+		dl.FieldAccessAPIKeyID:                newKey.ID,
+		dl.FieldAccessAPIKeyExpiration:        pendingExpiration,
+		dl.FieldPendingAccessAPIKeyID:         nil,
+		dl.FieldPendingAccessAPIKeyExpiration: nil,
+	}), mock.Anything).Return(nil).Once()
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{oldKey.ID}).Return(nil).Once()
+	resp = checkinWith(newKey, agent)
+	require.Nil(t, resp.AccessApiKey)
+	require.Equal(t, newKey.ID, agent.AccessAPIKeyID)
+	require.Equal(t, pendingExpiration, agent.AccessAPIKeyExpiration)
+	require.Empty(t, agent.PendingAccessAPIKeyID)
+	bulker.AssertExpectations(t)
+}
+
+func TestProcessRequestOTelSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	prevPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	logger := testlog.SetLogger(t)
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	defer cancel()
+
+	// A real bulker, so the Elasticsearch requests of the checkin are traced, on top of a mock transport.
+	client, mocktrans := esutil.MockESClient(t)
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		// The actions are searched once the checkpoint is reached.
+		assert.Equal(t, "/_fleet/_fleet_msearch", r.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"responses":[{"status":200,"hits":{"hits":[` +
+				`{"_id":"1","_seq_no":1,"_source":{"action_id":"action-1","type":"UNENROLL","agents":["agent-1"]}}]}}]}`)),
+			Header: http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	bulker := bulk.NewBulker(client, nil, bulk.WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+	ct := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pollPolicyMonitor{}, gcp, action.NewDispatcher(nil, 0, 0, 0), nil, bulker)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`))
+	r = r.WithContext(ctx)
+	// The trace of the agent is continued.
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, PolicyID: "policy-1"}
+	telemetry.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, ct.ProcessRequest(logger, w, r, time.Now(), agent, ""))
+	})).ServeHTTP(w, r)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	parent, ok := spans["checkin"]
+	require.True(t, ok, "checkin span")
+	require.True(t, parent.Parent.IsRemote())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", parent.SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", parent.Parent.SpanID().String())
+	require.Contains(t, parent.Attributes, telemetry.AttrAgentID.String("agent-1"))
+
+	query, ok := spans["actions query"]
+	require.True(t, ok, "actions query span")
+	require.Equal(t, parent.SpanContext.SpanID(), query.Parent.SpanID())
+
+	search, ok := spans["Bulker: search"]
+	require.True(t, ok, "Elasticsearch search span")
+	require.Equal(t, parent.SpanContext.TraceID(), search.SpanContext.TraceID())
+	require.Equal(t, query.SpanContext.SpanID(), search.Parent.SpanID())
+	require.Contains(t, search.Attributes, telemetry.AttrIndex.String(dl.FleetActions))
+}
@@ -6,12 +6,15 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/elastic/elastic-agent-libs/str"
@@ -20,13 +23,14 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/idgen"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	"go.elastic.co/apm/v2"
 
-	"github.com/gofrs/uuid"
 	"github.com/hashicorp/go-version"
 	"github.com/miolini/datacounter"
 	"github.com/rs/zerolog"
@@ -57,6 +61,7 @@ var (
 	ErrUnknownEnrollType     = errors.New("unknown enroll request type")
 	ErrInactiveEnrollmentKey = errors.New("inactive enrollment key")
 	ErrPolicyNotFound        = errors.New("policy not found")
+	ErrPolicyAgentLimit      = errors.New("policy agent limit reached")
 )
 
 type EnrollerT struct {
@@ -64,15 +69,43 @@ type EnrollerT struct {
 	cfg    *config.Server
 	bulker bulk.Bulk
 	cache  cache.Cache
+	idGen  idgen.Generator
+
+	// policyLocks serializes the enrollments in each capped policy, guarded by policyMu.
+	policyMu    sync.Mutex
+	policyLocks map[string]*sync.Mutex
+
+	// recent deduplicates retried enrollments, nil if disabled.
+	recent *recentEnrollments
 }
 
-func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache) (*EnrollerT, error) {
-	return &EnrollerT{
+// EnrollerOpt is a functional option used to configure an EnrollerT.
+type EnrollerOpt func(*EnrollerT)
+
+// WithAgentIDGenerator sets the generator of the IDs of enrolled agents, it defaults to idgen.Default.
+func WithAgentIDGenerator(g idgen.Generator) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.idGen = g
+	}
+}
+
+func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache, opts ...EnrollerOpt) (*EnrollerT, error) {
+	et := &EnrollerT{
 		verCon: verCon,
 		cfg:    cfg,
 		bulker: bulker,
 		cache:  c,
-	}, nil
+		idGen:  idgen.Default,
+
+		policyLocks: make(map[string]*sync.Mutex),
+	}
+	if cfg.Enroll.DedupWindow > 0 {
+		et.recent = newRecentEnrollments(cfg.Enroll.DedupWindow)
+	}
+	for _, opt := range opts {
+		opt(et)
+	}
+	return et, nil
 }
 
 func (et *EnrollerT) handleEnroll(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, rb *rollback.Rollback, userAgent string) error {
@@ -123,16 +156,29 @@ func (et *EnrollerT) processRequest(zlog zerolog.Logger, w http.ResponseWriter,
 	}
 
 	readCounter := datacounter.NewReaderCounter(body)
+	digest := sha256.New()
 
 	// Parse the request body
-	req, err := validateRequest(r.Context(), readCounter)
+	req, err := validateRequest(r.Context(), io.TeeReader(readCounter, digest))
 	if err != nil {
 		return nil, err
 	}
 
 	cntEnroll.bodyIn.Add(readCounter.Count())
 
-	return et._enroll(r.Context(), rb, zlog, req, enrollAPI.PolicyID, ver)
+	// Only requests carrying an enrollment_id are deduplicated, hosts sending identical requests without one,
+	// e.g. cloned machines, must not share an agent.
+	if et.recent == nil || req.EnrollmentId == nil || *req.EnrollmentId == "" {
+		return et._enroll(r.Context(), rb, zlog, req, enrollAPI.PolicyID, enrollAPI.APIKeyID, ver)
+	}
+	key := enrollmentAPIKey.ID + "/" + *req.EnrollmentId + "/" + hex.EncodeToString(digest.Sum(nil))
+	resp, retried, err := et.recent.do(r.Context(), key, func() (*EnrollResponse, error) {
+		return et._enroll(r.Context(), rb, zlog, req, enrollAPI.PolicyID, enrollAPI.APIKeyID, ver)
+	})
+	if retried {
+		zlog.Info().Str(LogAgentID, resp.Item.Id).Msg("retried enrollment, returning the recently enrolled agent")
+	}
+	return resp, err
 }
 
 // retrieveStaticTokenEnrollmentToken fetches the enrollment key record from the config static tokens.
@@ -191,6 +237,7 @@ func (et *EnrollerT) _enroll(
 	zlog zerolog.Logger,
 	req *EnrollRequest,
 	policyID,
+	enrollmentKeyID,
 	ver string,
 ) (*EnrollResponse, error) {
 	var agent model.Agent
@@ -198,6 +245,8 @@ func (et *EnrollerT) _enroll(
 
 	span, ctx := apm.StartSpan(ctx, "enroll", "process")
 	defer span.End()
+	ctx, otelSpan := telemetry.Start(ctx, "enroll", telemetry.AttrPolicyID.String(policyID))
+	defer otelSpan.End()
 
 	if req.EnrollmentId != nil {
 		vSpan, vCtx := apm.StartSpan(ctx, "checkEnrollmentID", "validate")
@@ -218,12 +267,12 @@ func (et *EnrollerT) _enroll(
 	now := time.Now()
 
 	// Generate an ID here so we can pre-create the api key and avoid a round trip
-	u, err := uuid.NewV4()
+	agentID, err := et.idGen.NewID()
 	if err != nil {
 		return nil, err
 	}
 
-	agentID := u.String()
+	otelSpan.SetAttributes(telemetry.AttrAgentID.String(agentID))
 	// only delete existing agent if it never checked in
 	if agent.Id != "" && agent.LastCheckin == "" {
 		zlog.Debug().
@@ -252,6 +301,16 @@ func (et *EnrollerT) _enroll(
 		}
 	}
 
+	// Serialize the enrollments in a capped policy on this server, so they are not counted concurrently.
+	agentLimit := et.cfg.AgentLimit(policyID)
+	if agentLimit > 0 {
+		unlock := et.lockPolicy(policyID)
+		defer unlock()
+		if err := checkAgentLimit(ctx, et.bulker, policyID, agentLimit-1); err != nil {
+			return nil, err
+		}
+	}
+
 	// Update the local metadata agent id
 	localMeta, err := updateLocalMetaAgentID(req.Metadata.Local, agentID)
 	if err != nil {
@@ -259,7 +318,7 @@ func (et *EnrollerT) _enroll(
 	}
 
 	// Generate the Fleet Agent access api key
-	accessAPIKey, err := generateAccessAPIKey(ctx, et.bulker, agentID)
+	accessAPIKey, err := generateAccessAPIKey(ctx, et.bulker, agentID, accessAPIKeyTTL(et.cfg.AccessAPIKey, ver))
 	if err != nil {
 		return nil, err
 	}
@@ -270,22 +329,24 @@ func (et *EnrollerT) _enroll(
 	})
 
 	agentData := model.Agent{
-		Active:         true,
-		PolicyID:       policyID,
-		Type:           string(req.Type),
-		EnrolledAt:     now.UTC().Format(time.RFC3339),
-		LocalMetadata:  localMeta,
-		AccessAPIKeyID: accessAPIKey.ID,
-		ActionSeqNo:    []int64{sqn.UndefinedSeqNo},
+		Active:                 true,
+		PolicyID:               policyID,
+		Type:                   string(req.Type),
+		EnrolledAt:             now.UTC().Format(time.RFC3339),
+		LocalMetadata:          localMeta,
+		AccessAPIKeyID:         accessAPIKey.ID,
+		AccessAPIKeyExpiration: accessAPIKeyExpiration(et.cfg.AccessAPIKey, ver, now),
+		ActionSeqNo:            []int64{sqn.UndefinedSeqNo},
 		Agent: &model.AgentMetadata{
 			ID:      agentID,
 			Version: ver,
 		},
-		Tags:         removeDuplicateStr(req.Metadata.Tags),
-		EnrollmentID: enrollmentID,
+		Tags:               removeDuplicateStr(req.Metadata.Tags),
+		EnrollmentID:       enrollmentID,
+		EnrollmentAPIKeyID: enrollmentKeyID,
 	}
 
-	err = createFleetAgent(ctx, et.bulker, agentID, agentData)
+	err = createFleetAgent(ctx, et.bulker, agentID, agentData, et.cfg.Enroll.WaitForActiveShards)
 	if err != nil {
 		return nil, err
 	}
@@ -295,6 +356,15 @@ func (et *EnrollerT) _enroll(
 		return deleteAgent(ctx, zlog, et.bulker, agentID)
 	})
 
+	// Other servers may have enrolled agents in the policy since it was counted, the rollback removes this one if
+	// the limit is now exceeded. Servers racing for the last slots may all reject their enrollment, but never exceed it.
+	if agentLimit > 0 {
+		if err := checkAgentLimit(ctx, et.bulker, policyID, agentLimit); err != nil {
+			zlog.Info().Err(err).Str(LogPolicyID, policyID).Msg("concurrent enrollment exceeded the policy agent limit")
+			return nil, err
+		}
+	}
+
 	resp := EnrollResponse{
 		Action: "created",
 		Item: EnrollResponseItem{
@@ -318,6 +388,34 @@ func (et *EnrollerT) _enroll(
 	return &resp, nil
 }
 
+// lockPolicy locks the enrollments in the policy and returns the function unlocking them.
+func (et *EnrollerT) lockPolicy(policyID string) func() {
+	et.policyMu.Lock()
+	mu, ok := et.policyLocks[policyID]
+	if !ok {
+		mu = &sync.Mutex{}
+		et.policyLocks[policyID] = mu
+	}
+	et.policyMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkAgentLimit returns ErrPolicyAgentLimit if more than allowed active agents are enrolled in the policy.
+func checkAgentLimit(ctx context.Context, bulker bulk.Bulk, policyID string, allowed int64) error {
+	span, ctx := apm.StartSpan(ctx, "checkAgentLimit", "search")
+	defer span.End()
+	count, err := dl.CountActiveAgentsOnPolicy(ctx, bulker, policyID)
+	if err != nil {
+		return err
+	}
+	if count > allowed {
+		return fmt.Errorf("%w: %d active agents in policy %s", ErrPolicyAgentLimit, count, policyID)
+	}
+	return nil
+}
+
 // Helper function to remove duplicate agent tags.
 // Note that this implementation will also sort the tags alphabetically.
 func removeDuplicateStr(strSlice []string) []string {
@@ -330,7 +428,8 @@ func deleteAgent(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, age
 	defer span.End()
 	zlog = zlog.With().Str(LogAgentID, agentID).Logger()
 
-	if err := bulker.Delete(ctx, dl.FleetAgents, agentID); err != nil {
+	// Refreshed so the agent is no longer counted by the policy agent limit.
+	if err := bulker.Delete(ctx, dl.FleetAgents, agentID, bulk.WithRefresh()); err != nil {
 		zlog.Error().Err(err).Msg("agent record failed to delete")
 		return err
 	}
@@ -467,7 +566,8 @@ func updateLocalMetaAgentID(data []byte, agentID string) ([]byte, error) {
 	return data, nil
 }
 
-func createFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, agent model.Agent) error {
+// createFleetAgent creates the agent document, waiting for activeShards shard copies when it is not 0.
+func createFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, agent model.Agent, activeShards int) error {
 	span, ctx := apm.StartSpan(ctx, "createAgent", "create")
 	defer span.End()
 
@@ -476,18 +576,18 @@ func createFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, agent mo
 		return err
 	}
 
-	_, err = bulker.Create(ctx, dl.FleetAgents, id, data, bulk.WithRefresh())
+	_, err = bulker.Create(ctx, dl.FleetAgents, id, data, bulk.WithRefresh(), bulk.WithWaitForActiveShards(activeShards))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func generateAccessAPIKey(ctx context.Context, bulk bulk.Bulk, agentID string) (*apikey.APIKey, error) {
+func generateAccessAPIKey(ctx context.Context, bulk bulk.Bulk, agentID, ttl string) (*apikey.APIKey, error) {
 	return bulk.APIKeyCreate(
 		ctx,
 		agentID,
-		"",
+		ttl,
 		[]byte(kFleetAccessRolesJSON),
 		apikey.NewMetadata(agentID, "", apikey.TypeAccess),
 	)
@@ -9,20 +9,33 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/idgen"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+	"github.com/gofrs/uuid"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRemoveDuplicateStr(t *testing.T) {
@@ -89,13 +102,43 @@ func TestEnroll(t *testing.T) {
 		}, nil)
 	bulker.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
 		"", nil)
-	resp, _ := et._enroll(ctx, rb, zlog, req, "1234", "8.9.0")
+	resp, _ := et._enroll(ctx, rb, zlog, req, "1234", "", "8.9.0")
 
 	if resp.Action != "created" {
 		t.Fatal("enroll failed")
 	}
 }
 
+func TestEnrollIDGenerator(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	req := &EnrollRequest{
+		Type: "PERMANENT",
+		Metadata: EnrollMetadata{
+			UserProvided: []byte("{}"),
+			Local:        []byte("{}"),
+		},
+	}
+	c, _ := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	bulker := ftesting.NewMockBulk()
+	gen := idgen.WithPrefix("tenant-a-", idgen.Default)
+	et, err := NewEnrollerT(mustBuildConstraints("8.9.0"), &config.Server{}, bulker, c, WithAgentIDGenerator(gen))
+	require.NoError(t, err)
+
+	var createdID string
+	bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&apikey.APIKey{ID: "1234", Key: "1234"}, nil)
+	bulker.On("Create", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		createdID = args.String(2)
+	}).Return("", nil)
+
+	resp, err := et._enroll(ctx, &rollback.Rollback{}, zerolog.Ctx(ctx).With().Logger(), req, "policy-1", "", "8.9.0")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp.Item.Id, "tenant-a-"), resp.Item.Id)
+	_, err = uuid.FromString(strings.TrimPrefix(resp.Item.Id, "tenant-a-"))
+	require.NoError(t, err)
+	require.Equal(t, resp.Item.Id, createdID)
+}
+
 func TestEnrollerT_retrieveStaticTokenEnrollmentToken(t *testing.T) {
 	bulkerBuilder := func(policies ...model.Policy) func() bulk.Bulk {
 		return func() bulk.Bulk {
@@ -247,3 +290,374 @@ func TestEnrollerT_retrieveStaticTokenEnrollmentToken(t *testing.T) {
 		})
 	}
 }
+
+func TestEnrollBodyLimit(t *testing.T) {
+	const maxBody = 512
+	tests := []struct {
+		name       string
+		metadata   string
+		statusCode int
+	}{{
+		name:     "normal body",
+		metadata: `{}`,
+	}, {
+		name:       "oversized body",
+		metadata:   `{"padding":"` + strings.Repeat("a", maxBody) + `"}`,
+		statusCode: http.StatusRequestEntityTooLarge,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Server{}
+			cfg.Limits.EnrollLimit.MaxBody = maxBody
+			// checkin limit is deliberately smaller, it does not apply to enrollment
+			cfg.Limits.CheckinLimit.MaxBody = 1
+
+			c := testcache.NewMockCache()
+			c.On("GetEnrollmentAPIKey", "key-id").Return(model.EnrollmentAPIKey{PolicyID: "policy-id", Active: true}, true)
+			c.On("SetAPIKey", mock.Anything, true).Return()
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+			bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&apikey.APIKey{ID: "1234", Key: "1234"}, nil)
+			bulker.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+			et, err := NewEnrollerT(mustBuildConstraints("8.9.0"), cfg, bulker, c)
+			require.NoError(t, err)
+
+			body := `{"type":"PERMANENT","metadata":{"user_provided":{},"local":` + tc.metadata + `}}`
+			r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/enroll", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			resp, err := et.processRequest(testlog.SetLogger(t), w, r, &rollback.Rollback{}, &apikey.APIKey{ID: "key-id"}, "8.9.0")
+			if tc.statusCode == 0 {
+				require.NoError(t, err)
+				require.Equal(t, "created", resp.Action)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, tc.statusCode, NewHTTPErrResp(err).StatusCode)
+		})
+	}
+}
+
+// enrolledAgentsBulk keeps the enrolled agents in memory to answer the policy agent counts.
+type enrolledAgentsBulk struct {
+	*ftesting.MockBulk
+
+	mu     sync.Mutex
+	agents map[string]string // agent ID to policy ID
+	max    int
+	// enrollmentIDs maps the enrollment ID of agents to their agent ID.
+	enrollmentIDs map[string]string
+	// stale are the deleted agents still counted until the next refresh, agent ID to policy ID.
+	stale map[string]string
+
+	// creating, if set, is waited on before an agent is created.
+	creating *sync.WaitGroup
+}
+
+func newEnrolledAgentsBulk() *enrolledAgentsBulk {
+	return &enrolledAgentsBulk{MockBulk: ftesting.NewMockBulk(), agents: map[string]string{}, enrollmentIDs: map[string]string{}, stale: map[string]string{}}
+}
+
+// visible counts the agents in the policy that searches see.
+func (b *enrolledAgentsBulk) visible(policyID string) int {
+	n := b.count(policyID)
+	for _, p := range b.stale {
+		if p == policyID {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *enrolledAgentsBulk) count(policyID string) int {
+	var n int
+	for _, p := range b.agents {
+		if p == policyID {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *enrolledAgentsBulk) Search(_ context.Context, _ string, body []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	var query struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Term map[string]interface{} `json:"term"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &query); err != nil {
+		return nil, err
+	}
+	res := &es.ResultT{}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range query.Query.Bool.Filter {
+		if policyID, ok := f.Term[dl.FieldPolicyID].(string); ok {
+			res.Total.Value = uint64(b.visible(policyID))
+		}
+		if enrollmentID, ok := f.Term[dl.FieldEnrollmentID].(string); ok {
+			if id, ok := b.enrollmentIDs[enrollmentID]; ok {
+				src, err := json.Marshal(model.Agent{PolicyID: b.agents[id], EnrollmentID: enrollmentID})
+				if err != nil {
+					return nil, err
+				}
+				res.Hits = append(res.Hits, es.HitT{ID: id, Source: src})
+			}
+		}
+	}
+	return res, nil
+}
+
+func (b *enrolledAgentsBulk) Create(_ context.Context, _, id string, body []byte, _ ...bulk.Opt) (string, error) {
+	var agent model.Agent
+	if err := json.Unmarshal(body, &agent); err != nil {
+		return "", err
+	}
+	if b.creating != nil {
+		b.creating.Done()
+		b.creating.Wait()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.agents[id] = agent.PolicyID
+	if agent.EnrollmentID != "" {
+		b.enrollmentIDs[agent.EnrollmentID] = id
+	}
+	if n := b.count(agent.PolicyID); n > b.max {
+		b.max = n
+	}
+	return id, nil
+}
+
+// Delete removes the agent. Enrollments only pass bulk.WithRefresh to deletes, without options the deleted agent
+// is still counted as it would be until the next refresh of the index.
+func (b *enrolledAgentsBulk) Delete(_ context.Context, _, id string, opts ...bulk.Opt) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(opts) == 0 {
+		b.stale[id] = b.agents[id]
+	}
+	delete(b.agents, id)
+	for enrollmentID, agentID := range b.enrollmentIDs {
+		if agentID == id {
+			delete(b.enrollmentIDs, enrollmentID)
+		}
+	}
+	return nil
+}
+
+func (b *enrolledAgentsBulk) APIKeyCreate(_ context.Context, name, _ string, _ []byte, _ interface{}) (*bulk.APIKey, error) {
+	return &bulk.APIKey{ID: name, Key: name}, nil
+}
+
+func (b *enrolledAgentsBulk) APIKeyRead(_ context.Context, id string, _ bool) (*bulk.APIKeyMetadata, error) {
+	return &bulk.APIKeyMetadata{ID: id}, nil
+}
+
+func (b *enrolledAgentsBulk) APIKeyInvalidate(context.Context, ...string) error {
+	return nil
+}
+
+func TestEnrollPolicyAgentLimit(t *testing.T) {
+	newEnroller := func(t *testing.T, bulker bulk.Bulk) *EnrollerT {
+		cfg := &config.Server{PolicyAgentLimits: []config.PolicyAgentLimit{{PolicyID: "capped", MaxAgents: 3}}}
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		et, err := NewEnrollerT(mustBuildConstraints("8.9.0"), cfg, bulker, c)
+		require.NoError(t, err)
+		return et
+	}
+	// enrollWithID rolls back failed enrollments, as the enroll handler does.
+	enrollWithID := func(t *testing.T, et *EnrollerT, policyID, enrollmentID string) error {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		req := &EnrollRequest{
+			Type:     "PERMANENT",
+			Metadata: EnrollMetadata{UserProvided: []byte("{}"), Local: []byte("{}")},
+		}
+		if enrollmentID != "" {
+			req.EnrollmentId = &enrollmentID
+		}
+		rb := rollback.New(zerolog.Nop())
+		_, err := et._enroll(ctx, rb, zerolog.Nop(), req, policyID, "", "8.9.0")
+		if err != nil {
+			require.NoError(t, rb.Rollback(ctx))
+		}
+		return err
+	}
+	enroll := func(t *testing.T, et *EnrollerT, policyID string) error {
+		return enrollWithID(t, et, policyID, "")
+	}
+
+	t.Run("within and beyond the limit", func(t *testing.T) {
+		bulker := newEnrolledAgentsBulk()
+		et := newEnroller(t, bulker)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, enroll(t, et, "capped"))
+		}
+		err := enroll(t, et, "capped")
+		require.ErrorIs(t, err, ErrPolicyAgentLimit)
+		require.Equal(t, http.StatusConflict, NewHTTPErrResp(err).StatusCode)
+		require.Equal(t, 3, bulker.count("capped"))
+
+		// Policies without a limit are not capped.
+		for i := 0; i < 5; i++ {
+			require.NoError(t, enroll(t, et, "uncapped"))
+		}
+	})
+
+	t.Run("re-enrolling at the limit", func(t *testing.T) {
+		bulker := newEnrolledAgentsBulk()
+		et := newEnroller(t, bulker)
+		require.NoError(t, enroll(t, et, "capped"))
+		require.NoError(t, enroll(t, et, "capped"))
+		require.NoError(t, enrollWithID(t, et, "capped", "enrollment-1"))
+
+		// The agent replaces the one it enrolled before, which is no longer counted.
+		require.NoError(t, enrollWithID(t, et, "capped", "enrollment-1"))
+		require.Equal(t, 3, bulker.count("capped"))
+		require.Empty(t, bulker.stale)
+	})
+
+	t.Run("concurrent enrollments on a server", func(t *testing.T) {
+		bulker := newEnrolledAgentsBulk()
+		et := newEnroller(t, bulker)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- enroll(t, et, "capped")
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		var enrolled int
+		for err := range errs {
+			if err == nil {
+				enrolled++
+				continue
+			}
+			require.ErrorIs(t, err, ErrPolicyAgentLimit)
+		}
+		require.Equal(t, 3, enrolled)
+		require.Equal(t, 3, bulker.count("capped"))
+		require.Equal(t, 3, bulker.max)
+	})
+
+	t.Run("servers racing for the last slot", func(t *testing.T) {
+		bulker := newEnrolledAgentsBulk()
+		et := newEnroller(t, bulker)
+		require.NoError(t, enroll(t, et, "capped"))
+		require.NoError(t, enroll(t, et, "capped"))
+
+		// Both servers count 2 agents before either creates its own.
+		bulker.creating = &sync.WaitGroup{}
+		bulker.creating.Add(2)
+		servers := []*EnrollerT{newEnroller(t, bulker), newEnroller(t, bulker)}
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for i, server := range servers {
+			wg.Add(1)
+			go func(i int, server *EnrollerT) {
+				defer wg.Done()
+				errs[i] = enroll(t, server, "capped")
+			}(i, server)
+		}
+		wg.Wait()
+
+		// At least one server sees both agents and rejects its enrollment. The other one may still see both, or
+		// see the first one rolled back, but the rollbacks never leave the policy over its limit.
+		var enrolled int
+		for _, err := range errs {
+			if err == nil {
+				enrolled++
+				continue
+			}
+			require.ErrorIs(t, err, ErrPolicyAgentLimit)
+		}
+		require.LessOrEqual(t, enrolled, 1)
+		require.Equal(t, 2+enrolled, bulker.count("capped"))
+	})
+}
+
+func TestEnrollDedupWindow(t *testing.T) {
+	cfg := &config.Server{Enroll: config.Enroll{DedupWindow: time.Minute}}
+	c := testcache.NewMockCache()
+	c.On("GetEnrollmentAPIKey", "key-id").Return(model.EnrollmentAPIKey{PolicyID: "policy-id", Active: true}, true)
+	c.On("SetAPIKey", mock.Anything, true).Return()
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&apikey.APIKey{ID: "1234", Key: "1234"}, nil)
+	bulker.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	et, err := NewEnrollerT(mustBuildConstraints("8.9.0"), cfg, bulker, c)
+	require.NoError(t, err)
+
+	enroll := func(t *testing.T, hostname, enrollmentID string) *EnrollResponse {
+		body := `{"type":"PERMANENT","enrollment_id":"` + enrollmentID + `","metadata":{"user_provided":{},"local":{"host":{"hostname":"` + hostname + `"}}}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/enroll", strings.NewReader(body))
+		resp, err := et.processRequest(testlog.SetLogger(t), httptest.NewRecorder(), r, &rollback.Rollback{}, &apikey.APIKey{ID: "key-id"}, "8.9.0")
+		require.NoError(t, err)
+		return resp
+	}
+
+	first := enroll(t, "host-1", "enrollment-1")
+	retried := enroll(t, "host-1", "enrollment-1")
+	require.Equal(t, first.Item.Id, retried.Item.Id)
+	require.Equal(t, first.Item.AccessApiKey, retried.Item.AccessApiKey)
+	bulker.AssertNumberOfCalls(t, "Create", 1)
+	bulker.AssertNumberOfCalls(t, "APIKeyCreate", 1)
+
+	other := enroll(t, "host-2", "enrollment-2")
+	require.NotEqual(t, first.Item.Id, other.Item.Id)
+	bulker.AssertNumberOfCalls(t, "Create", 2)
+
+	// Identical requests without an enrollment_id, e.g. from cloned machines, each enroll an agent.
+	clone := enroll(t, "host-3", "")
+	cloned := enroll(t, "host-3", "")
+	require.NotEqual(t, clone.Item.Id, cloned.Item.Id)
+	bulker.AssertNumberOfCalls(t, "Create", 4)
+
+	// Past the window the same request enrolls again.
+	et.recent.mu.Lock()
+	for _, e := range et.recent.entries {
+		e.at = e.at.Add(-2 * time.Minute)
+	}
+	et.recent.mu.Unlock()
+	again := enroll(t, "host-1", "enrollment-1")
+	require.NotEqual(t, first.Item.Id, again.Item.Id)
+	bulker.AssertNumberOfCalls(t, "Create", 5)
+}
+
+func TestCreateFleetAgentWaitForActiveShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	defer cancel()
+
+	client, mocktrans := esutil.MockESClient(t)
+	var shards string
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		shards = r.URL.Query().Get("wait_for_active_shards")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"items":[{"create":{"_index":".fleet-agents","_id":"agent-1","status":201,"result":"created"}}]}`)),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	bulker := bulk.NewBulker(client, nil, bulk.WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	require.NoError(t, createFleetAgent(ctx, bulker, "agent-1", model.Agent{}, 2))
+	assert.Equal(t, "2", shards)
+
+	// By default the parameter is not sent.
+	require.NoError(t, createFleetAgent(ctx, bulker, "agent-1", model.Agent{}, 0))
+	assert.Empty(t, shards)
+}
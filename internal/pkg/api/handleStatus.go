@@ -19,6 +19,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 
@@ -84,6 +85,17 @@ func (st StatusT) handleStatus(zlog zerolog.Logger, sm policy.SelfMonitor, bi bu
 			BuildHash: &bi.Commit,
 			BuildTime: &bt,
 		}
+		if lastErrors := es.LastErrors(); len(lastErrors) > 0 {
+			errs := make([]StatusResponseLastError, 0, len(lastErrors))
+			for _, e := range lastErrors {
+				errs = append(errs, StatusResponseLastError{
+					Message:   e.Message,
+					Subsystem: e.Subsystem,
+					Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+				})
+			}
+			resp.LastErrors = &errs
+		}
 		sSpan.End()
 	}
 	span.End()
@@ -9,6 +9,8 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -20,6 +22,7 @@ import (
 	fbuild "github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
 	"github.com/stretchr/testify/assert"
@@ -128,3 +131,57 @@ func TestHandleStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleStatusLastErrors(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+
+	status := func(authfn AuthFunc) StatusAPIResponse {
+		r := apiServer{
+			st: NewStatusT(cfg, nil, c, withAuthFunc(authfn)),
+			sm: &mockPolicyMonitor{client.UnitStateHealthy},
+			bi: fbuild.Info{Version: "8.1.0", BuildTime: time.Now()},
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/api/status", nil)
+		Handler(&r).ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res StatusAPIResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+	lastError := func(res StatusAPIResponse, subsystem string) *StatusResponseLastError {
+		require.NotNil(t, res.LastErrors)
+		for _, e := range *res.LastErrors {
+			if e.Subsystem == subsystem {
+				return &e
+			}
+		}
+		return nil
+	}
+	authed := func(r *http.Request) (*apikey.APIKey, error) { return nil, nil }
+
+	es.RecordError(es.SubsystemLeadership, errors.New("first failure"))
+	first := lastError(status(authed), es.SubsystemLeadership)
+	require.NotNil(t, first)
+	assert.Equal(t, "first failure", first.Message)
+
+	es.RecordError(es.SubsystemLeadership, fmt.Errorf("encountered error while fetching policy leaders: %w", es.ErrElasticVersionConflict))
+	second := lastError(status(authed), es.SubsystemLeadership)
+	require.NotNil(t, second)
+	assert.Equal(t, "encountered error while fetching policy leaders: elastic version conflict", second.Message)
+	firstAt, err := time.Parse(time.RFC3339Nano, first.Timestamp)
+	require.NoError(t, err)
+	secondAt, err := time.Parse(time.RFC3339Nano, second.Timestamp)
+	require.NoError(t, err)
+	assert.False(t, secondAt.Before(firstAt))
+
+	// Errors are only shown to authenticated requests.
+	res := status(func(r *http.Request) (*apikey.APIKey, error) { return nil, apikey.ErrNoAuthHeader })
+	assert.Nil(t, res.LastErrors)
+}
@@ -0,0 +1,38 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+// LeaderIndexStatsSchedule returns the schedule that refreshes the policy leaders index gauges every interval.
+// Each policy has a single leader document, a document count growing past the number of policies points at duplicate leaders.
+func LeaderIndexStatsSchedule(bulker bulk.Bulk, interval time.Duration) scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:     "policy leaders index metrics",
+		Interval: interval,
+		WorkFn: func(ctx context.Context) error {
+			return updateLeaderIndexMetrics(ctx, bulker, &cntLeaderIndex)
+		},
+	}
+}
+
+func updateLeaderIndexMetrics(ctx context.Context, bulker bulk.Bulk, stats *leaderIndexStats) error {
+	s, err := dl.PolicyLeadersIndexStats(ctx, bulker)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("failed to read policy leaders index stats")
+		return err
+	}
+	stats.Update(s)
+	return nil
+}
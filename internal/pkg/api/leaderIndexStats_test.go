@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestUpdateLeaderIndexMetrics(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	reg := newMetricsRegistry("test_leader_index")
+	var stats leaderIndexStats
+	stats.Register(reg)
+
+	client, mocktrans := esutil.MockESClient(t)
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		require.Equal(t, "/"+dl.FleetPoliciesLeader+"/_stats/docs,store", r.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"_all":{
+				"primaries":{"docs":{"count":12,"deleted":3},"store":{"size_in_bytes":40960}},
+				"total":{"docs":{"count":24,"deleted":6},"store":{"size_in_bytes":81920}}
+			}}`)),
+			Header: http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Client").Return(client)
+
+	err := updateLeaderIndexMetrics(ctx, bulker, &stats)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), stats.docs.metric.Get())
+	require.Equal(t, float64(12), testutil.ToFloat64(stats.docs.gauge))
+	require.Equal(t, uint64(40960), stats.storeBytes.metric.Get())
+	require.Equal(t, float64(40960), testutil.ToFloat64(stats.storeBytes.gauge))
+
+	// The index does not exist until a server first takes a policy lead.
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`)),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	err = updateLeaderIndexMetrics(ctx, bulker, &stats)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), stats.docs.metric.Get())
+	require.Equal(t, float64(0), testutil.ToFloat64(stats.storeBytes.gauge))
+}
@@ -21,6 +21,7 @@ import (
 	"go.elastic.co/apm/v2"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
@@ -46,6 +47,9 @@ var (
 	cntGetPGP      routeStats
 	cntArtifacts   artifactStats
 
+	cntAgentStatus agentStatusStats
+	cntLeaderIndex leaderIndexStats
+
 	infoReg sync.Once
 )
 
@@ -76,6 +80,10 @@ func init() {
 	cntFileDeliv.Register(routesRegistry.newRegistry("deliverFile"))
 	cntGetPGP.Register(routesRegistry.newRegistry("getPGPKey"))
 
+	cntAgentStatus.Register(registry.newRegistry("agents").newRegistry("status"))
+	cntLeaderIndex.Register(registry.newRegistry("policy_leaders_index"))
+
+	registry.promReg.MustRegister(bulk.Collectors()...)
 }
 
 // metricsRegistry wraps libbeat and prometheus registries
@@ -129,6 +137,11 @@ func (g *statsGauge) Add(delta uint64) {
 	g.gauge.Add(float64(delta))
 }
 
+func (g *statsGauge) Set(v uint64) {
+	g.metric.Set(v)
+	g.gauge.Set(float64(v))
+}
+
 func (g *statsGauge) Inc() {
 	g.metric.Inc()
 	g.gauge.Inc()
@@ -233,6 +246,46 @@ func (rt *artifactStats) IncError(err error) {
 	}
 }
 
+// agentStatusStats is the number of active agents per status.
+type agentStatusStats struct {
+	gauges map[string]*statsGauge
+}
+
+func (st *agentStatusStats) Register(registry *metricsRegistry) {
+	st.gauges = make(map[string]*statsGauge, len(agentStatuses))
+	for _, status := range agentStatuses {
+		st.gauges[status] = newGauge(registry, status)
+	}
+}
+
+// Update sets the gauges to counts, statuses absent from counts are reset to 0.
+func (st *agentStatusStats) Update(counts map[string]int64) {
+	for status, g := range st.gauges {
+		var v uint64
+		if n := counts[status]; n > 0 {
+			v = uint64(n)
+		}
+		g.Set(v)
+	}
+}
+
+// leaderIndexStats is the size of the policy leaders index.
+type leaderIndexStats struct {
+	docs       *statsGauge
+	storeBytes *statsGauge
+}
+
+func (st *leaderIndexStats) Register(registry *metricsRegistry) {
+	st.docs = newGauge(registry, "docs")
+	st.storeBytes = newGauge(registry, "store_bytes")
+}
+
+// Update sets the gauges to stats.
+func (st *leaderIndexStats) Update(stats dl.IndexStats) {
+	st.docs.Set(uint64(stats.DocCount))
+	st.storeBytes.Set(uint64(stats.StoreSizeBytes))
+}
+
 // InitMetrics initializes metrics exposure mechanisms.
 // If tracer is not nil, prometheus metrics are shipped through the tracer.
 // If cfg.http.enabled is true a /stats endpoint is created to expose libbeat metrics and a /metrics endpoint is created to expose prometheus metrics on the specified interface.
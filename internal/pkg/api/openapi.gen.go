@@ -277,6 +277,10 @@ type CheckinRequest struct {
 	// Status The agent state, inferred from agent control protocol states.
 	Status CheckinRequestStatus `json:"status"`
 
+	// Timestamp The agent's local time when the checkin was sent.
+	// If fleet-server is configured with a max_clock_skew, checkins where it differs from the server time by more than the skew are logged, or rejected if clock_skew_action is reject.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
 	// UpgradeDetails Additional details describing the status of an UPGRADE action delivered by the client (agent) on checkin.
 	UpgradeDetails *UpgradeDetails `json:"upgrade_details,omitempty"`
 }
@@ -286,6 +290,10 @@ type CheckinRequestStatus string
 
 // CheckinResponse defines model for checkinResponse.
 type CheckinResponse struct {
+	// AccessApiKey The ApiKey token that replaces the expiring access ApiKey of the agent.
+	// The agent must use it on its following requests, the current key is invalidated once it does.
+	AccessApiKey *string `json:"access_api_key,omitempty"`
+
 	// AckToken The acknowlegment token used to indicate action delivery.
 	AckToken *string `json:"ack_token,omitempty"`
 
@@ -294,6 +302,10 @@ type CheckinResponse struct {
 
 	// Actions A list of actions that the agent must execute.
 	Actions *[]Action `json:"actions,omitempty"`
+
+	// PollTimeoutReached True when the long poll ran until its timeout without any actions or policy changes.
+	// False when the response was returned before the timeout because there were actions to deliver.
+	PollTimeoutReached *bool `json:"poll_timeout_reached,omitempty"`
 }
 
 // DiagnosticsEvent defines model for diagnosticsEvent.
@@ -313,6 +325,10 @@ type DiagnosticsEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode A machine readable reason code for the error, set along with the error message.
+	// Stored on the action result so failures can be queried by reason across agents.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -497,6 +513,10 @@ type GenericEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode A machine readable reason code for the error, set along with the error message.
+	// Stored on the action result so failures can be queried by reason across agents.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -547,6 +567,10 @@ type InputEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode A machine readable reason code for the error, set along with the error message.
+	// Stored on the action result so failures can be queried by reason across agents.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -604,6 +628,9 @@ type PolicyData struct {
 
 // StatusAPIResponse Status response information.
 type StatusAPIResponse struct {
+	// LastErrors The last Elasticsearch error of each fleet-server subsystem that encountered one, included in the response to an authorized status request.
+	LastErrors *[]StatusResponseLastError `json:"last_errors,omitempty"`
+
 	// Name Service name.
 	Name string `json:"name"`
 
@@ -615,6 +642,18 @@ type StatusAPIResponse struct {
 	Version *StatusResponseVersion `json:"version,omitempty"`
 }
 
+// StatusResponseLastError The last Elasticsearch error a fleet-server subsystem encountered.
+type StatusResponseLastError struct {
+	// Message The error message.
+	Message string `json:"message"`
+
+	// Subsystem The fleet-server subsystem, one of bulk, leadership or monitor.
+	Subsystem string `json:"subsystem"`
+
+	// Timestamp The date-time the error was encountered.
+	Timestamp string `json:"timestamp"`
+}
+
 // StatusResponseStatus A Unit state that fleet-server may report.
 // Unit state is defined in the elastic-agent-client specification.
 type StatusResponseStatus string
@@ -644,6 +683,10 @@ type UpgradeEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode A machine readable reason code for the error, set along with the error message.
+	// Stored on the action result so failures can be queried by reason across agents.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -866,6 +909,10 @@ type AgentCheckinParams struct {
 
 	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IfNoneMatch The ETag of the policy the agent currently runs, as returned by a previous checkin.
+	// If the policy is unchanged the POLICY_CHANGE action only contains the policy id and revision.
+	IfNoneMatch *string `json:"If-None-Match,omitempty"`
 }
 
 // ArtifactParams defines parameters for Artifact.
@@ -2036,6 +2083,25 @@ func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.R
 
 	}
 
+	// ------------- Optional header parameter "If-None-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-None-Match")]; found {
+		var IfNoneMatch string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "If-None-Match", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, valueList[0], &IfNoneMatch)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "If-None-Match", Err: err})
+			return
+		}
+
+		params.IfNoneMatch = &IfNoneMatch
+
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.AgentCheckin(w, r, id, params)
 	}))
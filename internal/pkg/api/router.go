@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
@@ -24,6 +26,7 @@ func newRouter(cfg *config.ServerLimits, si ServerInterface, tracer *apm.Tracer)
 	if tracer != nil {
 		r.Use(apmchiv5.Middleware(apmchiv5.WithTracer(tracer)))
 	}
+	r.Use(telemetry.Middleware)
 	r.Use(logger.Middleware) // Attach middlewares to router directly so the occur before any request parsing/validation
 	r.Use(middleware.Recoverer)
 	r.Use(Limiter(cfg).middleware)
@@ -49,20 +52,23 @@ type limiter struct {
 	uploadComplete *limit.Limiter
 	deliverFile    *limit.Limiter
 	getPGPKey      *limit.Limiter
+	retryAfter     time.Duration
 }
 
 func Limiter(cfg *config.ServerLimits) *limiter {
+	retryAfter := limit.WithRetryAfter(cfg.RetryAfter)
 	return &limiter{
-		checkin:        limit.NewLimiter(&cfg.CheckinLimit),
-		artifact:       limit.NewLimiter(&cfg.ArtifactLimit),
-		enroll:         limit.NewLimiter(&cfg.EnrollLimit),
-		ack:            limit.NewLimiter(&cfg.AckLimit),
-		status:         limit.NewLimiter(&cfg.StatusLimit),
-		uploadBegin:    limit.NewLimiter(&cfg.UploadStartLimit),
-		uploadChunk:    limit.NewLimiter(&cfg.UploadChunkLimit),
-		uploadComplete: limit.NewLimiter(&cfg.UploadEndLimit),
-		deliverFile:    limit.NewLimiter(&cfg.DeliverFileLimit),
-		getPGPKey:      limit.NewLimiter(&cfg.GetPGPKey),
+		checkin:        limit.NewLimiter(&cfg.CheckinLimit, retryAfter),
+		artifact:       limit.NewLimiter(&cfg.ArtifactLimit, retryAfter),
+		enroll:         limit.NewLimiter(&cfg.EnrollLimit, retryAfter),
+		ack:            limit.NewLimiter(&cfg.AckLimit, retryAfter),
+		status:         limit.NewLimiter(&cfg.StatusLimit, retryAfter),
+		uploadBegin:    limit.NewLimiter(&cfg.UploadStartLimit, retryAfter),
+		uploadChunk:    limit.NewLimiter(&cfg.UploadChunkLimit, retryAfter),
+		uploadComplete: limit.NewLimiter(&cfg.UploadEndLimit, retryAfter),
+		deliverFile:    limit.NewLimiter(&cfg.DeliverFileLimit, retryAfter),
+		getPGPKey:      limit.NewLimiter(&cfg.GetPGPKey, retryAfter),
+		retryAfter:     cfg.RetryAfter,
 	}
 }
 
@@ -111,6 +117,7 @@ func pathToOperation(path string) string {
 
 func (l *limiter) middleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(withRetryAfter(r.Context(), l.retryAfter))
 		switch pathToOperation(r.URL.Path) {
 		case "enroll":
 			l.enroll.Wrap("enroll", &cntEnroll, zerolog.DebugLevel)(next).ServeHTTP(w, r)
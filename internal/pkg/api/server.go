@@ -56,24 +56,7 @@ func NewServer(addr string, cfg *config.Server, ct *CheckinT, et *EnrollerT, at
 }
 
 func (s *server) Run(ctx context.Context) error {
-	rdto := s.cfg.Timeouts.Read
-	wrto := s.cfg.Timeouts.Write
-	idle := s.cfg.Timeouts.Idle
-	rdhr := s.cfg.Timeouts.ReadHeader
-	mhbz := s.cfg.Limits.MaxHeaderByteSize
-
-	srv := http.Server{
-		Addr:              s.addr,
-		Handler:           s.handler,
-		ReadTimeout:       rdto,
-		ReadHeaderTimeout: rdhr,
-		WriteTimeout:      wrto,
-		IdleTimeout:       idle,
-		MaxHeaderBytes:    mhbz,
-		BaseContext:       func(net.Listener) context.Context { return ctx },
-		ErrorLog:          errLogger(ctx),
-		ConnState:         diagConn,
-	}
+	srv := s.newHTTPServer(ctx)
 
 	forceCh := make(chan struct{})
 	defer close(forceCh)
@@ -92,7 +75,7 @@ func (s *server) Run(ctx context.Context) error {
 		}
 	}()
 
-	var listenCfg net.ListenConfig
+	listenCfg := net.ListenConfig{KeepAlive: s.cfg.KeepAlive.TCPPeriod}
 
 	ln, err := listenCfg.Listen(ctx, "tcp", s.addr)
 	if err != nil {
@@ -152,6 +135,24 @@ func (s *server) Run(ctx context.Context) error {
 	return nil
 }
 
+// newHTTPServer creates the http.Server with the configured timeouts and keep-alive behaviour.
+func (s *server) newHTTPServer(ctx context.Context) *http.Server {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.handler,
+		ReadTimeout:       s.cfg.Timeouts.Read,
+		ReadHeaderTimeout: s.cfg.Timeouts.ReadHeader,
+		WriteTimeout:      s.cfg.Timeouts.Write,
+		IdleTimeout:       s.cfg.Timeouts.Idle,
+		MaxHeaderBytes:    s.cfg.Limits.MaxHeaderByteSize,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ErrorLog:          errLogger(ctx),
+		ConnState:         diagConn,
+	}
+	srv.SetKeepAlivesEnabled(s.cfg.KeepAlive.Enabled)
+	return srv
+}
+
 func diagConn(c net.Conn, s http.ConnState) {
 	if c == nil {
 		return
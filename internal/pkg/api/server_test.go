@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -71,3 +72,32 @@ func Test_server_Run(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+func Test_server_newHTTPServer(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Timeouts.ReadHeader = 2 * time.Second
+	cfg.Timeouts.Idle = 90 * time.Second
+	cfg.Limits.MaxHeaderByteSize = 4096
+
+	s := &server{addr: "localhost:0", cfg: cfg, handler: http.NotFoundHandler()}
+	srv := s.newHTTPServer(context.Background())
+	require.Equal(t, 2*time.Second, srv.ReadHeaderTimeout)
+	require.Equal(t, 90*time.Second, srv.IdleTimeout)
+	require.Equal(t, cfg.Timeouts.Read, srv.ReadTimeout)
+	require.Equal(t, cfg.Timeouts.Write, srv.WriteTimeout)
+	require.Equal(t, 4096, srv.MaxHeaderBytes)
+
+	// A server with keep-alives disabled closes the connection after each response.
+	cfg.KeepAlive.Enabled = false
+	srv = s.newHTTPServer(context.Background())
+	ts := httptest.NewUnstartedServer(s.handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.True(t, resp.Close)
+}
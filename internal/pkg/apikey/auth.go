@@ -5,6 +5,7 @@
 package apikey
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -62,3 +63,48 @@ func (k APIKey) Authenticate(ctx context.Context, es *elasticsearch.Client) (*Se
 
 	return &info, nil
 }
+
+// IndexPrivileges are privileges on a set of indices, as checked by HasPrivileges.
+type IndexPrivileges struct {
+	Names      []string `json:"names"`
+	Privileges []string `json:"privileges"`
+	// AllowRestrictedIndices is required to check the privileges on system indices such as .fleet-*.
+	AllowRestrictedIndices bool `json:"allow_restricted_indices,omitempty"`
+}
+
+// HasPrivileges returns true if the APIKey holds all the requested index privileges (retrieved from Elasticsearch).
+func (k APIKey) HasPrivileges(ctx context.Context, es *elasticsearch.Client, indices ...IndexPrivileges) (bool, error) {
+	body, err := json.Marshal(map[string]interface{}{"index": indices})
+	if err != nil {
+		return false, err
+	}
+
+	token := fmt.Sprintf("%s%s", authPrefix, k.Token())
+
+	req := esapi.SecurityHasPrivilegesRequest{
+		Body:   bytes.NewReader(body),
+		Header: map[string][]string{AuthKey: []string{token}},
+	}
+
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return false, fmt.Errorf("apikey has privileges request %s: %w", k.ID, err)
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.IsError() {
+		return false, fmt.Errorf("%w: %w", ErrUnauthorized, fmt.Errorf("apikey has privileges response %s: %s", k.ID, res.String()))
+	}
+
+	var resp struct {
+		HasAllRequested bool `json:"has_all_requested"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return false, fmt.Errorf("apikey has privileges parse %s: %w", k.ID, err)
+	}
+
+	return resp.HasAllRequested, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/danger"
 
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Buf = danger.Buf
@@ -16,19 +17,64 @@ type Buf = danger.Buf
 // However, the multiOp API's will allocate directly in large blocks.
 
 type bulkT struct {
-	action   actionT    // requested actions
-	flags    flagsT     // execution flags
-	idx      int32      // idx of originating request, used in mulitOp
-	ch       chan respT // response channel, caller is waiting synchronously
-	buf      Buf        // json payload to be sent to elastic
-	next     *bulkT     // pointer to next bulkT, used for fast internal queueing
-	spanLink *apm.SpanLink
+	action      actionT    // requested actions
+	flags       flagsT     // execution flags
+	idx         int32      // idx of originating request, used in mulitOp
+	ch          chan respT // response channel, caller is waiting synchronously
+	buf         Buf        // json payload to be sent to elastic
+	next        *bulkT     // pointer to next bulkT, used for fast internal queueing
+	spanLink    *apm.SpanLink
+	spanContext trace.SpanContext // OpenTelemetry span the flush is linked to, invalid if none
+	shards      int               // wait_for_active_shards, 0 when unset
+	doc         docKey            // target document of create, index, update and delete actions
+}
+
+// docKey identifies the document a bulk action targets.
+type docKey struct {
+	index string
+	id    string
+}
+
+// otelLink returns the link of the flush span to the span of the action, false if it has none.
+func (blk *bulkT) otelLink() (trace.Link, bool) {
+	if !blk.spanContext.IsValid() {
+		return trace.Link{}, false
+	}
+	return trace.Link{SpanContext: blk.spanContext}, true
+}
+
+// dedupeLinks drops the links to a span that is already linked, blocks of the
+// same caller span would otherwise link the flush span to it once per block.
+func dedupeLinks(links []trace.Link) []trace.Link {
+	type spanKey struct {
+		trace trace.TraceID
+		span  trace.SpanID
+	}
+	seen := make(map[spanKey]struct{}, len(links))
+	n := 0
+	for _, l := range links {
+		k := spanKey{l.SpanContext.TraceID(), l.SpanContext.SpanID()}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		links[n] = l
+		n++
+	}
+	return links[:n]
 }
 
 type flagsT int8
 
+// Values of the refresh parameter of the bulk API.
+const (
+	refreshTrue    = "true"
+	refreshWaitFor = "wait_for"
+)
+
 const (
 	flagRefresh flagsT = 1 << iota
+	flagForceRefresh
 )
 
 func (ft flagsT) Has(f flagsT) bool {
@@ -39,6 +85,28 @@ func (ft *flagsT) Set(f flagsT) {
 	*ft = *ft | f
 }
 
+// setRefresh sets the refresh flags requested by the options.
+func (ft *flagsT) setRefresh(opt optionsT) {
+	if opt.Refresh {
+		ft.Set(flagRefresh)
+	}
+	if opt.ForceRefresh {
+		ft.Set(flagForceRefresh)
+	}
+}
+
+// refreshParam returns the bulk refresh parameter for the flags, empty if the write does not wait on a refresh.
+// A forced refresh takes precedence as it also makes the write visible.
+func (ft flagsT) refreshParam() string {
+	switch {
+	case ft.Has(flagForceRefresh):
+		return refreshTrue
+	case ft.Has(flagRefresh):
+		return refreshWaitFor
+	}
+	return ""
+}
+
 type actionT int8
 
 const (
@@ -71,6 +139,9 @@ func (blk *bulkT) reset() {
 	blk.action = 0
 	blk.flags = 0
 	blk.idx = 0
+	blk.shards = 0
+	blk.spanContext = trace.SpanContext{}
+	blk.doc = docKey{}
 	blk.buf.Reset()
 	blk.next = nil
 }
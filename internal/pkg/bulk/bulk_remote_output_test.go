@@ -8,10 +8,21 @@ package bulk
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
 func Test_hasChangedAndUpdateRemoteOutputConfig(t *testing.T) {
@@ -148,3 +159,74 @@ func Test_CreateAndGetBulkerChanged(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, true, cancelFnCalled)
 }
+
+// stalledTransport holds every request until released, as an unresponsive Elasticsearch would.
+type stalledTransport struct {
+	requests chan struct{}
+	release  chan struct{}
+}
+
+func (s *stalledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case s.requests <- struct{}{}:
+	default:
+	}
+	select {
+	case <-s.release:
+	case <-req.Context().Done():
+	}
+	return nil, errors.New("stalled")
+}
+
+func Test_RemoteOutputStallDoesNotBlockOthers(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	stalled := &stalledTransport{requests: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(stalled.release)
+	prevESClient := newESClient
+	newESClient = func(context.Context, *config.Config, bool, ...es.ConfigOption) (*elasticsearch.Client, error) {
+		return elasticsearch.NewClient(elasticsearch.Config{Transport: stalled})
+	}
+	defer func() { newESClient = prevESClient }()
+
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+	outputMap := map[string]map[string]interface{}{
+		"remote1": {
+			"type":          "remote_elasticsearch",
+			"hosts":         []interface{}{"https://remote-es:443"},
+			"service_token": "token1",
+		},
+	}
+	remote, _, err := bulker.CreateAndGetBulker(ctx, zerolog.Nop(), "remote1", outputMap)
+	require.NoError(t, err)
+	defer remote.CancelFn()()
+
+	remoteDone := make(chan error, 1)
+	go func() {
+		_, err := remote.Index(ctx, "testidx", "1", []byte(`{}`))
+		remoteDone <- err
+	}()
+	select {
+	case <-stalled.requests:
+	case <-time.After(time.Second):
+		t.Fatal("remote output bulker did not flush")
+	}
+
+	// The stalled remote output flush does not hold up the flushes of the default output.
+	for i := 0; i < 3; i++ {
+		_, err := bulker.Index(ctx, "testidx", strconv.Itoa(i), []byte(`{}`))
+		require.NoError(t, err)
+	}
+	select {
+	case err := <-remoteDone:
+		t.Fatalf("remote output write completed while stalled: %v", err)
+	default:
+	}
+	require.Equal(t, float64(1), testutil.ToFloat64(flushActive.WithLabelValues("remote1")))
+	require.Zero(t, testutil.ToFloat64(flushActive.WithLabelValues(defaultOutputName)))
+}
@@ -9,19 +9,31 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TODO:
-// WithREfresh() options
 // Delete not found?
 
 type mockBulkTransport struct {
@@ -400,3 +412,797 @@ func BenchmarkMockBulk(b *testing.B) {
 		b.Run(strconv.Itoa(n), bindFunc(n))
 	}
 }
+
+func TestBulkerOTelSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	parentCtx, parent := telemetry.Start(ctx, "checkin", telemetry.AttrAgentID.String("agent-1"))
+	err := bulker.Update(parentCtx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`))
+	parent.End()
+	require.NoError(t, err)
+
+	child, ok := findSpan(exporter, "Bulker: update")
+	require.True(t, ok)
+	root, ok := findSpan(exporter, "checkin")
+	require.True(t, ok)
+	require.Equal(t, root.SpanContext.SpanID(), child.Parent.SpanID())
+	require.Contains(t, child.Attributes, telemetry.AttrIndex.String("testidx"))
+	require.Contains(t, root.Attributes, telemetry.AttrAgentID.String("agent-1"))
+}
+
+// findSpan returns the exported span named name.
+func findSpan(exporter *tracetest.InMemoryExporter, name string) (tracetest.SpanStub, bool) {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestFlushSpanLinked(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	flushLinks := func(t *testing.T) []sdktrace.Link {
+		t.Helper()
+		// The flush span ends after the response is sent to the caller.
+		var flush tracetest.SpanStub
+		require.Eventually(t, func() bool {
+			var ok bool
+			flush, ok = findSpan(exporter, "Flush: bulk")
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		return flush.Links
+	}
+
+	t.Run("context span", func(t *testing.T) {
+		exporter.Reset()
+		checkinCtx, checkin := telemetry.Start(ctx, "checkin")
+		err := bulker.Update(checkinCtx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`))
+		checkin.End()
+		require.NoError(t, err)
+
+		op, ok := findSpan(exporter, "Bulker: update")
+		require.True(t, ok)
+		links := flushLinks(t)
+		require.Len(t, links, 1)
+		require.Equal(t, op.SpanContext.TraceID(), links[0].SpanContext.TraceID())
+		require.Equal(t, op.SpanContext.SpanID(), links[0].SpanContext.SpanID())
+	})
+
+	t.Run("caller provided span", func(t *testing.T) {
+		exporter.Reset()
+		_, request := telemetry.Start(ctx, "request")
+		err := bulker.Update(ctx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`), WithSpanContext(request.SpanContext()))
+		request.End()
+		require.NoError(t, err)
+
+		links := flushLinks(t)
+		require.Len(t, links, 1)
+		require.Equal(t, request.SpanContext().TraceID(), links[0].SpanContext.TraceID())
+		require.Equal(t, request.SpanContext().SpanID(), links[0].SpanContext.SpanID())
+	})
+
+	t.Run("shared caller span", func(t *testing.T) {
+		exporter.Reset()
+		// Both writes are sent in the same flush.
+		shared := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(2))
+		go func() {
+			_ = shared.Run(ctx)
+		}()
+
+		_, request := telemetry.Start(ctx, "request")
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = shared.Update(ctx, "testidx", strconv.Itoa(i), []byte(`{"doc":{"hey":"now"}}`), WithSpanContext(request.SpanContext()))
+			}(i)
+		}
+		wg.Wait()
+		request.End()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		links := flushLinks(t)
+		require.Len(t, links, 1)
+		require.Equal(t, request.SpanContext().SpanID(), links[0].SpanContext.SpanID())
+	})
+}
+
+// shardsBulkTransport records the bulk request query and fails every item
+// with the response Elasticsearch sends when not enough shard copies are active.
+type shardsBulkTransport struct {
+	query url.Values
+}
+
+func (m *shardsBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.query = req.URL.Query()
+	body := `{"took":1,"errors":true,"items":[{"update":{"_index":"testidx","_id":"1","status":503,` +
+		`"error":{"type":"unavailable_shards_exception","reason":"[testidx][0] Not enough active copies to meet shard count of [2] (have 1, needed 2). Timeout: [1m]"}}}]}`
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestWaitForActiveShards(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	mock := &shardsBulkTransport{}
+	bulker := NewBulker(mock, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	err := bulker.Update(ctx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`), WithWaitForActiveShards(2), WithRefresh())
+	require.ErrorIs(t, err, es.ErrUnavailableShards)
+	require.ErrorContains(t, err, "Not enough active copies")
+	require.Equal(t, "2", mock.query.Get("wait_for_active_shards"))
+	require.Equal(t, "wait_for", mock.query.Get("refresh"))
+
+	err = bulker.Update(ctx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`))
+	require.Error(t, err)
+	require.False(t, mock.query.Has("wait_for_active_shards"))
+}
+
+// recordingBulkTransport records the bulk request body before answering as mockBulkTransport.
+type recordingBulkTransport struct {
+	mockBulkTransport
+	body []byte
+}
+
+func (m *recordingBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.body = body
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return m.mockBulkTransport.Perform(req)
+}
+
+func TestCoalesceUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []BulkOpt
+		second   string
+		expected []string
+	}{{
+		name:     "partial documents are merged",
+		second:   `{"doc":{"last_checkin_status":"online","local_metadata":{"os":"linux"}}}`,
+		expected: []string{`{"doc":{"last_checkin":"now","last_checkin_status":"online","local_metadata":{"host":"a","os":"linux"}}}`},
+	}, {
+		name:     "scripts are not merged",
+		second:   `{"script":{"source":"ctx._source.count++"}}`,
+		expected: []string{`{"script":{"source":"ctx._source.count++"}}`, `{"doc":{"last_checkin":"now","local_metadata":{"host":"a"}}}`},
+	}, {
+		name:     "coalescing disabled",
+		opts:     []BulkOpt{WithCoalesceUpdates(false)},
+		second:   `{"doc":{"last_checkin_status":"online","local_metadata":{"os":"linux"}}}`,
+		expected: []string{`{"doc":{"last_checkin_status":"online","local_metadata":{"os":"linux"}}}`, `{"doc":{"last_checkin":"now","local_metadata":{"host":"a"}}}`},
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancelF := context.WithCancel(context.Background())
+			defer cancelF()
+			ctx = testlog.SetLogger(t).WithContext(ctx)
+
+			mock := &recordingBulkTransport{}
+			bulker := NewBulker(mock, nil, append([]BulkOpt{WithFlushThresholdCount(3)}, tc.opts...)...)
+
+			// Queue both updates before the run loop starts so they share a flush in a known order.
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			update := func(i int, body string) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = bulker.Update(ctx, "testidx", "agent-1", []byte(body))
+				}()
+				require.Eventually(t, func() bool { return len(bulker.ch) == i+1 }, time.Second, time.Millisecond)
+			}
+			update(0, `{"doc":{"last_checkin":"now","local_metadata":{"host":"a"}}}`)
+			update(1, tc.second)
+
+			// A third, unrelated document triggers the flush.
+			go func() {
+				_ = bulker.Run(ctx)
+			}()
+			_, err := bulker.Index(ctx, "testidx", "other", []byte(`{}`))
+			require.NoError(t, err)
+			wg.Wait()
+			require.NoError(t, errs[0])
+			require.NoError(t, errs[1])
+
+			var updates []string
+			lines := strings.Split(strings.TrimSpace(string(mock.body)), "\n")
+			for i := 0; i < len(lines); i += 2 {
+				if strings.HasPrefix(lines[i], `{"update":`) {
+					require.Contains(t, lines[i], `"_id":"agent-1"`)
+					updates = append(updates, lines[i+1])
+				}
+			}
+			require.Equal(t, tc.expected, updates)
+		})
+	}
+}
+
+func TestCoalescedUpdateMetadata(t *testing.T) {
+	bulker := NewBulker(&mockBulkTransport{}, nil)
+	update := func(retry string, flags flagsT, next *bulkT) *bulkT {
+		blk := &bulkT{action: ActionUpdate, flags: flags, doc: docKey{"testidx", "agent-1"}, next: next}
+		require.NoError(t, bulker.writeBulkMeta(&blk.buf, ActionUpdate.String(), "testidx", "agent-1", retry))
+		require.NoError(t, bulker.writeBulkBody(&blk.buf, ActionUpdate, []byte(`{"doc":{"hey":"now"}}`)))
+		return blk
+	}
+	// A refreshed update retrying on conflicts, then a plain one; the queue head is the latest.
+	oldest := update("3", flagRefresh, nil)
+	latest := update("", 0, oldest)
+
+	ops := bulker.bulkOps(queueT{ty: kQueueActiveShardsBulk, cnt: 2, head: latest})
+	require.Len(t, ops, 1)
+	require.Equal(t, []*bulkT{latest, oldest}, ops[0].blocks())
+	require.True(t, ops[0].flags.Has(flagRefresh), "the refresh of a coalesced update is kept")
+	require.Contains(t, string(ops[0].buf), `"retry_on_conflict":3`)
+}
+
+// indexBulkTransport answers each index action with its target index, rejecting the documents with id "bad".
+// It records the indices targeted by each bulk request.
+type indexBulkTransport struct {
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func (m *indexBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	var indices, items []string
+	decoder := json.NewDecoder(req.Body)
+	for decoder.More() {
+		var meta struct {
+			Index struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := decoder.Decode(&meta); err != nil {
+			return nil, err
+		}
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if len(indices) == 0 || indices[len(indices)-1] != meta.Index.Index {
+			indices = append(indices, meta.Index.Index)
+		}
+		status, reason := 201, ""
+		if meta.Index.ID == "bad" {
+			status, reason = 400, `,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}`
+		}
+		items = append(items, fmt.Sprintf(`{"index":{"_index":%q,"_id":%q,"status":%d%s}}`, meta.Index.Index, meta.Index.ID, status, reason))
+	}
+	m.mu.Lock()
+	m.requests = append(m.requests, indices)
+	m.mu.Unlock()
+
+	body := `{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestFlushByIndex(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	docs := []struct{ index, id string }{{"idx-a", "1"}, {"idx-b", "bad"}, {"idx-a", "2"}, {"idx-b", "3"}}
+	mock := &indexBulkTransport{}
+	bulker := NewBulker(mock, nil, WithFlushThresholdCount(len(docs)), WithFlushByIndex(true), WithOutputName("flush-by-index"))
+	requests := func(index string) uint64 {
+		var m dto.Metric
+		require.NoError(t, bulker.metrics.indexRequest.WithLabelValues(index).(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	itemErrors := func(index string) float64 {
+		return testutil.ToFloat64(bulker.metrics.indexErrors.WithLabelValues(index))
+	}
+	requestsA, requestsB := requests("idx-a"), requests("idx-b")
+	errorsA, errorsB := itemErrors("idx-a"), itemErrors("idx-b")
+
+	// Queue all the writes before the run loop starts so they share a flush.
+	var wg sync.WaitGroup
+	errs := make([]error, len(docs))
+	for i, d := range docs {
+		i, d := i, d
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = bulker.Index(ctx, d.index, d.id, []byte(`{}`))
+		}()
+		require.Eventually(t, func() bool { return len(bulker.ch) == i+1 }, time.Second, time.Millisecond)
+	}
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+	wg.Wait()
+
+	for i, d := range docs {
+		if d.id == "bad" {
+			require.Error(t, errs[i])
+		} else {
+			require.NoError(t, errs[i])
+		}
+	}
+
+	// One bulk request per index, each only targeting its index.
+	require.ElementsMatch(t, [][]string{{"idx-a"}, {"idx-b"}}, mock.requests)
+
+	require.Equal(t, requestsA+1, requests("idx-a"))
+	require.Equal(t, requestsB+1, requests("idx-b"))
+	require.Equal(t, errorsA, itemErrors("idx-a"))
+	require.Equal(t, errorsB+1, itemErrors("idx-b"))
+}
+
+// failIndexBulkTransport fails the bulk requests to failIndex and answers the others as indexBulkTransport does.
+type failIndexBulkTransport struct {
+	indexBulkTransport
+	failIndex string
+}
+
+func (m *failIndexBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(body), fmt.Sprintf(`"_index":%q`, m.failIndex)) {
+		return nil, errors.New("connection reset")
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return m.indexBulkTransport.Perform(req)
+}
+
+func TestFlushByIndexFailedRequestMulti(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	ops := []MultiOp{{Index: "idx-a", ID: "1", Body: []byte(`{}`)}, {Index: "idx-b", ID: "2", Body: []byte(`{}`)}, {Index: "idx-a", ID: "3", Body: []byte(`{}`)}}
+	bulker := NewBulker(&failIndexBulkTransport{failIndex: "idx-b"}, nil, WithFlushThresholdCount(len(ops)), WithFlushByIndex(true), WithOutputName("flush-by-index-multi"))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	// The failed request only fails the op to its index, reported at the position of the op.
+	_, errs, err := bulker.doMultiBulkOp(ctx, ActionIndex, ops)
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+}
+
+func TestBulkLatencyRecorded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	sample := func(o prometheus.Observer) (uint64, float64) {
+		var m dto.Metric
+		require.NoError(t, o.(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+	}
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(1))
+	tookCnt, tookSum := sample(bulker.metrics.esTook)
+	clientCnt, _ := sample(bulker.metrics.clientTime)
+
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+	_, err := bulker.Index(ctx, "testidx", "1", []byte(`{}`))
+	require.NoError(t, err)
+
+	// mockBulkTransport reports "took": 1 on every response.
+	cnt, sum := sample(bulker.metrics.esTook)
+	require.Equal(t, tookCnt+1, cnt)
+	require.InDelta(t, tookSum+0.001, sum, 1e-9)
+	cnt, _ = sample(bulker.metrics.clientTime)
+	require.Equal(t, clientCnt+1, cnt)
+}
+
+// rolloverBulkTransport answers the first bulk request as Elasticsearch does while the alias
+// is rolled over and has no write index, later requests land in the new write index.
+type rolloverBulkTransport struct {
+	calls int
+}
+
+func (m *rolloverBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.calls++
+	body := `{"took":1,"errors":false,"items":[{"index":{"_index":"testidx-000002","_id":"1","status":201}}]}`
+	if m.calls == 1 {
+		body = `{"took":1,"errors":true,"items":[{"index":{"_index":"testidx","_id":"1","status":400,` +
+			`"error":{"type":"illegal_argument_exception","reason":"no write index is defined for alias [testidx]. The write index may be explicitly disabled using is_write_index=false or the alias points to multiple indices without one being designated as a write index"}}}]}`
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestRolloverRetry(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	mock := &rolloverBulkTransport{}
+	bulker := NewBulker(mock, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	item, err := bulker.waitBulkAction(ctx, ActionIndex, "testidx", "1", []byte(`{"hey":"now"}`))
+	require.NoError(t, err)
+	require.Equal(t, 2, mock.calls)
+	require.Equal(t, "testidx-000002", item.Index)
+	require.Equal(t, "1", item.DocumentID)
+}
+
+// rolloverMultiTransport answers the first write of the document "2" as Elasticsearch does while the alias
+// is rolled over and has no write index, every other write lands in the new write index.
+type rolloverMultiTransport struct {
+	mu      sync.Mutex
+	written map[string]int
+}
+
+func (m *rolloverMultiTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var items []string
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	for i := 0; i < len(lines); i += 2 {
+		var meta map[string]struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(lines[i], &meta); err != nil {
+			return nil, err
+		}
+		id := meta["update"].ID
+		m.written[id]++
+		if id == "2" && m.written[id] == 1 {
+			items = append(items, `{"update":{"_index":"testidx","_id":"2","status":400,`+
+				`"error":{"type":"illegal_argument_exception","reason":"no write index is defined for alias [testidx]. The write index may be explicitly disabled using is_write_index=false or the alias points to multiple indices without one being designated as a write index"}}}`)
+			continue
+		}
+		items = append(items, `{"update":{"_index":"testidx-000002","_id":"`+id+`","status":200}}`)
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`)),
+	}, nil
+}
+
+func TestRolloverRetryMulti(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	mock := &rolloverMultiTransport{written: make(map[string]int)}
+	bulker := NewBulker(mock, nil, WithFlushThresholdCount(2), WithFlushInterval(10*time.Millisecond))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	items, err := bulker.MUpdate(ctx, []MultiOp{
+		{Index: "testidx", ID: "1", Body: []byte(`{"doc":{"hey":"now"}}`)},
+		{Index: "testidx", ID: "2", Body: []byte(`{"doc":{"hey":"now"}}`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	for i, item := range items {
+		require.Equal(t, "testidx-000002", item.Index)
+		require.Equal(t, strconv.Itoa(i+1), item.DocumentID)
+	}
+	// Only the op without a write index is sent again.
+	require.Equal(t, map[string]int{"1": 1, "2": 2}, mock.written)
+}
+
+// blockingSearchTransport holds msearch requests until released and tracks the searches in flight.
+type blockingSearchTransport struct {
+	release chan struct{}
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *blockingSearchTransport) searches() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inFlight, m.maxInFlight
+}
+
+func (m *blockingSearchTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Each search is a header and a body line.
+	n := bytes.Count(body, []byte("\n")) / 2
+
+	m.mu.Lock()
+	m.inFlight += n
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+
+	<-m.release
+
+	m.mu.Lock()
+	m.inFlight -= n
+	m.mu.Unlock()
+
+	responses := make([]string, n)
+	for i := range responses {
+		responses[i] = `{"status":200,"hits":{"hits":[]}}`
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"took":1,"responses":[` + strings.Join(responses, ",") + `]}`)),
+	}, nil
+}
+
+func TestSearchMaxParallel(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	const (
+		limit    = 2
+		searches = 5
+	)
+	mock := &blockingSearchTransport{release: make(chan struct{})}
+	bulker := NewBulker(mock, nil, WithFlushThresholdCount(1), WithMaxPending(searches), WithSearchMaxParallel(limit))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, searches)
+	for i := 0; i < searches; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := bulker.Search(ctx, "testidx", []byte(`{"query":{"match_all":{}}}`))
+			errs <- err
+		}()
+	}
+
+	// The searches beyond the limit are queued, not sent to Elasticsearch.
+	require.Eventually(t, func() bool {
+		inFlight, _ := mock.searches()
+		return inFlight == limit && testutil.ToFloat64(bulker.metrics.searchQueued) == searches-limit
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(limit), testutil.ToFloat64(bulker.metrics.searchActive))
+
+	close(mock.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	_, maxInFlight := mock.searches()
+	require.Equal(t, limit, maxInFlight)
+	require.Zero(t, testutil.ToFloat64(bulker.metrics.searchQueued))
+	require.Zero(t, testutil.ToFloat64(bulker.metrics.searchActive))
+}
+
+// refreshTransport models the visibility of indexed documents to searches.
+// Writes are pending until a refresh: a bulk request with refresh=true or refresh=wait_for
+// only returns once its documents are visible, otherwise they stay pending.
+// It records the refresh parameter of each bulk request.
+type refreshTransport struct {
+	mu      sync.Mutex
+	refresh []string
+	pending []string
+	visible []string
+}
+
+func (m *refreshTransport) visibleIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.visible...)
+}
+
+func (m *refreshTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var body string
+	if strings.HasSuffix(req.URL.Path, "/_msearch") {
+		hits := make([]string, 0, len(m.visible))
+		for _, id := range m.visible {
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_index":"testidx","_source":{}}`, id))
+		}
+		body = `{"took":1,"responses":[{"status":200,"hits":{"hits":[` + strings.Join(hits, ",") + `]}}]}`
+	} else {
+		var items []string
+		decoder := json.NewDecoder(req.Body)
+		for decoder.More() {
+			var meta map[string]struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			}
+			if err := decoder.Decode(&meta); err != nil {
+				return nil, err
+			}
+			var doc json.RawMessage
+			if err := decoder.Decode(&doc); err != nil {
+				return nil, err
+			}
+			for action, target := range meta {
+				m.pending = append(m.pending, target.ID)
+				items = append(items, fmt.Sprintf(`{%q:{"_index":%q,"_id":%q,"status":201}}`, action, target.Index, target.ID))
+			}
+		}
+		refresh := req.URL.Query().Get("refresh")
+		m.refresh = append(m.refresh, refresh)
+		if refresh == "true" || refresh == "wait_for" {
+			m.visible = append(m.visible, m.pending...)
+			m.pending = nil
+		}
+		body = `{"took":1,"errors":false,"items":[` + strings.Join(items, ",") + `]}`
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestRefreshModes(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	tests := []struct {
+		name    string
+		opts    []Opt
+		refresh string
+		visible bool
+	}{
+		{"no refresh", nil, "", false},
+		{"wait for refresh", []Opt{WithRefresh()}, "wait_for", true},
+		{"forced refresh", []Opt{WithForceRefresh()}, "true", true},
+		{"forced refresh wins", []Opt{WithRefresh(), WithForceRefresh()}, "true", true},
+		{"active shards wait for refresh", []Opt{WithRefresh(), WithWaitForActiveShards(1)}, "wait_for", true},
+		{"active shards forced refresh", []Opt{WithForceRefresh(), WithWaitForActiveShards(1)}, "true", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &refreshTransport{}
+			bulker := NewBulker(mock, nil, WithFlushThresholdCount(1))
+			go func() {
+				_ = bulker.Run(ctx)
+			}()
+
+			_, err := bulker.Create(ctx, "testidx", "1", []byte(`{"hey":"now"}`), tc.opts...)
+			require.NoError(t, err)
+			require.Equal(t, []string{tc.refresh}, mock.refresh)
+
+			// Read after write: a search issued once the write returned sees it.
+			res, err := bulker.Search(ctx, "testidx", []byte(`{"query":{"match_all":{}}}`))
+			require.NoError(t, err)
+			if tc.visible {
+				require.Len(t, res.Hits, 1)
+				require.Equal(t, "1", res.Hits[0].ID)
+			} else {
+				require.Empty(t, res.Hits)
+			}
+
+			// The multi operations honour the same options.
+			_, err = bulker.MCreate(ctx, []MultiOp{{Index: "testidx", ID: "2", Body: []byte(`{"hey":"now"}`)}}, tc.opts...)
+			require.NoError(t, err)
+			require.Equal(t, []string{tc.refresh, tc.refresh}, mock.refresh)
+			if tc.visible {
+				require.Contains(t, mock.visibleIDs(), "2")
+			}
+		})
+	}
+}
+
+// failingTransport fails every request as if Elasticsearch were unreachable.
+type failingTransport struct{}
+
+func (failingTransport) Perform(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestFlushErrorRecorded(t *testing.T) {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	bulker := NewBulker(failingTransport{}, nil, WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	before := time.Now().UTC()
+	err := bulker.Update(ctx, "testidx", "1", []byte(`{"doc":{"hey":"now"}}`))
+	require.Error(t, err)
+
+	var last *es.LastError
+	for _, e := range es.LastErrors() {
+		if e.Subsystem == es.SubsystemBulk {
+			last = &e
+		}
+	}
+	require.NotNil(t, last)
+	require.Contains(t, last.Message, "connection refused")
+	require.False(t, last.Timestamp.Before(before))
+}
@@ -0,0 +1,183 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var errNotMergeable = errors.New("update is not a partial document")
+
+// bulkOp is a single item of a bulk request.
+// It answers the block it was serialized from and any older blocks coalesced into it.
+type bulkOp struct {
+	buf       []byte
+	blk       *bulkT
+	coalesced []*bulkT
+	flags     flagsT // refresh flags requested by any of the blocks
+}
+
+// blocks returns the blocks answered by the op.
+func (op bulkOp) blocks() []*bulkT {
+	return append([]*bulkT{op.blk}, op.coalesced...)
+}
+
+// bulkOps lays out the queue as bulk request items.
+// When coalescing is enabled, updates queued for the same document are merged into a single update, see mergeUpdates.
+func (b *Bulker) bulkOps(queue queueT) []bulkOp {
+	ops := make([]bulkOp, 0, queue.cnt)
+
+	var groups map[docKey][]*bulkT
+	if b.opts.coalesceUpdates && queue.cnt > 1 {
+		groups = groupUpdates(queue)
+	}
+
+	for n := queue.head; n != nil; n = n.next {
+		group, ok := groups[n.doc]
+		if !ok {
+			ops = append(ops, bulkOp{buf: n.buf.Bytes(), blk: n, flags: n.flags})
+			continue
+		}
+		if group == nil {
+			// Already written at the position of the latest update
+			continue
+		}
+		groups[n.doc] = nil
+
+		buf, err := mergeUpdates(group)
+		if err != nil {
+			for _, g := range group {
+				ops = append(ops, bulkOp{buf: g.buf.Bytes(), blk: g, flags: g.flags})
+			}
+			continue
+		}
+		op := bulkOp{buf: buf, blk: group[0], coalesced: group[1:]}
+		for _, g := range group {
+			op.flags.Set(g.flags & (flagRefresh | flagForceRefresh))
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// groupUpdates returns the blocks of each document targeted by more than one action in the queue,
+// latest first, provided all of those actions are updates.
+func groupUpdates(queue queueT) map[docKey][]*bulkT {
+	type countT struct {
+		n       int
+		updates bool
+	}
+
+	counts := make(map[docKey]countT, queue.cnt)
+	for n := queue.head; n != nil; n = n.next {
+		if n.doc.id == "" {
+			continue
+		}
+		c, ok := counts[n.doc]
+		if !ok {
+			c.updates = true
+		}
+		c.n++
+		c.updates = c.updates && n.action == ActionUpdate
+		counts[n.doc] = c
+	}
+
+	var groups map[docKey][]*bulkT
+	for n := queue.head; n != nil; n = n.next {
+		if c := counts[n.doc]; c.n < 2 || !c.updates {
+			continue
+		}
+		if groups == nil {
+			groups = make(map[docKey][]*bulkT)
+		}
+		groups[n.doc] = append(groups[n.doc], n)
+	}
+	return groups
+}
+
+// mergeUpdates merges updates to the same document, given latest first, into a single update.
+//
+// The partial documents are applied in the order they were queued: objects are merged recursively
+// and any other value is replaced by the later update, as Elasticsearch does for partial document updates.
+// The merged item keeps the metadata of the update with the highest retry_on_conflict, the latest one on a tie.
+// Updates that are not a plain partial document, such as scripts or upserts, are not merged.
+func mergeUpdates(group []*bulkT) ([]byte, error) {
+	merged := make(map[string]interface{})
+	var meta []byte
+	retries := -1
+	for i := len(group) - 1; i >= 0; i-- {
+		m, body, ok := bytes.Cut(group[i].buf.Bytes(), []byte{'\n'})
+		if !ok {
+			return nil, errNotMergeable
+		}
+		r, err := retryOnConflict(m)
+		if err != nil {
+			return nil, err
+		}
+		if r >= retries {
+			meta, retries = m, r
+		}
+
+		var update map[string]json.RawMessage
+		if err := json.Unmarshal(body, &update); err != nil {
+			return nil, err
+		}
+		raw, ok := update["doc"]
+		if !ok || len(update) != 1 {
+			return nil, errNotMergeable
+		}
+
+		var doc map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return nil, errNotMergeable
+		}
+		mergeDoc(merged, doc)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"doc": merged})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(meta)+len(body)+2)
+	buf = append(buf, meta...)
+	buf = append(buf, '\n')
+	buf = append(buf, body...)
+	buf = append(buf, '\n')
+	return buf, nil
+}
+
+// retryOnConflict returns the retry_on_conflict of an update metadata line, 0 if unset.
+func retryOnConflict(meta []byte) (int, error) {
+	var m struct {
+		Update struct {
+			RetryOnConflict int `json:"retry_on_conflict"`
+		} `json:"update"`
+	}
+	if err := json.Unmarshal(meta, &m); err != nil {
+		return 0, err
+	}
+	return m.Update.RetryOnConflict, nil
+}
+
+func mergeDoc(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				mergeDoc(dm, sm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
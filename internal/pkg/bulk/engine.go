@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
@@ -84,11 +85,14 @@ type Bulker struct {
 	opts                  bulkOptT
 	blkPool               sync.Pool
 	apikeyLimit           *semaphore.Weighted
+	searchLimit           *semaphore.Weighted
+	metrics               bulkMetrics
 	tracer                *apm.Tracer
 	remoteOutputConfigMap map[string]map[string]interface{}
 	bulkerMap             map[string]Bulk
 	cancelFn              context.CancelFunc
 	remoteOutputMutex     sync.RWMutex
+	queued                atomic.Int64 // operations in the queues of Run, waiting for a flush
 }
 
 const (
@@ -99,6 +103,7 @@ const (
 	defaultBlockQueueSz      = 32 // Small capacity to allow multiOp to spin fast
 	defaultAPIKeyMaxParallel = 32
 	defaultApikeyMaxReqSize  = 100 * 1024 * 1024
+	defaultOutputName        = "default"
 )
 
 func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker {
@@ -109,12 +114,19 @@ func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker
 		return &bulkT{ch: make(chan respT, 1)}
 	}
 
+	var searchLimit *semaphore.Weighted
+	if bopts.searchMaxParallel > 0 {
+		searchLimit = semaphore.NewWeighted(int64(bopts.searchMaxParallel))
+	}
+
 	return &Bulker{
 		opts:                  bopts,
 		es:                    es,
 		ch:                    make(chan *bulkT, bopts.blockQueueSz),
 		blkPool:               sync.Pool{New: poolFunc},
 		apikeyLimit:           semaphore.NewWeighted(int64(bopts.apikeyMaxParallel)),
+		searchLimit:           searchLimit,
+		metrics:               newBulkMetrics(bopts.outputName),
 		tracer:                tracer,
 		remoteOutputConfigMap: make(map[string]map[string]interface{}),
 		// remote ES bulkers
@@ -165,7 +177,8 @@ func (b *Bulker) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, ou
 		return nil, hasConfigChanged, err
 	}
 	// starting a new bulker to create/update API keys for remote ES output
-	newBulker := NewBulker(es, b.tracer)
+	// the bulker has its own queues and flush loop so a slow remote output does not hold up the others
+	newBulker := NewBulker(es, b.tracer, b.remoteBulkOpts(outputName)...)
 	newBulker.cancelFn = bulkCancel
 
 	b.updateBulkerMap(outputName, newBulker)
@@ -192,6 +205,20 @@ func (b *Bulker) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, ou
 	return newBulker, hasConfigChanged, nil
 }
 
+// remoteBulkOpts returns the options of the bulker of a remote output, it flushes on the same thresholds as b.
+func (b *Bulker) remoteBulkOpts(outputName string) []BulkOpt {
+	return []BulkOpt{
+		WithFlushInterval(b.opts.flushInterval),
+		WithFlushThresholdCount(b.opts.flushThresholdCnt),
+		WithFlushThresholdSize(b.opts.flushThresholdSz),
+		WithMaxPending(b.opts.maxPending),
+		WithBlockQueueSize(b.opts.blockQueueSz),
+		WithFlushByIndex(b.opts.flushByIndex),
+		WithBi(b.opts.bi),
+		WithOutputName(outputName),
+	}
+}
+
 var newESClient = es.NewClient
 
 func (b *Bulker) createRemoteEsClient(ctx context.Context, outputName string, outputMap map[string]map[string]interface{}) (*elasticsearch.Client, error) {
@@ -292,7 +319,8 @@ func stopTimer(t *time.Timer) {
 func blkToQueueType(blk *bulkT) queueType {
 	queueIdx := kQueueBulk
 
-	forceRefresh := blk.flags.Has(flagRefresh)
+	refresh := blk.flags.Has(flagRefresh)
+	forceRefresh := blk.flags.Has(flagForceRefresh)
 
 	switch blk.action {
 	case ActionSearch:
@@ -300,7 +328,7 @@ func blkToQueueType(blk *bulkT) queueType {
 	case ActionFleetSearch:
 		queueIdx = kQueueFleetSearch
 	case ActionRead:
-		if forceRefresh {
+		if refresh || forceRefresh {
 			queueIdx = kQueueRefreshRead
 		} else {
 			queueIdx = kQueueRead
@@ -308,7 +336,12 @@ func blkToQueueType(blk *bulkT) queueType {
 	case ActionUpdateAPIKey:
 		queueIdx = kQueueAPIKeyUpdate
 	default:
-		if forceRefresh {
+		switch {
+		case blk.shards > 0:
+			queueIdx = kQueueActiveShardsBulk
+		case forceRefresh:
+			queueIdx = kQueueForceRefreshBulk
+		case refresh:
 			queueIdx = kQueueRefreshBulk
 		}
 	}
@@ -316,6 +349,11 @@ func blkToQueueType(blk *bulkT) queueType {
 	return queueIdx
 }
 
+// QueueDepth returns the number of operations waiting to be flushed to Elasticsearch.
+func (b *Bulker) QueueDepth() int {
+	return int(b.queued.Load()) + len(b.ch)
+}
+
 func (b *Bulker) Run(ctx context.Context) error {
 	var err error
 
@@ -359,6 +397,7 @@ func (b *Bulker) Run(ctx context.Context) error {
 		// Reset threshold counters
 		itemCnt = 0
 		byteCnt = 0
+		b.queued.Store(0)
 
 		return nil
 	}
@@ -383,6 +422,7 @@ func (b *Bulker) Run(ctx context.Context) error {
 			// Update threshold counters
 			itemCnt += 1
 			byteCnt += blk.buf.Len()
+			b.queued.Add(1)
 
 			// Start timer on first queued item
 			if itemCnt == 1 {
@@ -460,6 +500,9 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 
 		defer w.Release(1)
 
+		b.metrics.flushActive.Inc()
+		defer b.metrics.flushActive.Dec()
+
 		var err error
 		switch queue.ty {
 		case kQueueRead, kQueueRefreshRead:
@@ -474,6 +517,7 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 
 		if err != nil {
 			failQueue(queue, err)
+			es.RecordError(es.SubsystemBulk, err)
 			apm.CaptureError(ctx, err).Send()
 		}
 
@@ -496,6 +540,7 @@ func failQueue(queue queueT, err error) {
 		next := n.next // 'n' is invalid immediately on channel send
 		n.ch <- respT{
 			err: err,
+			idx: n.idx,
 		}
 		n = next
 	}
@@ -512,10 +557,10 @@ func (b *Bulker) parseOpts(opts ...Opt) optionsT {
 func (b *Bulker) newBlk(action actionT, opts optionsT) *bulkT {
 	blk := b.blkPool.Get().(*bulkT) //nolint:errcheck // we control what is placed in the pool
 	blk.action = action
-	if opts.Refresh {
-		blk.flags.Set(flagRefresh)
-	}
+	blk.flags.setRefresh(opts)
 	blk.spanLink = opts.spanLink
+	blk.spanContext = opts.spanContext
+	blk.shards = opts.ActiveShards
 
 	return blk
 }
@@ -568,7 +613,7 @@ func (b *Bulker) dispatch(ctx context.Context, blk *bulkT) respT {
 			Err(ctx.Err()).
 			Str("mod", kModBulk).
 			Str("action", blk.action.String()).
-			Bool("refresh", blk.flags.Has(flagRefresh)).
+			Str("refresh", blk.flags.refreshParam()).
 			Dur("rtt", time.Since(start)).
 			Msg("Dispatch abort queue")
 		return respT{err: ctx.Err()}
@@ -581,7 +626,7 @@ func (b *Bulker) dispatch(ctx context.Context, blk *bulkT) respT {
 			Err(resp.err).
 			Str("mod", kModBulk).
 			Str("action", blk.action.String()).
-			Bool("refresh", blk.flags.Has(flagRefresh)).
+			Str("refresh", blk.flags.refreshParam()).
 			Dur("rtt", time.Since(start)).
 			Msg("Dispatch OK")
 
@@ -591,7 +636,7 @@ func (b *Bulker) dispatch(ctx context.Context, blk *bulkT) respT {
 			Err(ctx.Err()).
 			Str("mod", kModBulk).
 			Str("action", blk.action.String()).
-			Bool("refresh", blk.flags.Has(flagRefresh)).
+			Str("refresh", blk.flags.refreshParam()).
 			Dur("rtt", time.Since(start)).
 			Msg("Dispatch abort response")
 	}
@@ -0,0 +1,109 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelOutput is the name of the output a bulker sends its requests to.
+const labelOutput = "output"
+
+// labelIndex is the target index of the writes of a bulk request flushed by index.
+const labelIndex = "index"
+
+// Latency of the bulk requests split between the time Elasticsearch reports it spent processing
+// the request (took) and the remaining network and client time of the round trip.
+var (
+	esTookHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bulk",
+		Name:      "es_took_seconds",
+		Help:      "Bulk request processing time reported by Elasticsearch.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{labelOutput})
+	clientTimeHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bulk",
+		Name:      "client_seconds",
+		Help:      "Bulk request round trip time not spent processing in Elasticsearch.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{labelOutput})
+)
+
+// Bulk requests and failed writes by target index, only recorded when the writes are flushed by index.
+var (
+	indexRequestHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bulk",
+		Name:      "index_request_seconds",
+		Help:      "Round trip time of the bulk requests flushed by index.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{labelOutput, labelIndex})
+	indexItemErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bulk",
+		Name:      "index_item_errors_total",
+		Help:      "Writes of the bulk requests flushed by index that failed.",
+	}, []string{labelOutput, labelIndex})
+)
+
+// Searches in flight and searches waiting for a slot when search_max_parallel is set.
+var (
+	searchActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bulk",
+		Name:      "search_active",
+		Help:      "Searches in flight to Elasticsearch.",
+	}, []string{labelOutput})
+	searchQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bulk",
+		Name:      "search_queued",
+		Help:      "Searches waiting for a slot under the search concurrency limit.",
+	}, []string{labelOutput})
+)
+
+// Flushes of the bulker queues waiting on a response from Elasticsearch.
+var flushActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "bulk",
+	Name:      "flush_active",
+	Help:      "Bulker queue flushes in flight to Elasticsearch.",
+}, []string{labelOutput})
+
+// Collectors returns the bulk request metrics to be registered with a metrics registry.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{esTookHist, clientTimeHist, indexRequestHist, indexItemErrors, searchActive, searchQueued, flushActive}
+}
+
+// bulkMetrics are the metrics of a single bulker, labeled with the name of its output.
+type bulkMetrics struct {
+	esTook       prometheus.Observer
+	clientTime   prometheus.Observer
+	indexRequest prometheus.ObserverVec
+	indexErrors  *prometheus.CounterVec
+	searchActive prometheus.Gauge
+	searchQueued prometheus.Gauge
+	flushActive  prometheus.Gauge
+}
+
+func newBulkMetrics(output string) bulkMetrics {
+	return bulkMetrics{
+		esTook:       esTookHist.WithLabelValues(output),
+		clientTime:   clientTimeHist.WithLabelValues(output),
+		indexRequest: indexRequestHist.MustCurryWith(prometheus.Labels{labelOutput: output}),
+		indexErrors:  indexItemErrors.MustCurryWith(prometheus.Labels{labelOutput: output}),
+		searchActive: searchActive.WithLabelValues(output),
+		searchQueued: searchQueued.WithLabelValues(output),
+		flushActive:  flushActive.WithLabelValues(output),
+	}
+}
+
+// observeLatency records the took value of a bulk response against the measured round trip time.
+func (m *bulkMetrics) observeLatency(took int, rtt time.Duration) {
+	esTook := time.Duration(took) * time.Millisecond
+	m.esTook.Observe(esTook.Seconds())
+	if client := rtt - esTook; client > 0 {
+		m.clientTime.Observe(client.Seconds())
+	} else {
+		m.clientTime.Observe(0)
+	}
+}
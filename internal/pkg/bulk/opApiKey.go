@@ -11,9 +11,11 @@ import (
 	"math"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -118,6 +120,7 @@ func (b *Bulker) flushUpdateAPIKey(ctx context.Context, queue queueT) error {
 	IDToResponse := make(map[string]int)
 	maxKeySize := 0
 	links := []apm.SpanLink{}
+	var otelLinks []trace.Link
 
 	// merge ids
 	for n := queue.head; n != nil; n = n.next {
@@ -155,6 +158,9 @@ func (b *Bulker) flushUpdateAPIKey(ctx context.Context, queue queueT) error {
 		if n.spanLink != nil {
 			links = append(links, *n.spanLink)
 		}
+		if l, ok := n.otelLink(); ok {
+			otelLinks = append(otelLinks, l)
+		}
 	}
 
 	if len(links) == 0 {
@@ -164,6 +170,8 @@ func (b *Bulker) flushUpdateAPIKey(ctx context.Context, queue queueT) error {
 		Links: links,
 	})
 	defer span.End()
+	ctx, otelSpan := telemetry.StartLinked(ctx, "Flush: apiKeyUpdate", dedupeLinks(otelLinks))
+	defer otelSpan.End()
 
 	for id, roleHash := range rolePerID {
 		delete(rolePerID, id)
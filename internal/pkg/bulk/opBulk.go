@@ -9,14 +9,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/mailru/easyjson"
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
+)
+
+const (
+	// rolloverMaxRetries bounds the retries of an action while its alias has no write index.
+	rolloverMaxRetries   = 3
+	rolloverRetryBackoff = 100 * time.Millisecond
 )
 
 func (b *Bulker) Create(ctx context.Context, index, id string, body []byte, opts ...Opt) (string, error) {
@@ -46,11 +56,37 @@ func (b *Bulker) Delete(ctx context.Context, index, id string, opts ...Opt) erro
 	return err
 }
 
+// waitBulkAction queues the action and waits for its result.
+//
+// Writes always go through index as given, an alias or data stream is resolved by Elasticsearch on every request.
+// While the alias is rolled over it may briefly have no write index, the action is then retried so it lands in
+// the new write index.
 func (b *Bulker) waitBulkAction(ctx context.Context, action actionT, index, id string, body []byte, opts ...Opt) (*BulkIndexerResponseItem, error) {
 	span, ctx := apm.StartSpan(ctx, fmt.Sprintf("Bulker: %s", action.String()), "bulker")
 	defer span.End()
-	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx))...)
+	ctx, otelSpan := telemetry.Start(ctx, fmt.Sprintf("Bulker: %s", action.String()), telemetry.AttrIndex.String(index))
+	defer otelSpan.End()
+
+	for attempt := 1; ; attempt++ {
+		item, err := b.doBulkAction(ctx, action, index, id, body, opts...)
+		if !errors.Is(err, es.ErrNoWriteIndex) || attempt > rolloverMaxRetries {
+			return item, err
+		}
+		zerolog.Ctx(ctx).Debug().Err(err).
+			Str("mod", kModBulk).
+			Str("index", index).
+			Int("attempt", attempt).
+			Msg("No write index during rollover, retrying bulk action")
+		if err := sleep.WithContext(ctx, time.Duration(attempt)*rolloverRetryBackoff); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (b *Bulker) doBulkAction(ctx context.Context, action actionT, index, id string, body []byte, opts ...Opt) (*BulkIndexerResponseItem, error) {
+	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx), withOTelLinkedContext(ctx))...)
 	blk := b.newBlk(action, opt)
+	blk.doc = docKey{index, id}
 
 	// Serialize request
 	const kSlop = 64
@@ -166,26 +202,101 @@ func (b *Bulker) calcBulkSz(action, idx, id, retry string, body []byte) int {
 }
 
 func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
+	ops := b.bulkOps(queue)
+	if !b.opts.flushByIndex {
+		return b.flushBulkOps(ctx, queue, ops, "")
+	}
+
+	// Each index is sent in its own request, a failed request only fails the writes to its index.
+	for _, group := range groupOpsByIndex(ops) {
+		if err := b.flushBulkOps(ctx, queue, group.ops, group.index); err != nil {
+			writes := 0
+			for _, op := range group.ops {
+				for _, n := range op.blocks() {
+					respond(n, nil, err)
+					writes++
+				}
+			}
+			b.metrics.indexErrors.WithLabelValues(group.index).Add(float64(writes))
+			apm.CaptureError(ctx, err).Send()
+		}
+	}
+	return nil
+}
+
+// indexOps are the bulk request items targeting an index.
+type indexOps struct {
+	index string
+	ops   []bulkOp
+}
+
+// groupOpsByIndex splits ops by target index, in the order the indices first appear.
+func groupOpsByIndex(ops []bulkOp) []indexOps {
+	var groups []indexOps
+	pos := make(map[string]int)
+	for _, op := range ops {
+		i, ok := pos[op.blk.doc.index]
+		if !ok {
+			i = len(groups)
+			pos[op.blk.doc.index] = i
+			groups = append(groups, indexOps{index: op.blk.doc.index})
+		}
+		groups[i].ops = append(groups[i].ops, op)
+	}
+	return groups
+}
+
+// flushBulkOps sends ops in a single bulk request and answers their blocks.
+// index is the target index of all the ops when the queue is flushed by index, empty otherwise.
+func (b *Bulker) flushBulkOps(ctx context.Context, queue queueT, ops []bulkOp, index string) error {
 	start := time.Now()
 
 	const kRoughEstimatePerItem = 200
 
-	bufSz := queue.cnt * kRoughEstimatePerItem
-	if bufSz < queue.pending {
-		bufSz = queue.pending
+	var pending int
+	for i := range ops {
+		pending += len(ops[i].buf)
+	}
+	bufSz := len(ops) * kRoughEstimatePerItem
+	if bufSz < pending {
+		bufSz = pending
 	}
 
 	var buf bytes.Buffer
 	buf.Grow(bufSz)
 
-	queueCnt := 0
 	links := []apm.SpanLink{}
-	for n := queue.head; n != nil; n = n.next {
-		buf.Write(n.buf.Bytes())
-		queueCnt += 1
-		if n.spanLink != nil {
-			links = append(links, *n.spanLink)
+	var otelLinks []trace.Link
+	var refresh flagsT
+	switch queue.ty {
+	case kQueueRefreshBulk:
+		refresh.Set(flagRefresh)
+	case kQueueForceRefreshBulk:
+		refresh.Set(flagForceRefresh)
+	}
+	var shards int
+	for i := range ops {
+		for _, n := range ops[i].blocks() {
+			if n.spanLink != nil {
+				links = append(links, *n.spanLink)
+			}
+			if l, ok := n.otelLink(); ok {
+				otelLinks = append(otelLinks, l)
+			}
+			// Writes waiting on active shards share a queue; honour the strictest
+			// requirement any of them asked for.
+			if queue.ty == kQueueActiveShardsBulk && n.shards > shards {
+				shards = n.shards
+			}
 		}
+		// And the strongest refresh, including the ones of the updates coalesced into the op.
+		if queue.ty == kQueueActiveShardsBulk {
+			refresh.Set(ops[i].flags & (flagRefresh | flagForceRefresh))
+		}
+	}
+
+	for i := range ops {
+		buf.Write(ops[i].buf)
 	}
 
 	// We should not encounter a case outside of testing where blk instances have no links
@@ -197,14 +308,20 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 		Links: links,
 	})
 	defer span.End()
+	if index != "" {
+		span.Context.SetLabel("index", index)
+	}
+	ctx, otelSpan := telemetry.StartLinked(ctx, fmt.Sprintf("Flush: %s", queue.Type()), dedupeLinks(otelLinks), telemetry.AttrIndex.String(index))
+	defer otelSpan.End()
 
 	// Do actual bulk request; defer to the client
 	req := esapi.BulkRequest{
 		Body: bytes.NewReader(buf.Bytes()),
 	}
 
-	if queue.ty == kQueueRefreshBulk {
-		req.Refresh = "true"
+	req.Refresh = refresh.refreshParam()
+	if shards > 0 {
+		req.WaitForActiveShards = strconv.Itoa(shards)
 	}
 
 	res, err := req.Do(ctx, b.es)
@@ -235,7 +352,7 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 	}
 
 	var blk bulkIndexerResponse
-	blk.Items = make([]bulkStubItem, 0, queueCnt)
+	blk.Items = make([]bulkStubItem, 0, len(ops))
 
 	// TODO: We're loosing information abut the errors, we should check a way
 	// to return the full error ES returns
@@ -251,19 +368,27 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 		zerolog.Ctx(ctx).Debug().Err(errors.New(buf.String())).Msg("Bulk call: Es returned an error")
 	}
 
+	rtt := time.Since(start)
+	b.metrics.observeLatency(blk.Took, rtt)
+	if index != "" {
+		b.metrics.indexRequest.WithLabelValues(index).Observe(rtt.Seconds())
+	}
+
 	zerolog.Ctx(ctx).Trace().
 		Err(err).
-		Bool("refresh", queue.ty == kQueueRefreshBulk).
+		Str("refresh", req.Refresh).
 		Str("mod", kModBulk).
 		Int("took", blk.Took).
-		Dur("rtt", time.Since(start)).
+		Dur("rtt", rtt).
 		Bool("hasErrors", blk.HasErrors).
 		Int("cnt", len(blk.Items)).
+		Str("index", index).
+		Int("coalesced", queue.cnt-len(ops)).
 		Int("bufSz", bufSz).
 		Int64("bodySz", bodySz).
 		Msg("flushBulk")
 
-	if len(blk.Items) != queueCnt {
+	if len(blk.Items) != len(ops) {
 		return fmt.Errorf("Bulk queue length mismatch")
 	}
 
@@ -272,27 +397,37 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 	// Do NOT return a non-nil value or failQueue
 	// up the stack will fail.
 
-	n := queue.head
 	for i := range blk.Items {
-		next := n.next // 'n' is invalid immediately on channel send
-
 		item := blk.Items[i].Choose()
-		select {
-		case n.ch <- respT{
-			err:  item.deriveError(),
-			idx:  n.idx,
-			data: item,
-		}:
-		default:
-			panic("Unexpected blocked response channel on flushBulk")
+		err := item.deriveError()
+		respond(ops[i].blk, item, err)
+		for _, n := range ops[i].coalesced {
+			respond(n, item, err)
+		}
+		if err != nil && index != "" {
+			b.metrics.indexErrors.WithLabelValues(index).Add(float64(1 + len(ops[i].coalesced)))
 		}
-
-		n = next
 	}
 
 	return nil
 }
 
+// respond answers n without blocking the flush, item is nil if the request failed.
+func respond(n *bulkT, item *BulkIndexerResponseItem, err error) {
+	resp := respT{
+		err: err,
+		idx: n.idx,
+	}
+	if item != nil {
+		resp.data = item
+	}
+	select {
+	case n.ch <- resp:
+	default:
+		panic("Unexpected blocked response channel on flushBulk")
+	}
+}
+
 func (b *Bulker) HasTracer() bool {
 	return b.tracer != nil
 }
@@ -8,27 +8,37 @@ import (
 	"context"
 	"errors"
 	"math"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
 )
 
 // TODO: Are multi requests used by anything? a quick grep shows no hits outside the bulk package.
 
 func (b *Bulker) MCreate(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionCreate, ops)
+	return b.multiWaitBulkOp(ctx, ActionCreate, ops, opts...)
 }
 
 func (b *Bulker) MIndex(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionIndex, ops)
+	return b.multiWaitBulkOp(ctx, ActionIndex, ops, opts...)
 }
 
 func (b *Bulker) MUpdate(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionUpdate, ops)
+	return b.multiWaitBulkOp(ctx, ActionUpdate, ops, opts...)
 }
 
 func (b *Bulker) MDelete(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionDelete, ops)
+	return b.multiWaitBulkOp(ctx, ActionDelete, ops, opts...)
 }
 
-func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) { //nolint:unparam // better to keep consistency with other methods
+// multiWaitBulkOp queues the ops and waits for their results.
+//
+// As for single actions, the ops that fail because their alias has no write index during a rollover are retried;
+// the ops that succeeded are not sent again.
+func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
 	if len(ops) == 0 {
 		return nil, nil
 	}
@@ -37,7 +47,55 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 		return nil, errors.New("too many bulk ops")
 	}
 
-	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx))...)
+	items := make([]BulkIndexerResponseItem, len(ops))
+	errs := make([]error, len(ops))
+	pending := make([]int, len(ops))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		batch := make([]MultiOp, len(pending))
+		for j, i := range pending {
+			batch[j] = ops[i]
+		}
+		batchItems, batchErrs, err := b.doMultiBulkOp(ctx, action, batch, opts...)
+		if err != nil {
+			return nil, err
+		}
+		var retry []int
+		for j, i := range pending {
+			items[i], errs[i] = batchItems[j], batchErrs[j]
+			if errors.Is(errs[i], es.ErrNoWriteIndex) {
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || attempt > rolloverMaxRetries {
+			break
+		}
+		zerolog.Ctx(ctx).Debug().
+			Str("mod", kModBulk).
+			Int("retries", len(retry)).
+			Int("attempt", attempt).
+			Msg("No write index during rollover, retrying bulk ops")
+		if err := sleep.WithContext(ctx, time.Duration(attempt)*rolloverRetryBackoff); err != nil {
+			return nil, err
+		}
+		pending = retry
+	}
+
+	var lastErr error
+	for _, err := range errs {
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return items, lastErr
+}
+
+// doMultiBulkOp dispatches the ops and returns the result and error of each.
+// The returned error is set if the ops could not be dispatched.
+func (b *Bulker) doMultiBulkOp(ctx context.Context, action actionT, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, []error, error) {
+	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx), withOTelLinkedContext(ctx))...)
 
 	// Contract is that consumer never blocks, so must preallocate.
 	// Could consider making the response channel *respT to limit memory usage.
@@ -68,11 +126,11 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 		op := &ops[i]
 
 		if err := b.writeBulkMeta(&bulkBuf, actionStr, op.Index, op.ID, opt.RetryOnConflict); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if err := b.writeBulkBody(&bulkBuf, action, op.Body); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		bodySlice := bulkBuf.Bytes()[bufIdx:]
@@ -81,36 +139,35 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 		bulk.ch = ch
 		bulk.idx = int32(i)
 		bulk.action = action
+		bulk.doc = docKey{op.Index, op.ID}
 		bulk.buf.Set(bodySlice)
-		if opt.Refresh {
-			bulk.flags.Set(flagRefresh)
-		}
+		bulk.flags.setRefresh(opt)
+		bulk.spanLink = opt.spanLink
+		bulk.spanContext = opt.spanContext
 	}
 
 	// Dispatch requests
 	if err := b.multiDispatch(ctx, bulks); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Wait for response and populate return slice
-	var lastErr error
+	// Wait for response and populate return slices
 	items := make([]BulkIndexerResponseItem, len(ops))
+	errs := make([]error, len(ops))
 
 	for i := 0; i < len(ops); i++ {
 		select {
 		case r := <-ch:
-			if r.err != nil {
-				lastErr = r.err
-			}
+			errs[r.idx] = r.err
 			if r.data != nil {
 				items[r.idx] = *r.data.(*BulkIndexerResponseItem)
 			}
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
 		}
 	}
 
-	return items, lastErr
+	return items, errs, nil
 }
 
 func (b *Bulker) multiDispatch(ctx context.Context, blks []bulkT) error {
@@ -14,6 +14,9 @@ import (
 	"github.com/mailru/easyjson"
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 )
 
 const (
@@ -24,7 +27,9 @@ const (
 func (b *Bulker) Read(ctx context.Context, index, id string, opts ...Opt) ([]byte, error) {
 	span, ctx := apm.StartSpan(ctx, "Bulker: read", "bulker")
 	defer span.End()
-	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx))...)
+	ctx, otelSpan := telemetry.Start(ctx, "Bulker: read", telemetry.AttrIndex.String(index))
+	defer otelSpan.End()
+	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx), withOTelLinkedContext(ctx))...)
 	blk := b.newBlk(ActionRead, opt)
 
 	// Serialize request
@@ -68,12 +73,16 @@ func (b *Bulker) flushRead(ctx context.Context, queue queueT) error {
 	// Each item a JSON array element followed by comma
 	queueCnt := 0
 	links := []apm.SpanLink{}
+	var otelLinks []trace.Link
 	for n := queue.head; n != nil; n = n.next {
 		buf.Write(n.buf.Bytes())
 		queueCnt += 1
 		if n.spanLink != nil {
 			links = append(links, *n.spanLink)
 		}
+		if l, ok := n.otelLink(); ok {
+			otelLinks = append(otelLinks, l)
+		}
 	}
 	if len(links) == 0 {
 		links = nil
@@ -82,6 +91,8 @@ func (b *Bulker) flushRead(ctx context.Context, queue queueT) error {
 		Links: links,
 	})
 	defer span.End()
+	ctx, otelSpan := telemetry.StartLinked(ctx, "Flush: read", dedupeLinks(otelLinks))
+	defer otelSpan.End()
 
 	// Need to strip the last element and append the suffix
 	payload := buf.Bytes()
@@ -13,16 +13,20 @@ import (
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/mailru/easyjson"
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (b *Bulker) Search(ctx context.Context, index string, body []byte, opts ...Opt) (*es.ResultT, error) {
 	span, ctx := apm.StartSpan(ctx, "Bulker: search", "bulker")
 	defer span.End()
-	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx))...)
+	ctx, otelSpan := telemetry.Start(ctx, "Bulker: search", telemetry.AttrIndex.String(index))
+	defer otelSpan.End()
+	opt := b.parseOpts(append(opts, withAPMLinkedContext(ctx), withOTelLinkedContext(ctx))...)
 	action := ActionSearch
 
 	// Use /_fleet/_fleet_msearch fleet plugin endpoint if need to wait for checkpoints
@@ -43,6 +47,13 @@ func (b *Bulker) Search(ctx context.Context, index string, body []byte, opts ...
 		return nil, err
 	}
 
+	// Bound the searches in flight so Elasticsearch search thread pools are not overwhelmed
+	release, err := b.acquireSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Process response
 	resp := b.dispatch(ctx, blk)
 	if resp.err != nil {
@@ -127,12 +138,16 @@ func (b *Bulker) flushSearch(ctx context.Context, queue queueT) error {
 
 	queueCnt := 0
 	links := []apm.SpanLink{}
+	var otelLinks []trace.Link
 	for n := queue.head; n != nil; n = n.next {
 		buf.Write(n.buf.Bytes())
 		queueCnt += 1
 		if n.spanLink != nil {
 			links = append(links, *n.spanLink)
 		}
+		if l, ok := n.otelLink(); ok {
+			otelLinks = append(otelLinks, l)
+		}
 	}
 	if len(links) == 0 {
 		links = nil
@@ -141,6 +156,8 @@ func (b *Bulker) flushSearch(ctx context.Context, queue queueT) error {
 		Links: links,
 	})
 	defer span.End()
+	ctx, otelSpan := telemetry.StartLinked(ctx, "Flush: search", dedupeLinks(otelLinks))
+	defer otelSpan.End()
 
 	// Do actual bulk request; and send response on chan
 	var (
@@ -230,3 +247,22 @@ func (b *Bulker) flushSearch(ctx context.Context, queue queueT) error {
 
 	return nil
 }
+
+// acquireSearch waits for a search slot when searches are limited, the returned function releases it.
+func (b *Bulker) acquireSearch(ctx context.Context) (func(), error) {
+	if b.searchLimit == nil {
+		b.metrics.searchActive.Inc()
+		return b.metrics.searchActive.Dec, nil
+	}
+	b.metrics.searchQueued.Inc()
+	err := b.searchLimit.Acquire(ctx, 1)
+	b.metrics.searchQueued.Dec()
+	if err != nil {
+		return nil, err
+	}
+	b.metrics.searchActive.Inc()
+	return func() {
+		b.metrics.searchActive.Dec()
+		b.searchLimit.Release(1)
+	}, nil
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
@@ -21,20 +22,35 @@ import (
 
 type optionsT struct {
 	Refresh            bool
+	ForceRefresh       bool
 	RetryOnConflict    string
 	Indices            []string
 	WaitForCheckpoints []int64
+	ActiveShards       int
 	spanLink           *apm.SpanLink
+	spanContext        trace.SpanContext
 }
 
 type Opt func(*optionsT)
 
+// WithRefresh makes a write visible to searches before it returns, with refresh=wait_for.
+// Elasticsearch does not refresh the index for the write but waits for its next scheduled refresh.
+// Applicable to create, index, update and delete; reads refresh the shards before the mget.
 func WithRefresh() Opt {
 	return func(opt *optionsT) {
 		opt.Refresh = true
 	}
 }
 
+// WithForceRefresh refreshes the shards the write touched before it returns, with refresh=true.
+// It gives the same read-after-write guarantee as WithRefresh without waiting on the refresh interval,
+// at the cost of creating small segments; prefer WithRefresh.
+func WithForceRefresh() Opt {
+	return func(opt *optionsT) {
+		opt.ForceRefresh = true
+	}
+}
+
 func WithRetryOnConflict(n int) Opt {
 	return func(opt *optionsT) {
 		opt.RetryOnConflict = strconv.Itoa(n)
@@ -56,6 +72,15 @@ func WithWaitForCheckpoints(checkpoints []int64) Opt {
 	}
 }
 
+// WithWaitForActiveShards sets the wait_for_active_shards parameter.
+// Applicable to create, index, update and delete; the write fails once
+// the number of active shard copies is not reached within the bulk timeout.
+func WithWaitForActiveShards(n int) Opt {
+	return func(opt *optionsT) {
+		opt.ActiveShards = n
+	}
+}
+
 func withAPMLinkedContext(ctx context.Context) Opt {
 	return func(opt *optionsT) {
 		trace := apm.TransactionFromContext(ctx)
@@ -70,6 +95,24 @@ func withAPMLinkedContext(ctx context.Context) Opt {
 	}
 }
 
+// WithSpanContext links the flush of the operation to the span sc.
+// By default the flush is linked to the OpenTelemetry span of the context the operation is called with;
+// the option is needed when the operation is issued outside of the originating request span, e.g. from a goroutine.
+func WithSpanContext(sc trace.SpanContext) Opt {
+	return func(opt *optionsT) {
+		opt.spanContext = sc
+	}
+}
+
+func withOTelLinkedContext(ctx context.Context) Opt {
+	return func(opt *optionsT) {
+		if opt.spanContext.IsValid() {
+			return
+		}
+		opt.spanContext = trace.SpanContextFromContext(ctx)
+	}
+}
+
 //-----
 // Bulk API options
 
@@ -80,9 +123,13 @@ type bulkOptT struct {
 	maxPending        int
 	blockQueueSz      int
 	apikeyMaxParallel int
+	searchMaxParallel int
 	apikeyMaxReqSize  int
 	policyTokens      []config.PolicyToken
 	bi                build.Info
+	coalesceUpdates   bool
+	flushByIndex      bool
+	outputName        string
 }
 
 type BulkOpt func(*bulkOptT)
@@ -129,6 +176,13 @@ func WithAPIKeyMaxParallel(max int) BulkOpt {
 	}
 }
 
+// WithSearchMaxParallel sets the number of searches outstanding, searches beyond it wait for a slot. Default 0 is unlimited
+func WithSearchMaxParallel(max int) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.searchMaxParallel = max
+	}
+}
+
 // WithAPIKeyMaxRequestSize sets the maximum size of the request body. Default 100MB
 func WithAPIKeyMaxRequestSize(maxBytes int) BulkOpt {
 	return func(opt *bulkOptT) {
@@ -145,6 +199,27 @@ func WithPolicyTokens(tokens []config.PolicyToken) BulkOpt {
 	}
 }
 
+// WithCoalesceUpdates sets whether updates to the same document within a flush are merged into a single update. Default true
+func WithCoalesceUpdates(enabled bool) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.coalesceUpdates = enabled
+	}
+}
+
+// WithFlushByIndex sets whether the writes of a flush are sent in one bulk request per target index. Default false
+func WithFlushByIndex(enabled bool) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.flushByIndex = enabled
+	}
+}
+
+// WithOutputName sets the name of the output the bulker sends its requests to, used to label its metrics. Default "default"
+func WithOutputName(name string) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.outputName = name
+	}
+}
+
 func WithBi(bi build.Info) BulkOpt {
 	return func(opt *bulkOptT) {
 		opt.bi = bi
@@ -161,6 +236,8 @@ func parseBulkOpts(opts ...BulkOpt) bulkOptT {
 		blockQueueSz:      defaultBlockQueueSz,
 		apikeyMaxReqSize:  defaultApikeyMaxReqSize,
 		policyTokens:      []config.PolicyToken{}, // default is empty
+		coalesceUpdates:   true,
+		outputName:        defaultOutputName,
 	}
 
 	for _, f := range opts {
@@ -177,7 +254,11 @@ func (o *bulkOptT) MarshalZerologObject(e *zerolog.Event) {
 	e.Int("maxPending", o.maxPending)
 	e.Int("blockQueueSz", o.blockQueueSz)
 	e.Int("apikeyMaxParallel", o.apikeyMaxParallel)
+	e.Int("searchMaxParallel", o.searchMaxParallel)
 	e.Int("apikeyMaxReqSize", o.apikeyMaxReqSize)
+	e.Bool("coalesceUpdates", o.coalesceUpdates)
+	e.Bool("flushByIndex", o.flushByIndex)
+	e.Str("outputName", o.outputName)
 }
 
 // BulkOptsFromCfg transforms config to a slize of BulkOpt
@@ -200,6 +281,8 @@ func BulkOptsFromCfg(cfg *config.Config) []BulkOpt {
 		WithFlushThresholdSize(bulkCfg.FlushThresholdSize),
 		WithMaxPending(bulkCfg.FlushMaxPending),
 		WithAPIKeyMaxParallel(maxKeyParallel),
+		WithSearchMaxParallel(bulkCfg.SearchMaxParallel),
+		WithFlushByIndex(bulkCfg.FlushByIndex),
 		WithAPIKeyMaxRequestSize(cfg.Output.Elasticsearch.MaxContentLength),
 		WithPolicyTokens(policyTokens),
 	}
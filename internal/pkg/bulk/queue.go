@@ -21,6 +21,8 @@ const (
 	kQueueRefreshBulk
 	kQueueRefreshRead
 	kQueueAPIKeyUpdate
+	kQueueActiveShardsBulk
+	kQueueForceRefreshBulk
 	kNumQueues
 )
 
@@ -40,6 +42,10 @@ func (q queueT) Type() string {
 		return "refreshRead"
 	case kQueueAPIKeyUpdate:
 		return "apiKeyUpdate"
+	case kQueueActiveShardsBulk:
+		return "activeShardsBulk"
+	case kQueueForceRefreshBulk:
+		return "forceRefreshBulk"
 	}
 	panic("unknown")
 }
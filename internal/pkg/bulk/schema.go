@@ -44,7 +44,7 @@ type bulkIndexerResponse struct {
 //
 // Comment out fields we don't use; no point decoding.
 type BulkIndexerResponseItem struct {
-	//	Index      string `json:"_index"`
+	Index      string `json:"_index"`
 	DocumentID string `json:"_id"`
 	//	Version    int64  `json:"_version"`
 	//	Result     string `json:"result"`
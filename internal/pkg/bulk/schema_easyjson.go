@@ -1218,6 +1218,8 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jle
 			continue
 		}
 		switch key {
+		case "_index":
+			out.Index = string(in.String())
 		case "_id":
 			out.DocumentID = string(in.String())
 		case "status":
@@ -1241,8 +1243,13 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out *jw
 	first := true
 	_ = first
 	{
-		const prefix string = ",\"_id\":"
+		const prefix string = ",\"_index\":"
 		out.RawString(prefix[1:])
+		out.String(string(in.Index))
+	}
+	{
+		const prefix string = ",\"_id\":"
+		out.RawString(prefix)
 		out.String(string(in.DocumentID))
 	}
 	{
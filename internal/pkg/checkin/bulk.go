@@ -8,20 +8,29 @@ package checkin
 import (
 	"context"
 	"encoding/json"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const defaultFlushInterval = 10 * time.Second
+const (
+	defaultFlushInterval      = 10 * time.Second
+	defaultRecoveryBufferSize = 10000
+)
 
 type optionsT struct {
-	flushInterval time.Duration
+	flushInterval      time.Duration
+	recoveryBufferSize int
 }
 
 type Opt func(*optionsT)
@@ -32,6 +41,14 @@ func WithFlushInterval(d time.Duration) Opt {
 	}
 }
 
+// WithRecoveryBufferSize sets the maximum number of agents whose failed checkin
+// writes are retained and replayed on a later flush. A size of 0 disables the buffer.
+func WithRecoveryBufferSize(n int) Opt {
+	return func(opt *optionsT) {
+		opt.recoveryBufferSize = n
+	}
+}
+
 type extraT struct {
 	meta       []byte
 	seqNo      sqn.SeqNo
@@ -47,6 +64,7 @@ type pendingT struct {
 	status  string
 	message string
 	extra   *extraT
+	span    *trace.SpanContext // span of the checkin the write is linked to, nil if none
 }
 
 // Bulk will batch pending checkins and update elasticsearch at a set interval.
@@ -56,6 +74,9 @@ type Bulk struct {
 	mut     sync.Mutex
 	pending map[string]pendingT
 
+	// recovery holds the latest state of agents whose last flush failed.
+	recovery map[string]pendingT
+
 	ts   string
 	unix int64
 }
@@ -64,16 +85,18 @@ func NewBulk(bulker bulk.Bulk, opts ...Opt) *Bulk {
 	parsedOpts := parseOpts(opts...)
 
 	return &Bulk{
-		opts:    parsedOpts,
-		bulker:  bulker,
-		pending: make(map[string]pendingT),
+		opts:     parsedOpts,
+		bulker:   bulker,
+		pending:  make(map[string]pendingT),
+		recovery: make(map[string]pendingT),
 	}
 }
 
 func parseOpts(opts ...Opt) optionsT {
 
 	outOpts := optionsT{
-		flushInterval: defaultFlushInterval,
+		flushInterval:      defaultFlushInterval,
+		recoveryBufferSize: defaultRecoveryBufferSize,
 	}
 
 	for _, f := range opts {
@@ -98,9 +121,10 @@ func (bc *Bulk) timestamp() string {
 }
 
 // CheckIn will add the agent (identified by id) to the pending set.
-// The pending agents are sent to elasticsearch as a bulk update at each flush interval.
+// The pending agents are sent to elasticsearch as a bulk update at each flush interval,
+// the span of the update is linked to the OpenTelemetry span of ctx.
 // WARNING: Bulk will take ownership of fields, so do not use after passing in.
-func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string) error {
+func (bc *Bulk) CheckIn(ctx context.Context, id string, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string) error {
 	// Separate out the extra data to minimize
 	// the memory footprint of the 90% case of just
 	// updating the timestamp.
@@ -114,6 +138,11 @@ func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, c
 		}
 	}
 
+	var span *trace.SpanContext
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		span = &sc
+	}
+
 	bc.mut.Lock()
 
 	bc.pending[id] = pendingT{
@@ -121,6 +150,7 @@ func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, c
 		status:  status,
 		message: message,
 		extra:   extra,
+		span:    span,
 	}
 
 	bc.mut.Unlock()
@@ -158,6 +188,17 @@ func (bc *Bulk) flush(ctx context.Context) error {
 	bc.mut.Lock()
 	pending := bc.pending
 	bc.pending = make(map[string]pendingT, len(pending))
+
+	// Replay writes that failed on a previous flush, unless the agent
+	// has checked in again since; the newer state wins.
+	for id, pendingData := range bc.recovery {
+		if _, ok := pending[id]; !ok {
+			pending[id] = pendingData
+		}
+	}
+	if len(bc.recovery) > 0 {
+		bc.recovery = make(map[string]pendingT)
+	}
 	bc.mut.Unlock()
 
 	if len(pending) == 0 {
@@ -168,11 +209,16 @@ func (bc *Bulk) flush(ctx context.Context) error {
 
 	simpleCache := make(map[pendingT][]byte)
 
+	var links []trace.Link
+
 	nowTimestamp := start.UTC().Format(time.RFC3339)
 
 	var err error
 	var needRefresh bool
 	for id, pendingData := range pending {
+		if pendingData.span != nil {
+			links = append(links, trace.Link{SpanContext: *pendingData.span})
+		}
 
 		// In the simple case, there are no fields and no seqNo.
 		// When that is true, we can reuse an already generated
@@ -180,8 +226,12 @@ func (bc *Bulk) flush(ctx context.Context) error {
 		var body []byte
 		if pendingData.extra == nil {
 
+			// The body does not depend on the span.
+			key := pendingData
+			key.span = nil
+
 			var ok bool
-			body, ok = simpleCache[pendingData]
+			body, ok = simpleCache[key]
 			if !ok {
 				fields := bulk.UpdateFields{
 					dl.FieldLastCheckin:        pendingData.ts,
@@ -192,7 +242,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 				if body, err = fields.Marshal(); err != nil {
 					return err
 				}
-				simpleCache[pendingData] = body
+				simpleCache[key] = body
 			}
 		} else {
 
@@ -249,7 +299,12 @@ func (bc *Bulk) flush(ctx context.Context) error {
 		opts = append(opts, bulk.WithRefresh())
 	}
 
-	_, err = bc.bulker.MUpdate(ctx, updates, opts...)
+	otelCtx, span := telemetry.StartLinked(ctx, "checkin bulk write", links, attribute.Int("fleet.checkin.count", len(updates)))
+	items, err := bc.bulker.MUpdate(otelCtx, updates, opts...)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
 
 	zerolog.Ctx(ctx).Trace().
 		Err(err).
@@ -258,5 +313,80 @@ func (bc *Bulk) flush(ctx context.Context) error {
 		Bool("refresh", needRefresh).
 		Msg("Flush updates")
 
+	if err != nil {
+		bc.retain(ctx, failedUpdates(pending, updates, items))
+	}
+
 	return err
 }
+
+// failedUpdates returns the pending writes worth replaying after a failed flush.
+// If the bulk request failed as a whole every write is returned, otherwise only the writes whose item failed with a
+// retryable status; an agent that no longer exists or a rejected document fails the same way on every replay.
+func failedUpdates(pending map[string]pendingT, updates []bulk.MultiOp, items []bulk.BulkIndexerResponseItem) map[string]pendingT {
+	if len(items) != len(updates) {
+		return pending
+	}
+
+	failed := make(map[string]pendingT)
+	for i, item := range items {
+		if !retryableStatus(item.Status) {
+			continue
+		}
+		id := updates[i].ID
+		failed[id] = pending[id]
+	}
+	return failed
+}
+
+// retryableStatus returns true if a write that failed with status may succeed later.
+// A status of 0 is an item that was not written because its request failed.
+func retryableStatus(status int) bool {
+	switch {
+	case status == 0, status == http.StatusConflict, status == http.StatusTooManyRequests:
+		return true
+	default:
+		return status >= http.StatusInternalServerError
+	}
+}
+
+// retain adds the failed pending writes to the recovery buffer so they are replayed on the next flush.
+// Writes for agents that have checked in since the failed flush are discarded in favour of the newer state.
+// If the buffer is over capacity, the entries with the oldest checkin timestamps are dropped.
+func (bc *Bulk) retain(ctx context.Context, failed map[string]pendingT) {
+	if bc.opts.recoveryBufferSize <= 0 {
+		return
+	}
+
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
+	for id, pendingData := range failed {
+		if _, ok := bc.pending[id]; ok {
+			continue
+		}
+		bc.recovery[id] = pendingData
+	}
+
+	overflow := len(bc.recovery) - bc.opts.recoveryBufferSize
+	if overflow <= 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(bc.recovery))
+	for id := range bc.recovery {
+		ids = append(ids, id)
+	}
+	// RFC3339 timestamps in UTC sort lexically.
+	sort.Slice(ids, func(i, j int) bool {
+		return bc.recovery[ids[i]].ts < bc.recovery[ids[j]].ts
+	})
+	for _, id := range ids[:overflow] {
+		delete(bc.recovery, id)
+	}
+
+	zerolog.Ctx(ctx).Warn().
+		Int("dropped", overflow).
+		Int("size", bc.opts.recoveryBufferSize).
+		Msg("Checkin recovery buffer full, dropping oldest checkin writes")
+}
@@ -8,18 +8,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/rs/xid"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Test simple,
@@ -184,7 +191,7 @@ func TestBulkSimple(t *testing.T) {
 			mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchOp(t, c, start)), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
 			bc := NewBulk(mockBulk)
 
-			if err := bc.CheckIn(c.id, c.status, c.message, c.meta, c.components, c.seqno, c.ver); err != nil {
+			if err := bc.CheckIn(ctx, c.id, c.status, c.message, c.meta, c.components, c.seqno, c.ver); err != nil {
 				t.Fatal(err)
 			}
 
@@ -197,6 +204,196 @@ func TestBulkSimple(t *testing.T) {
 	}
 }
 
+func TestBulkRecovery(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	// statusByID decodes the last_checkin_status of each operation.
+	statusByID := func(ops []bulk.MultiOp) map[string]string {
+		res := make(map[string]string, len(ops))
+		for _, op := range ops {
+			var m map[string]struct {
+				Status string `json:"last_checkin_status"`
+			}
+			if err := json.Unmarshal(op.Body, &m); err != nil {
+				t.Fatalf("unable to decode operation: %v", err)
+			}
+			res[op.ID] = m["doc"].Status
+		}
+		return res
+	}
+
+	t.Run("failed writes are replayed latest-only after recovery", func(t *testing.T) {
+		errOutage := errors.New("es unavailable")
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, errOutage).Twice()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return cmp.Equal(map[string]string{
+				"agent1": "degraded",
+				"agent2": "online",
+				"agent3": "online",
+			}, statusByID(ops))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		_ = bc.CheckIn(ctx, "agent1", "online", "", nil, nil, nil, "")
+		_ = bc.CheckIn(ctx, "agent2", "online", "", nil, nil, nil, "")
+		if err := bc.flush(ctx); !errors.Is(err, errOutage) {
+			t.Fatalf("expected outage error, got %v", err)
+		}
+
+		_ = bc.CheckIn(ctx, "agent1", "error", "", nil, nil, nil, "")
+		_ = bc.CheckIn(ctx, "agent3", "online", "", nil, nil, nil, "")
+		if err := bc.flush(ctx); !errors.Is(err, errOutage) {
+			t.Fatalf("expected outage error, got %v", err)
+		}
+
+		// agent1 checks in again while the previous write is buffered; newer state wins.
+		_ = bc.CheckIn(ctx, "agent1", "degraded", "", nil, nil, nil, "")
+		if err := bc.flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(bc.recovery) != 0 {
+			t.Errorf("expected recovery buffer to be empty, has %d entries", len(bc.recovery))
+		}
+
+		// Nothing left to replay.
+		if err := bc.flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("only retryable failed writes are replayed", func(t *testing.T) {
+		statuses := map[string]int{
+			"agent1": http.StatusOK,
+			"agent2": http.StatusServiceUnavailable,
+			"agent3": http.StatusNotFound,
+			"agent4": http.StatusTooManyRequests,
+			"agent5": http.StatusBadRequest,
+		}
+		items := make([]bulk.BulkIndexerResponseItem, len(statuses))
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			for i, op := range args.Get(1).([]bulk.MultiOp) {
+				items[i] = bulk.BulkIndexerResponseItem{DocumentID: op.ID, Status: statuses[op.ID]}
+				if items[i].Status != http.StatusOK {
+					items[i].Error = json.RawMessage(`{"type":"failure"}`)
+				}
+			}
+		}).Return(items, errors.New("partial failure")).Once()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return cmp.Equal(map[string]string{
+				"agent2": "online",
+				"agent4": "online",
+			}, statusByID(ops))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		for id := range statuses {
+			_ = bc.CheckIn(ctx, id, "online", "", nil, nil, nil, "")
+		}
+		if err := bc.flush(ctx); err == nil {
+			t.Fatal("expected error")
+		}
+		if len(bc.recovery) != 2 {
+			t.Errorf("expected 2 buffered writes, got %d", len(bc.recovery))
+		}
+		if err := bc.flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("recovery buffer is bounded", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, errors.New("es unavailable")).Once()
+		bc := NewBulk(mockBulk, WithRecoveryBufferSize(2))
+
+		for _, id := range []string{"agent1", "agent2", "agent3"} {
+			_ = bc.CheckIn(ctx, id, "online", "", nil, nil, nil, "")
+		}
+		if err := bc.flush(ctx); err == nil {
+			t.Fatal("expected error")
+		}
+		if len(bc.recovery) != 2 {
+			t.Errorf("expected 2 buffered writes, got %d", len(bc.recovery))
+		}
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("recovery buffer disabled", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, errors.New("es unavailable")).Once()
+		bc := NewBulk(mockBulk, WithRecoveryBufferSize(0))
+
+		_ = bc.CheckIn(ctx, "agent1", "online", "", nil, nil, nil, "")
+		if err := bc.flush(ctx); err == nil {
+			t.Fatal("expected error")
+		}
+		if len(bc.recovery) != 0 {
+			t.Errorf("expected no buffered writes, got %d", len(bc.recovery))
+		}
+		mockBulk.AssertExpectations(t)
+	})
+}
+
+func TestBulkOTelLinks(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+	bc := NewBulk(mockBulk)
+
+	var checkins []trace.SpanContext
+	for _, id := range []string{"agent1", "agent2"} {
+		spanCtx, span := telemetry.Start(ctx, "checkin", telemetry.AttrAgentID.String(id))
+		_ = bc.CheckIn(spanCtx, id, "online", "", nil, nil, nil, "")
+		span.End()
+		checkins = append(checkins, span.SpanContext())
+	}
+	// An agent checking in without a span is written without a link.
+	_ = bc.CheckIn(ctx, "agent3", "online", "", nil, nil, nil, "")
+
+	if err := bc.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var write *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		if spans[i].Name == "checkin bulk write" {
+			write = &spans[i]
+		}
+	}
+	if write == nil {
+		t.Fatal("expected a checkin bulk write span")
+	}
+	var linked []trace.SpanContext
+	for _, l := range write.Links {
+		linked = append(linked, l.SpanContext)
+	}
+	if len(linked) != len(checkins) {
+		t.Fatalf("expected %d links, got %d", len(checkins), len(linked))
+	}
+	for _, sc := range checkins {
+		found := false
+		for _, l := range linked {
+			if l.SpanID() == sc.SpanID() && l.TraceID() == sc.TraceID() {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("checkin span %s is not linked", sc.SpanID())
+		}
+	}
+	mockBulk.AssertExpectations(t)
+}
+
 func validateTimestamp(tb testing.TB, start time.Time, ts string) {
 	if t1, err := time.Parse(time.RFC3339, ts); err != nil {
 		tb.Error("expected rfc3999")
@@ -223,7 +420,7 @@ func benchmarkBulk(n int, flush bool, b *testing.B) {
 	for i := 0; i < b.N; i++ {
 
 		for _, id := range ids {
-			err := bc.CheckIn(id, "", "", nil, nil, nil, "")
+			err := bc.CheckIn(ctx, id, "", "", nil, nil, nil, "")
 			if err != nil {
 				b.Fatal(err)
 			}
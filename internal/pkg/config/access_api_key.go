@@ -0,0 +1,70 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+const defaultAccessAPIKeyRotateBefore = 24 * time.Hour
+
+// AccessAPIKey is the configuration of the API keys agents use to contact fleet-server.
+type AccessAPIKey struct {
+	// Expiration is the lifetime of the access API keys created on enrollment and rotation, 0 never expires.
+	Expiration time.Duration `config:"expiration"`
+	// RotateBefore is how long before it expires an access API key is replaced on the agent checkin.
+	// The agent keeps using its current key until it checks in with the new one.
+	RotateBefore time.Duration `config:"rotate_before"`
+	// MinAgentVersion is the lowest agent version reading the access API key delivered in the checkin response.
+	// Only the keys of these agents expire and are rotated, the keys of older agents never expire.
+	MinAgentVersion string `config:"min_agent_version"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *AccessAPIKey) InitDefaults() {
+	c.RotateBefore = defaultAccessAPIKeyRotateBefore
+}
+
+// Validate ensures that the configuration is valid.
+func (c *AccessAPIKey) Validate() error {
+	if c.Expiration < 0 {
+		return fmt.Errorf("expiration must not be negative, got %s", c.Expiration)
+	}
+	if c.RotateBefore <= 0 {
+		return fmt.Errorf("rotate_before must be positive, got %s", c.RotateBefore)
+	}
+	if c.Expiration > 0 && c.RotateBefore >= c.Expiration {
+		return fmt.Errorf("rotate_before must be lower than expiration, got %s and %s", c.RotateBefore, c.Expiration)
+	}
+	if c.Expiration > 0 {
+		if c.MinAgentVersion == "" {
+			return errors.New("min_agent_version is required when expiration is set")
+		}
+		if _, err := version.NewVersion(c.MinAgentVersion); err != nil {
+			return fmt.Errorf("invalid min_agent_version %q: %w", c.MinAgentVersion, err)
+		}
+	}
+	return nil
+}
+
+// Rotates returns true if the access API keys of the agents at version ver expire and are rotated.
+func (c *AccessAPIKey) Rotates(ver string) bool {
+	if c.Expiration <= 0 || c.MinAgentVersion == "" {
+		return false
+	}
+	minVer, err := version.NewVersion(c.MinAgentVersion)
+	if err != nil {
+		return false
+	}
+	agentVer, err := version.NewVersion(ver)
+	if err != nil {
+		return false
+	}
+	return !agentVer.LessThan(minVer)
+}
@@ -0,0 +1,69 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultAutoscalingMinReplicas    = 1
+	defaultAutoscalingSampleInterval = 30 * time.Second
+)
+
+// Autoscaling is the configuration of the scaling hint fleet-server exposes to autoscalers.
+//
+// Each threshold is the load a single replica is expected to handle, 0 ignores the indicator.
+type Autoscaling struct {
+	// Enabled serves the hint on the local monitoring HTTP server.
+	Enabled bool `config:"enabled"`
+	// MinReplicas is the lowest recommended number of replicas.
+	MinReplicas int `config:"min_replicas"`
+	// MaxReplicas caps the recommended number of replicas, 0 leaves it uncapped.
+	MaxReplicas int `config:"max_replicas"`
+	// AgentsPerReplica is the number of online agents a replica serves.
+	AgentsPerReplica int `config:"agents_per_replica"`
+	// CheckinRatePerReplica is the number of checkins per second a replica handles.
+	CheckinRatePerReplica float64 `config:"checkin_rate_per_replica"`
+	// InflightCheckinsPerReplica is the number of concurrent checkins a replica handles.
+	InflightCheckinsPerReplica int `config:"inflight_checkins_per_replica"`
+	// BulkQueueDepthPerReplica is the number of operations a replica may queue for Elasticsearch.
+	BulkQueueDepthPerReplica int `config:"bulk_queue_depth_per_replica"`
+	// SampleInterval is the period the checkin rate is averaged over.
+	SampleInterval time.Duration `config:"sample_interval"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Autoscaling) InitDefaults() {
+	c.MinReplicas = defaultAutoscalingMinReplicas
+	c.SampleInterval = defaultAutoscalingSampleInterval
+}
+
+// Validate ensures that the configuration is valid.
+func (c *Autoscaling) Validate() error {
+	if c.MinReplicas < 1 {
+		return fmt.Errorf("autoscaling min_replicas must be at least 1, got %d", c.MinReplicas)
+	}
+	if c.MaxReplicas != 0 && c.MaxReplicas < c.MinReplicas {
+		return fmt.Errorf("autoscaling max_replicas must not be lower than min_replicas %d, got %d", c.MinReplicas, c.MaxReplicas)
+	}
+	if c.AgentsPerReplica < 0 {
+		return fmt.Errorf("autoscaling agents_per_replica must not be negative, got %d", c.AgentsPerReplica)
+	}
+	if c.CheckinRatePerReplica < 0 {
+		return fmt.Errorf("autoscaling checkin_rate_per_replica must not be negative, got %g", c.CheckinRatePerReplica)
+	}
+	if c.InflightCheckinsPerReplica < 0 {
+		return fmt.Errorf("autoscaling inflight_checkins_per_replica must not be negative, got %d", c.InflightCheckinsPerReplica)
+	}
+	if c.BulkQueueDepthPerReplica < 0 {
+		return fmt.Errorf("autoscaling bulk_queue_depth_per_replica must not be negative, got %d", c.BulkQueueDepthPerReplica)
+	}
+	if c.SampleInterval <= 0 {
+		return fmt.Errorf("autoscaling sample_interval must be positive, got %s", c.SampleInterval)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// ClockSkewActionLog logs the checkins skewed beyond MaxClockSkew and handles them normally.
+	ClockSkewActionLog = "log"
+	// ClockSkewActionReject rejects the checkins skewed beyond MaxClockSkew with a 400.
+	ClockSkewActionReject = "reject"
+)
+
+const (
+	defaultUnknownAgentStatus = http.StatusNotFound
+	defaultMaxActions         = 100
+
+	// maxActionsLimit is the default index.max_result_window of Elasticsearch.
+	maxActionsLimit = 10000
+)
+
+// Checkin is the configuration for the agent checkin endpoint.
+type Checkin struct {
+	// UnknownAgentStatus is the status code returned to an agent whose record no longer exists, for example after it was deleted.
+	// The response body always carries the AgentNotFound error so the agent can re-enroll.
+	UnknownAgentStatus int `config:"unknown_agent_status"`
+	// MaxActions is the maximum number of pending actions fetched and delivered on a single checkin.
+	// Remaining actions are delivered on the following checkins, oldest first.
+	MaxActions int `config:"max_actions"`
+	// DispatchBatchSize is the maximum number of new actions the action dispatcher delivers to an agent in a turn,
+	// 0 uses MaxActions. The agent fetches the remaining actions on its next checkin.
+	DispatchBatchSize int `config:"dispatch_batch_size"`
+	// PolicyConcurrency is the maximum number of policies assembled for delivery at once, 0 is unlimited.
+	// Checkins waiting for a policy beyond this limit are queued.
+	PolicyConcurrency int `config:"policy_concurrency"`
+	// MaxClockSkew is the maximum difference between the timestamp reported by an agent and the server time, 0 disables the check.
+	MaxClockSkew time.Duration `config:"max_clock_skew"`
+	// ClockSkewAction is what happens to the checkins skewed beyond MaxClockSkew: they are either logged or rejected.
+	ClockSkewAction string `config:"clock_skew_action"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Checkin) InitDefaults() {
+	c.UnknownAgentStatus = defaultUnknownAgentStatus
+	c.MaxActions = defaultMaxActions
+	c.ClockSkewAction = ClockSkewActionLog
+}
+
+// Validate ensures that the configuration is valid.
+func (c *Checkin) Validate() error {
+	if c.UnknownAgentStatus < 400 || c.UnknownAgentStatus > 499 {
+		return fmt.Errorf("unknown_agent_status must be a 4xx status code, got %d", c.UnknownAgentStatus)
+	}
+	if c.MaxActions < 1 || c.MaxActions > maxActionsLimit {
+		return fmt.Errorf("max_actions must be between 1 and %d, got %d", maxActionsLimit, c.MaxActions)
+	}
+	if c.DispatchBatchSize < 0 || c.DispatchBatchSize > c.MaxActions {
+		return fmt.Errorf("dispatch_batch_size must be between 0 and max_actions (%d), got %d", c.MaxActions, c.DispatchBatchSize)
+	}
+	if c.PolicyConcurrency < 0 {
+		return fmt.Errorf("policy_concurrency must not be negative, got %d", c.PolicyConcurrency)
+	}
+	if c.MaxClockSkew < 0 {
+		return fmt.Errorf("max_clock_skew must not be negative, got %s", c.MaxClockSkew)
+	}
+	switch c.ClockSkewAction {
+	case ClockSkewActionLog, ClockSkewActionReject:
+	default:
+		return fmt.Errorf("clock_skew_action must be %q or %q, got %q", ClockSkewActionLog, ClockSkewActionReject, c.ClockSkewAction)
+	}
+	return nil
+}
+
+// DispatchBatch returns the maximum number of actions the action dispatcher delivers to an agent in a turn.
+func (c *Checkin) DispatchBatch() int {
+	if c.DispatchBatchSize > 0 {
+		return c.DispatchBatchSize
+	}
+	return c.MaxActions
+}
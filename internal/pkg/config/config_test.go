@@ -133,6 +133,13 @@ func TestConfig(t *testing.T) {
 								UpstreamURL: defaultPGPUpstreamURL,
 								Dir:         filepath.Join(retrieveExecutableDir(), defaultPGPDirectoryName),
 							},
+							Checkin:      defaultServerCheckin(),
+							KeepAlive:    defaultServerKeepAlive(),
+							Leadership:   defaultServerLeadership(),
+							Metrics:      defaultServerMetrics(),
+							AccessAPIKey: defaultServerAccessAPIKey(),
+							Enroll:       defaultServerEnroll(),
+							Autoscaling:  defaultServerAutoscaling(),
 						},
 						Cache: generateCache(0),
 						Monitor: Monitor{
@@ -244,6 +251,7 @@ func TestLoadServerLimits(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotZero(t, c.Inputs[0].Server.Limits.CheckinLimit.MaxBody)
 		assert.NotZero(t, c.Inputs[0].Cache.ActionTTL)
+		assert.Equal(t, defaultRetryAfter, c.Inputs[0].Server.Limits.RetryAfter)
 	})
 	t.Run("agent count limits load", func(t *testing.T) {
 		c := &Config{Inputs: []Input{{
@@ -334,6 +342,48 @@ func defaultServerGC() GC {
 	return d
 }
 
+func defaultServerCheckin() Checkin {
+	var d Checkin
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerKeepAlive() ServerKeepAlive {
+	var d ServerKeepAlive
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerLeadership() Leadership {
+	var d Leadership
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerMetrics() ServerMetrics {
+	var d ServerMetrics
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerEnroll() Enroll {
+	var d Enroll
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerAutoscaling() Autoscaling {
+	var d Autoscaling
+	d.InitDefaults()
+	return d
+}
+
+func defaultServerAccessAPIKey() AccessAPIKey {
+	var d AccessAPIKey
+	d.InitDefaults()
+	return d
+}
+
 func defaultLogging() Logging {
 	var d Logging
 	d.InitDefaults()
@@ -0,0 +1,33 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Enroll is the configuration of the agent enrollment.
+type Enroll struct {
+	// DedupWindow is how long a server returns the result of an enrollment to the retries of the same request
+	// carrying an enrollment_id, instead of enrolling the agent again, 0 disables the deduplication.
+	DedupWindow time.Duration `config:"dedup_window"`
+	// WaitForActiveShards is the number of active shard copies the creation of the agent waits for, 0 is the index default.
+	WaitForActiveShards int `config:"wait_for_active_shards"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Enroll) InitDefaults() {}
+
+// Validate ensures that the configuration is valid.
+func (c *Enroll) Validate() error {
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("enroll dedup_window must not be negative, got %s", c.DedupWindow)
+	}
+	if c.WaitForActiveShards < 0 {
+		return fmt.Errorf("enroll wait_for_active_shards must not be negative, got %d", c.WaitForActiveShards)
+	}
+	return nil
+}
@@ -12,10 +12,16 @@ const (
 )
 
 // GC is the configuration for the Fleet Server data garbage collection.
-// Currently manages the expired actions cleanup
+// Manages the expired actions cleanup, the inactive agents unenroll and the stuck enrollments cleanup.
 type GC struct {
 	ScheduleInterval            time.Duration `config:"schedule_interval"`
 	CleanupAfterExpiredInterval string        `config:"cleanup_after_expired_interval"`
+	// InactivityUnenrollTimeout is how long an agent may go without checking in before it is auto unenrolled.
+	// Disabled when zero; values under 24h are raised to 24h.
+	InactivityUnenrollTimeout time.Duration `config:"inactivity_unenroll_timeout"`
+	// EnrollmentTimeout is how long an enrolled agent may go without its first checkin before it is removed.
+	// Disabled when zero; values under 1h are raised to 1h.
+	EnrollmentTimeout time.Duration `config:"enrollment_timeout"`
 }
 
 func (g *GC) InitDefaults() {
@@ -38,6 +38,47 @@ func (c *ServerProfiler) InitDefaults() {
 	c.Bind = "localhost:6060"
 }
 
+// ServerDebug is the configuration for debugging aids that are too verbose to be enabled by default.
+type ServerDebug struct {
+	// LogQueries logs the rendered body of data layer searches at debug level, with sensitive values redacted.
+	LogQueries bool `config:"log_queries"`
+}
+
+// ServerAdmin is the configuration for the operator endpoints served by the local monitoring HTTP server.
+type ServerAdmin struct {
+	// Enabled mounts the /admin endpoints, they are off by default as they expose the content of the Fleet indices.
+	Enabled bool `config:"enabled"`
+}
+
+// ServerKeepAlive is the configuration for keeping idle agent connections open.
+type ServerKeepAlive struct {
+	// Enabled allows HTTP keep-alives; when false every connection is closed after its response.
+	Enabled bool `config:"enabled"`
+	// TCPPeriod is the interval of TCP keep-alive probes used to detect dead peers.
+	// A negative value disables the probes.
+	TCPPeriod time.Duration `config:"tcp_period"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ServerKeepAlive) InitDefaults() {
+	c.Enabled = true
+	c.TCPPeriod = 15 * time.Second
+}
+
+// ServerMetrics is the configuration for the fleet metrics fleet-server periodically collects.
+type ServerMetrics struct {
+	// AgentStatusInterval is how often the agent counts by status are refreshed, 0 (the default) disables them.
+	// Every fleet-server instance runs the aggregation, it is best enabled on a single one.
+	AgentStatusInterval time.Duration `config:"agent_status_interval"`
+	// LeaderIndexInterval is how often the document count and size of the policy leaders index are refreshed, 0 disables them.
+	LeaderIndexInterval time.Duration `config:"leader_index_interval"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ServerMetrics) InitDefaults() {
+	c.LeaderIndexInterval = time.Minute
+}
+
 // ServerTLS is the TLS configuration for running the TLS endpoint.
 type ServerTLS struct {
 	Key  string `config:"key"`
@@ -49,6 +90,8 @@ type ServerBulk struct {
 	FlushThresholdCount int           `config:"flush_threshold_cnt"`
 	FlushThresholdSize  int           `config:"flush_threshold_size"`
 	FlushMaxPending     int           `config:"flush_max_pending"`
+	SearchMaxParallel   int           `config:"search_max_parallel"`
+	FlushByIndex        bool          `config:"flush_by_index"`
 }
 
 func (c *ServerBulk) InitDefaults() {
@@ -67,6 +110,8 @@ type (
 		TLS                *tlscommon.ServerConfig `config:"ssl"`
 		Timeouts           ServerTimeouts          `config:"timeouts"`
 		Profiler           ServerProfiler          `config:"profiler"`
+		Debug              ServerDebug             `config:"debug"`
+		Admin              ServerAdmin             `config:"admin"`
 		CompressionLevel   int                     `config:"compression_level"`
 		CompressionThresh  int                     `config:"compression_threshold"`
 		Limits             ServerLimits            `config:"limits"`
@@ -76,6 +121,14 @@ type (
 		Instrumentation    Instrumentation         `config:"instrumentation"`
 		StaticPolicyTokens StaticPolicyTokens      `config:"static_policy_tokens"`
 		PGP                PGP                     `config:"pgp"`
+		Checkin            Checkin                 `config:"checkin"`
+		KeepAlive          ServerKeepAlive         `config:"keep_alive"`
+		Leadership         Leadership              `config:"leadership"`
+		Metrics            ServerMetrics           `config:"metrics"`
+		AccessAPIKey       AccessAPIKey            `config:"access_api_key"`
+		PolicyAgentLimits  []PolicyAgentLimit      `config:"policy_agent_limits"`
+		Enroll             Enroll                  `config:"enroll"`
+		Autoscaling        Autoscaling             `config:"autoscaling"`
 	}
 
 	StaticPolicyTokens struct {
@@ -106,6 +159,13 @@ func (c *Server) InitDefaults() {
 	c.Bulk.InitDefaults()
 	c.GC.InitDefaults()
 	c.PGP.InitDefaults()
+	c.Checkin.InitDefaults()
+	c.KeepAlive.InitDefaults()
+	c.Leadership.InitDefaults()
+	c.Metrics.InitDefaults()
+	c.AccessAPIKey.InitDefaults()
+	c.Enroll.InitDefaults()
+	c.Autoscaling.InitDefaults()
 }
 
 // BindEndpoints returns the binding address for the all HTTP server listeners.
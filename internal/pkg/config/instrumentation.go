@@ -38,6 +38,15 @@ type Instrumentation struct {
 	GlobalLabels string `config:"global_labels"`
 	// TransactionSampleRate sets the sample rate  - may be specified with ELASTIC_APM_TRANSACTION_SAMPLE_RATE
 	TransactionSampleRate string `config:"transaction_sample_rate"`
+	// OTel configures OpenTelemetry trace export, independently of APM.
+	OTel InstrumentationOTel `config:"otel"`
+}
+
+// InstrumentationOTel configures OpenTelemetry trace export.
+// The exporter is configured with the standard OTEL_EXPORTER_OTLP_* environment variables.
+type InstrumentationOTel struct {
+	// Enabled turns on OTLP trace export - may be specified with OTEL_TRACES_EXPORTER=otlp
+	Enabled bool `config:"enabled"`
 }
 
 type InstrumentationTLS struct {
@@ -0,0 +1,111 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// LeadershipRolePrimary servers take over expired policy leases as soon as they expire.
+	LeadershipRolePrimary = "primary"
+	// LeadershipRoleStandby servers wait an additional StandbyGrace before taking over expired policy leases.
+	LeadershipRoleStandby = "standby"
+
+	defaultStandbyGrace = time.Minute
+)
+
+// Leadership is the configuration for the policy leader election of this server.
+type Leadership struct {
+	// Role is either primary or standby.
+	Role string `config:"role"`
+	// StandbyGrace is how much longer than a primary a standby waits before taking over an expired lease,
+	// leaving a healthy primary the time to reclaim it first.
+	StandbyGrace time.Duration `config:"standby_grace"`
+	// StartupDelay is the upper bound of the random delay a starting server waits before acquiring leadership,
+	// spreading the initial acquisition of servers restarted together. Zero disables the delay.
+	StartupDelay time.Duration `config:"startup_delay"`
+	// ReleaseDelay is how long after shutdown the released policy leases become claimable by other servers,
+	// giving a successor time to be ready during rolling restarts. Zero releases the leases immediately.
+	ReleaseDelay time.Duration `config:"release_delay"`
+	// WaitForActiveShards is the number of active shard copies the writes of the policy leases wait for, 0 is the index default.
+	WaitForActiveShards int `config:"wait_for_active_shards"`
+	// PinnedPolicies are the policies led by a designated server.
+	// Other servers only take over the lease of a pinned policy while its server is not running.
+	PinnedPolicies []PolicyPin `config:"pinned_policies"`
+}
+
+// PolicyPin pins the leadership of a policy to a server.
+type PolicyPin struct {
+	PolicyID string `config:"policy_id"`
+	// ServerID is the agent ID of the Fleet Server leading the policy.
+	ServerID string `config:"server_id"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Leadership) InitDefaults() {
+	c.Role = LeadershipRolePrimary
+	c.StandbyGrace = defaultStandbyGrace
+}
+
+// Validate ensures that the configuration is valid.
+func (c *Leadership) Validate() error {
+	switch c.Role {
+	case LeadershipRolePrimary, LeadershipRoleStandby:
+	default:
+		return fmt.Errorf("leadership role must be %q or %q, got %q", LeadershipRolePrimary, LeadershipRoleStandby, c.Role)
+	}
+	if c.StandbyGrace < 0 {
+		return fmt.Errorf("leadership standby_grace must not be negative, got %s", c.StandbyGrace)
+	}
+	if c.StartupDelay < 0 {
+		return fmt.Errorf("leadership startup_delay must not be negative, got %s", c.StartupDelay)
+	}
+	if c.ReleaseDelay < 0 {
+		return fmt.Errorf("leadership release_delay must not be negative, got %s", c.ReleaseDelay)
+	}
+	if c.WaitForActiveShards < 0 {
+		return fmt.Errorf("leadership wait_for_active_shards must not be negative, got %d", c.WaitForActiveShards)
+	}
+	pinned := make(map[string]bool, len(c.PinnedPolicies))
+	for _, p := range c.PinnedPolicies {
+		if p.PolicyID == "" || p.ServerID == "" {
+			return errors.New("leadership pinned_policies entries require a policy_id and a server_id")
+		}
+		if pinned[p.PolicyID] {
+			return fmt.Errorf("leadership pinned_policies has policy %q more than once", p.PolicyID)
+		}
+		pinned[p.PolicyID] = true
+	}
+	return nil
+}
+
+// Pins returns the servers the pinned policies are led by, keyed by policy ID.
+func (c *Leadership) Pins() map[string]string {
+	pins := make(map[string]string, len(c.PinnedPolicies))
+	for _, p := range c.PinnedPolicies {
+		pins[p.PolicyID] = p.ServerID
+	}
+	return pins
+}
+
+// JitteredStartupDelay returns a random delay in [0, StartupDelay) to wait before acquiring leadership.
+func (c *Leadership) JitteredStartupDelay() time.Duration {
+	if c.StartupDelay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(c.StartupDelay))) //nolint:gosec // jitter time does not need to by generated from a crypto secure source
+}
+
+// LeaseGrace returns the extra time to wait after a policy lease expired before taking it over.
+func (c *Leadership) LeaseGrace() time.Duration {
+	if c.Role == LeadershipRoleStandby {
+		return c.StandbyGrace
+	}
+	return 0
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 )
 
+const defaultRetryAfter = 5 * time.Second
+
 type Limit struct {
 	Interval time.Duration `config:"interval"`
 	Burst    int           `config:"burst"`
@@ -20,6 +22,7 @@ type ServerLimits struct {
 	PolicyThrottle    time.Duration `config:"policy_throttle"`
 	MaxHeaderByteSize int           `config:"max_header_byte_size"`
 	MaxConnections    int           `config:"max_connections"`
+	RetryAfter        time.Duration `config:"retry_after"` // Retry-After of 429 and 503 responses
 
 	ActionLimit      Limit `config:"action_limit"`
 	CheckinLimit     Limit `config:"checkin_limit"`
@@ -43,6 +46,9 @@ func (c *ServerLimits) LoadLimits(limits *envLimits) {
 	if c.MaxHeaderByteSize == 0 {
 		c.MaxHeaderByteSize = 8192 // 8k
 	}
+	if c.RetryAfter == 0 {
+		c.RetryAfter = defaultRetryAfter
+	}
 	if c.MaxConnections == 0 {
 		c.MaxConnections = l.MaxConnections
 	}
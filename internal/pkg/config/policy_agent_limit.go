@@ -0,0 +1,34 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "fmt"
+
+// PolicyAgentLimit caps the number of active agents enrolled in a policy.
+type PolicyAgentLimit struct {
+	PolicyID  string `config:"policy_id"`
+	MaxAgents int64  `config:"max_agents"`
+}
+
+// Validate ensures that the configuration is valid.
+func (c *PolicyAgentLimit) Validate() error {
+	if c.PolicyID == "" {
+		return fmt.Errorf("policy agent limit requires a policy_id")
+	}
+	if c.MaxAgents <= 0 {
+		return fmt.Errorf("policy agent limit max_agents must be positive, got %d for policy %q", c.MaxAgents, c.PolicyID)
+	}
+	return nil
+}
+
+// AgentLimit returns the maximum number of active agents enrolled in the policy, 0 if it is not capped.
+func (c *Server) AgentLimit(policyID string) int64 {
+	for _, l := range c.PolicyAgentLimits {
+		if l.PolicyID == policyID {
+			return l.MaxAgents
+		}
+	}
+	return 0
+}
@@ -31,6 +31,7 @@ const (
 	defaultLeaderInterval          = 30 * time.Second // become leader for at least 30 seconds
 	defaultMetadataInterval        = 5 * time.Minute  // update metadata every 5 minutes
 	defaultCoordinatorRestartDelay = 5 * time.Second  // delay in restarting coordinator on failure
+	defaultReconcileInterval       = 5 * time.Minute  // reconcile running coordinators with leader docs every 5 minutes
 )
 
 // Monitor monitors the leader election of policies and routes managed policies to the coordinator.
@@ -59,6 +60,14 @@ type monitorT struct {
 	leaderInterval    time.Duration
 	metadataInterval  time.Duration
 	coordRestartDelay time.Duration
+	reconcileInterval time.Duration
+	leaseGrace        time.Duration
+	startupDelay      time.Duration
+	releaseDelay      time.Duration
+	activeShards      int // wait_for_active_shards of the lease writes, 0 is the index default
+
+	// pins are the servers pinned policies are led by, keyed by policy ID.
+	pins map[string]string
 
 	serversIndex  string
 	policiesIndex string
@@ -72,7 +81,10 @@ type monitorT struct {
 }
 
 // NewMonitor creates a new coordinator policy monitor.
-func NewMonitor(fleet config.Fleet, version string, bulker bulk.Bulk, monitor monitor.Monitor, factory Factory) Monitor {
+//
+// A standby server, as set by leadership, waits an additional grace before taking over expired leases.
+// Policies pinned to another server by leadership are only led while that server is not running.
+func NewMonitor(fleet config.Fleet, leadership config.Leadership, version string, bulker bulk.Bulk, monitor monitor.Monitor, factory Factory) Monitor {
 	return &monitorT{
 		version:           version,
 		fleet:             fleet,
@@ -83,6 +95,12 @@ func NewMonitor(fleet config.Fleet, version string, bulker bulk.Bulk, monitor mo
 		leaderInterval:    defaultLeaderInterval,
 		metadataInterval:  defaultMetadataInterval,
 		coordRestartDelay: defaultCoordinatorRestartDelay,
+		reconcileInterval: defaultReconcileInterval,
+		leaseGrace:        leadership.LeaseGrace(),
+		startupDelay:      leadership.JitteredStartupDelay(),
+		releaseDelay:      leadership.ReleaseDelay,
+		activeShards:      leadership.WaitForActiveShards,
+		pins:              leadership.Pins(),
 		serversIndex:      dl.FleetServers,
 		policiesIndex:     dl.FleetPolicies,
 		leadersIndex:      dl.FleetPoliciesLeader,
@@ -105,6 +123,18 @@ func (m *monitorT) Run(ctx context.Context) (err error) {
 		return ctx.Err()
 	}
 
+	// Spread the initial leadership acquisition of servers started together.
+	if m.startupDelay > 0 {
+		log.Info().Dur("delay", m.startupDelay).Msg("delaying initial leadership acquisition")
+		sT := time.NewTimer(m.startupDelay)
+		select {
+		case <-sT.C:
+		case <-ctx.Done():
+			sT.Stop()
+			return ctx.Err()
+		}
+	}
+
 	// Start timer loop to ensure leadership
 	lT := time.NewTimer(m.checkInterval)
 	defer lT.Stop()
@@ -113,6 +143,7 @@ func (m *monitorT) Run(ctx context.Context) (err error) {
 	for {
 		err = m.ensureLeadership(ctx)
 		if err != nil {
+			es.RecordError(es.SubsystemLeadership, err)
 			log.Warn().Err(err).Msg("error ensuring leadership, will retry")
 			select {
 			case <-lT.C:
@@ -134,6 +165,10 @@ func (m *monitorT) Run(ctx context.Context) (err error) {
 	mT := time.NewTimer(m.metadataInterval)
 	defer mT.Stop()
 
+	// Start timer to correct drift between running coordinators and the leader docs
+	rT := time.NewTimer(m.reconcileInterval)
+	defer rT.Stop()
+
 	// Keep track of errored statuses
 	erroredOnLastRequest := false
 	numFailedRequests := 0
@@ -144,16 +179,27 @@ func (m *monitorT) Run(ctx context.Context) (err error) {
 			if err != nil {
 				erroredOnLastRequest = true
 				numFailedRequests++
+				es.RecordError(es.SubsystemLeadership, err)
 				log.Warn().Err(err).Msgf("Encountered an error while policy leadership changes; continuing to retry.")
 			}
 		case <-mT.C:
 			m.calcMetadata(ctx)
 			mT.Reset(m.metadataInterval)
+		case <-rT.C:
+			err = m.reconcileLeadership(ctx)
+			if err != nil {
+				erroredOnLastRequest = true
+				numFailedRequests++
+				es.RecordError(es.SubsystemLeadership, err)
+				log.Warn().Err(err).Msgf("Encountered an error while reconciling policy leaders; continuing to retry.")
+			}
+			rT.Reset(m.reconcileInterval)
 		case <-lT.C:
 			err = m.ensureLeadership(ctx)
 			if err != nil {
 				erroredOnLastRequest = true
 				numFailedRequests++
+				es.RecordError(es.SubsystemLeadership, err)
 				log.Warn().Err(err).Msgf("Encountered an error while checking/assigning policy leaders; continuing to retry.")
 			}
 			lT.Reset(m.checkInterval)
@@ -249,18 +295,35 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 	// determine the policies that lead needs to be taken
 	var lead []model.Policy
 	now := time.Now().UTC()
+	alive := make(map[string]bool)
 	for _, policy := range policies {
 		leader, ok := leaders[policy.PolicyID]
+		if pinnedTo, pinned := m.pins[policy.PolicyID]; pinned {
+			var current *model.PolicyLeader
+			if ok {
+				current = &leader
+			}
+			claimable, err := m.claimablePinned(ctx, current, pinnedTo, alive, now)
+			if err != nil {
+				return err
+			}
+			if claimable {
+				lead = append(lead, policy)
+			} else if _, running := m.policies[policy.PolicyID]; running {
+				m.handOverLeadership(ctx, policy.PolicyID, pinnedTo)
+			}
+			continue
+		}
 		if !ok {
 			// new policy want to try to take leadership
 			lead = append(lead, policy)
 			continue
 		}
-		t, err := leader.Time()
+		claimable, err := m.claimable(leader, now)
 		if err != nil {
 			return err
 		}
-		if now.Sub(t) > m.leaderInterval || leader.Server.ID == m.agentMetadata.ID {
+		if claimable {
 			// policy needs a new leader or already leader
 			lead = append(lead, policy)
 		}
@@ -277,7 +340,7 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 			}()
 
 			l := zerolog.Ctx(ctx).With().Str("ctx", "policy leader manager").Str(dl.FieldPolicyID, pt.id).Logger()
-			err := dl.TakePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.version, dl.WithIndexName(m.leadersIndex))
+			err := dl.TakePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.version, dl.WithIndexName(m.leadersIndex), dl.WithWaitForActiveShards(m.activeShards))
 			if err != nil {
 				l.Warn().Err(err).Msg("monitor.ensureLeadership: failed to take ownership")
 				if pt.cord != nil {
@@ -290,21 +353,15 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 				return
 			}
 			if pt.cord == nil {
-				cord, err := m.factory(p)
+				pt, err = m.startCoordinator(ctx, l, p, pt)
 				if err != nil {
 					l.Err(err).Msg("failed to start coordinator")
-					err = dl.ReleasePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.leaderInterval, dl.WithIndexName(m.leadersIndex))
+					err = dl.ReleasePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.leaderInterval, dl.WithIndexName(m.leadersIndex), dl.WithWaitForActiveShards(m.activeShards))
 					if err != nil {
 						l.Err(err).Msg("failed to release policy leadership")
 					}
 					return
 				}
-
-				cordCtx, canceller := context.WithCancel(ctx)
-				go runCoordinator(cordCtx, cord, l, m.coordRestartDelay)
-				go runCoordinatorOutput(cordCtx, cord, m.bulker, l, m.policiesIndex)
-				pt.cord = cord
-				pt.cordCanceller = canceller
 			} else {
 				err = pt.cord.Update(ctx, p)
 				if err != nil {
@@ -329,8 +386,173 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 	return nil
 }
 
+// claimable returns true if this server holds the lease of leader or may take it over as of now.
+// Expired leases are only taken over once the lease grace of this server has passed as well.
+func (m *monitorT) claimable(leader model.PolicyLeader, now time.Time) (bool, error) {
+	if leader.Server != nil && leader.Server.ID == m.agentMetadata.ID {
+		return true, nil
+	}
+	return leader.Expired(now, m.leaderInterval+m.leaseGrace)
+}
+
+// claimablePinned returns true if this server may lead a policy pinned to the server pinnedTo,
+// given current, the lease of the policy if any.
+// Other servers only lead the policy while the pinned server is not running. The pinned server takes over a lease
+// held by another server once it is released by handOverLeadership or expired, so both never lead the policy at once;
+// the lease grace of a standby does not apply.
+// The liveness of the servers is cached in alive for the duration of an ensureLeadership run.
+func (m *monitorT) claimablePinned(ctx context.Context, current *model.PolicyLeader, pinnedTo string, alive map[string]bool, now time.Time) (bool, error) {
+	if pinnedTo == m.agentMetadata.ID {
+		if current == nil || current.Server == nil || current.Server.ID == m.agentMetadata.ID {
+			return true, nil
+		}
+		return current.Expired(now, m.leaderInterval)
+	}
+	running, ok := alive[pinnedTo]
+	if !ok {
+		var err error
+		running, err = m.serverRunning(ctx, pinnedTo, now)
+		if err != nil {
+			return false, err
+		}
+		alive[pinnedTo] = running
+	}
+	if running {
+		return false, nil
+	}
+	if current == nil {
+		return true, nil
+	}
+	return m.claimable(*current, now)
+}
+
+// serverRunning returns true if the server updated its document within the lease duration.
+func (m *monitorT) serverRunning(ctx context.Context, serverID string, now time.Time) (bool, error) {
+	server, err := dl.GetServer(ctx, m.bulker, serverID, dl.WithIndexName(m.serversIndex))
+	if err != nil {
+		if errors.Is(err, es.ErrElasticNotFound) || errors.Is(err, es.ErrIndexNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch server %s: %w", serverID, err)
+	}
+	expired, err := server.Expired(now, m.leaderInterval+m.leaseGrace)
+	if err != nil {
+		return false, err
+	}
+	return !expired, nil
+}
+
+// handOverLeadership stops the coordinator of a policy pinned to another server that is running again,
+// and releases the lease for it to take over.
+func (m *monitorT) handOverLeadership(ctx context.Context, policyID, pinnedTo string) {
+	l := zerolog.Ctx(ctx).With().Str("ctx", "policy leader manager").Str(dl.FieldPolicyID, policyID).Logger()
+	l.Info().Str("server_id", pinnedTo).Msg("pinned server is running, handing over policy leadership")
+
+	pt := m.policies[policyID]
+	if pt.cordCanceller != nil {
+		pt.cordCanceller()
+	}
+	delete(m.policies, policyID)
+
+	m.muPoliciesCanceller.Lock()
+	delete(m.policiesCanceller, policyID)
+	m.muPoliciesCanceller.Unlock()
+
+	if err := dl.ReleasePolicyLeadership(ctx, m.bulker, policyID, m.agentMetadata.ID, m.leaderInterval, dl.WithIndexName(m.leadersIndex), dl.WithWaitForActiveShards(m.activeShards)); err != nil {
+		l.Warn().Err(err).Msg("failed to release policy leadership")
+	}
+}
+
+// startCoordinator creates and runs the coordinator for a policy this monitor leads.
+func (m *monitorT) startCoordinator(ctx context.Context, l zerolog.Logger, p model.Policy, pt policyT) (policyT, error) {
+	cord, err := m.factory(p)
+	if err != nil {
+		return pt, err
+	}
+
+	cordCtx, canceller := context.WithCancel(ctx)
+	go runCoordinator(cordCtx, cord, l, m.coordRestartDelay)
+	go runCoordinatorOutput(cordCtx, cord, m.bulker, l, m.policiesIndex)
+	pt.cord = cord
+	pt.cordCanceller = canceller
+	return pt, nil
+}
+
+// reconcileLeadership corrects drift between the policies this monitor runs coordinators for
+// and the leader docs in Elasticsearch. Coordinators are stopped for policies whose lease is
+// held by another server, and started for unexpired leases held by this server.
+func (m *monitorT) reconcileLeadership(ctx context.Context) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "policy leader manager").Logger()
+
+	policies, err := dl.QueryLatestPolicies(ctx, m.bulker, dl.WithIndexName(m.policiesIndex))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil
+		}
+		return fmt.Errorf("encountered error while querying policies: %w", err)
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(policies))
+	for i, p := range policies {
+		ids[i] = p.PolicyID
+	}
+	leaders, err := dl.SearchPolicyLeaders(ctx, m.bulker, ids, dl.WithIndexName(m.leadersIndex))
+	if err != nil {
+		return fmt.Errorf("encountered error while fetching policy leaders: %w", err)
+	}
+
+	owned := make(map[string]bool, len(leaders))
+	now := time.Now().UTC()
+	for id, leader := range leaders {
+		if leader.Server == nil || leader.Server.ID != m.agentMetadata.ID {
+			continue
+		}
+		expired, err := leader.Expired(now, m.leaderInterval)
+		if err != nil {
+			return err
+		}
+		owned[id] = !expired
+	}
+
+	for id, pt := range m.policies {
+		if _, ok := owned[id]; ok {
+			continue
+		}
+		log.Warn().Str(dl.FieldPolicyID, id).Msg("no longer policy leader, stopping coordinator")
+		if pt.cordCanceller != nil {
+			pt.cordCanceller()
+		}
+		delete(m.policies, id)
+
+		m.muPoliciesCanceller.Lock()
+		delete(m.policiesCanceller, id)
+		m.muPoliciesCanceller.Unlock()
+	}
+
+	for _, p := range policies {
+		if _, ok := m.policies[p.PolicyID]; ok || !owned[p.PolicyID] {
+			continue
+		}
+		l := log.With().Str(dl.FieldPolicyID, p.PolicyID).Logger()
+		pt, err := m.startCoordinator(ctx, l, p, policyT{id: p.PolicyID})
+		if err != nil {
+			l.Err(err).Msg("failed to start coordinator")
+			continue
+		}
+		l.Info().Msg("re-adopted policy leadership")
+		m.policies[p.PolicyID] = pt
+	}
+	return nil
+}
+
 // releaseLeadership releases current leadership
+//
+// The leases become claimable by other servers releaseDelay from now.
 func (m *monitorT) releaseLeadership() {
+	releaseAt := time.Now().UTC().Add(m.releaseDelay)
 	var wg sync.WaitGroup
 	wg.Add(len(m.policies))
 	for _, pt := range m.policies {
@@ -342,7 +564,7 @@ func (m *monitorT) releaseLeadership() {
 			// monitor will be cancelled at this point in the code
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			err := dl.ReleasePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.leaderInterval, dl.WithIndexName(m.leadersIndex))
+			err := dl.ReleasePolicyLeadershipAt(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.leaderInterval, releaseAt, dl.WithIndexName(m.leadersIndex), dl.WithWaitForActiveShards(m.activeShards))
 			if err != nil {
 				l := zerolog.Ctx(ctx).With().Str("ctx", "policy leader manager").Str(dl.FieldPolicyID, pt.id).Logger()
 				l.Warn().Err(err).Msg("monitor.releaseLeadership: failed to release leadership")
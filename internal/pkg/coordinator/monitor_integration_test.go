@@ -46,7 +46,7 @@ func TestMonitorLeadership(t *testing.T) {
 		t.Fatal(err)
 	}
 	cfg := makeFleetConfig()
-	pm := NewMonitor(cfg, "1.0.0", bulker, pim, NewCoordinatorZero)
+	pm := NewMonitor(cfg, config.Leadership{}, "1.0.0", bulker, pim, NewCoordinatorZero)
 	pm.(*monitorT).serversIndex = serversIndex
 	pm.(*monitorT).leadersIndex = leadersIndex
 	pm.(*monitorT).policiesIndex = policiesIndex
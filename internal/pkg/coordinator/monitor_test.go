@@ -0,0 +1,416 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// idleCoordinator runs until cancelled and never outputs a policy.
+type idleCoordinator struct{}
+
+func newIdleCoordinator(model.Policy) (Coordinator, error) { return idleCoordinator{}, nil }
+
+func (idleCoordinator) Name() string { return "idle" }
+
+func (idleCoordinator) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (idleCoordinator) Update(context.Context, model.Policy) error { return nil }
+
+func (idleCoordinator) Output() <-chan model.Policy { return nil }
+
+func TestReconcileLeadership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	const serverID = "server-1"
+	hit := func(id string, v interface{}) es.HitT {
+		src, err := json.Marshal(v)
+		require.NoError(t, err)
+		return es.HitT{ID: id, Source: src}
+	}
+	leader := func(server string) model.PolicyLeader {
+		return model.PolicyLeader{
+			Server:    &model.ServerMetadata{ID: server},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	// QueryLatestPolicies reads the latest revision of each policy from a terms aggregation.
+	var buckets []es.Bucket
+	for i, id := range []string{"lost", "owned", "kept"} {
+		buckets = append(buckets, es.Bucket{Key: id, Aggregations: map[string]es.HitsT{
+			dl.FieldRevisionIdx: {Hits: []es.HitT{hit(strconv.Itoa(i), model.Policy{PolicyID: id, RevisionIdx: 1})}},
+		}})
+	}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{dl.FieldPolicyID: {Buckets: buckets}},
+	}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetPoliciesLeader, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit("lost", leader("server-2")),
+		hit("owned", leader(serverID)),
+		hit("kept", leader(serverID)),
+	}}}, nil)
+
+	m := NewMonitor(config.Fleet{}, config.Leadership{}, "8.0.0", bulker, nil, newIdleCoordinator).(*monitorT)
+	m.agentMetadata = model.AgentMetadata{ID: serverID}
+
+	// Drift: the monitor still runs "lost", which another server took over,
+	// and does not run "owned", which this server holds.
+	var lostCancelled, keptCancelled bool
+	m.policies["lost"] = policyT{id: "lost", cordCanceller: func() { lostCancelled = true }}
+	m.policies["kept"] = policyT{id: "kept", cordCanceller: func() { keptCancelled = true }}
+
+	err := m.reconcileLeadership(ctx)
+	require.NoError(t, err)
+
+	require.NotContains(t, m.policies, "lost")
+	require.True(t, lostCancelled)
+	require.Contains(t, m.policies, "kept")
+	require.False(t, keptCancelled)
+	require.Contains(t, m.policies, "owned")
+	require.NotNil(t, m.policies["owned"].cord)
+	bulker.AssertExpectations(t)
+}
+
+func TestClaimableStandby(t *testing.T) {
+	const primaryID = "primary"
+	now := time.Now().UTC()
+	lease := func(ago time.Duration) model.PolicyLeader {
+		return model.PolicyLeader{
+			Server:    &model.ServerMetadata{ID: primaryID},
+			Timestamp: now.Add(-ago).Format(time.RFC3339Nano),
+		}
+	}
+
+	newMonitor := func(leadership config.Leadership) *monitorT {
+		m := NewMonitor(config.Fleet{}, leadership, "8.0.0", ftesting.NewMockBulk(), nil, newIdleCoordinator).(*monitorT)
+		m.agentMetadata = model.AgentMetadata{ID: "server-1"}
+		return m
+	}
+	primary := newMonitor(config.Leadership{Role: config.LeadershipRolePrimary, StandbyGrace: time.Minute})
+	standby := newMonitor(config.Leadership{Role: config.LeadershipRoleStandby, StandbyGrace: time.Minute})
+
+	tests := []struct {
+		name    string
+		m       *monitorT
+		ago     time.Duration
+		claimed bool
+	}{
+		{"primary defers to a healthy leader", primary, defaultLeaderInterval / 2, false},
+		{"primary takes over an expired lease", primary, defaultLeaderInterval + time.Second, true},
+		{"standby defers to a healthy primary", standby, defaultLeaderInterval / 2, false},
+		{"standby defers within the grace", standby, defaultLeaderInterval + 30*time.Second, false},
+		{"standby takes over after the grace", standby, defaultLeaderInterval + time.Minute + time.Second, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			claimed, err := tc.m.claimable(lease(tc.ago), now)
+			require.NoError(t, err)
+			require.Equal(t, tc.claimed, claimed)
+		})
+	}
+
+	// A standby always keeps the leases it already holds.
+	held := lease(defaultLeaderInterval + time.Second)
+	held.Server.ID = standby.agentMetadata.ID
+	claimed, err := standby.claimable(held, now)
+	require.NoError(t, err)
+	require.True(t, claimed)
+}
+
+func TestStartupDelay(t *testing.T) {
+	leadership := config.Leadership{StartupDelay: 300 * time.Millisecond}
+	for i := 0; i < 10; i++ {
+		m := NewMonitor(config.Fleet{}, leadership, "8.0.0", ftesting.NewMockBulk(), nil, newIdleCoordinator).(*monitorT)
+		require.GreaterOrEqual(t, m.startupDelay, time.Duration(0))
+		require.Less(t, m.startupDelay, leadership.StartupDelay)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	// The first step of acquiring leadership is registering the server.
+	acquiring := make(chan time.Time, 1)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Read", mock.Anything, dl.FleetServers, "server-1", mock.Anything).Run(func(mock.Arguments) {
+		select {
+		case acquiring <- time.Now():
+		default:
+		}
+	}).Return([]byte(nil), es.ErrIndexNotFound)
+
+	m := NewMonitor(config.Fleet{Agent: config.Agent{ID: "server-1"}}, leadership, "8.0.0", bulker, nil, newIdleCoordinator).(*monitorT)
+	m.startupDelay = 200 * time.Millisecond
+	m.checkInterval = time.Hour
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	select {
+	case at := <-acquiring:
+		require.GreaterOrEqual(t, at.Sub(started), m.startupDelay)
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership acquisition did not start")
+	}
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestReleaseLeadershipDelay(t *testing.T) {
+	const (
+		serverID     = "server-1"
+		releaseDelay = time.Minute
+	)
+	current := model.PolicyLeader{Server: &model.ServerMetadata{ID: serverID}}
+	current.SetTime(time.Now().UTC())
+	data, err := json.Marshal(&current)
+	require.NoError(t, err)
+
+	var released model.PolicyLeader
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Read", mock.Anything, dl.FleetPoliciesLeader, "policy-1", mock.Anything).Return(data, nil).Once()
+	bulker.On("Update", mock.Anything, dl.FleetPoliciesLeader, "policy-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc model.PolicyLeader `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		released = body.Doc
+	}).Return(nil).Once()
+
+	m := NewMonitor(config.Fleet{}, config.Leadership{ReleaseDelay: releaseDelay}, "8.0.0", bulker, nil, newIdleCoordinator).(*monitorT)
+	m.agentMetadata = model.AgentMetadata{ID: serverID}
+	m.policies["policy-1"] = policyT{id: "policy-1"}
+
+	shutdown := time.Now().UTC()
+	m.releaseLeadership()
+	bulker.AssertExpectations(t)
+
+	expired, err := released.Expired(shutdown.Add(releaseDelay-time.Second), m.leaderInterval)
+	require.NoError(t, err)
+	require.False(t, expired, "lease should not be claimable before the release delay")
+	expired, err = released.Expired(time.Now().UTC().Add(releaseDelay+time.Second), m.leaderInterval)
+	require.NoError(t, err)
+	require.True(t, expired, "lease should be claimable after the release delay")
+}
+
+// leasesBulk keeps the servers and policy leaders documents in memory so that several monitors can compete for leases.
+type leasesBulk struct {
+	*ftesting.MockBulk
+	policies []model.Policy
+
+	mu   sync.Mutex
+	docs map[string]map[string][]byte
+}
+
+func newLeasesBulk(policies ...model.Policy) *leasesBulk {
+	return &leasesBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		policies: policies,
+		docs:     map[string]map[string][]byte{dl.FleetServers: {}, dl.FleetPoliciesLeader: {}},
+	}
+}
+
+func (b *leasesBulk) Read(_ context.Context, index, id string, _ ...bulk.Opt) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.docs[index][id]
+	if !ok {
+		return nil, es.ErrElasticNotFound
+	}
+	return data, nil
+}
+
+func (b *leasesBulk) Create(_ context.Context, index, id string, body []byte, _ ...bulk.Opt) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.docs[index][id] = body
+	return id, nil
+}
+
+func (b *leasesBulk) Update(_ context.Context, index, id string, body []byte, _ ...bulk.Opt) error {
+	var update struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	if err := json.Unmarshal(body, &update); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.docs[index][id] = update.Doc
+	return nil
+}
+
+func (b *leasesBulk) Search(_ context.Context, index string, _ []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	if index == dl.FleetPolicies {
+		var buckets []es.Bucket
+		for i, p := range b.policies {
+			src, err := json.Marshal(p)
+			if err != nil {
+				return nil, err
+			}
+			buckets = append(buckets, es.Bucket{Key: p.PolicyID, Aggregations: map[string]es.HitsT{
+				dl.FieldRevisionIdx: {Hits: []es.HitT{{ID: strconv.Itoa(i), Source: src}}},
+			}})
+		}
+		return &es.ResultT{Aggregations: map[string]es.Aggregation{dl.FieldPolicyID: {Buckets: buckets}}}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := &es.ResultT{}
+	for id, data := range b.docs[index] {
+		res.Hits = append(res.Hits, es.HitT{ID: id, Source: data})
+	}
+	return res, nil
+}
+
+// leader returns the server leading the policy.
+func (b *leasesBulk) leader(t *testing.T, policyID string) string {
+	t.Helper()
+	data, err := b.Read(context.Background(), dl.FleetPoliciesLeader, policyID)
+	require.NoError(t, err)
+	var l model.PolicyLeader
+	require.NoError(t, json.Unmarshal(data, &l))
+	return l.Server.ID
+}
+
+// stop ages the server document and the leases held by the server as if it stopped running.
+func (b *leasesBulk) stop(t *testing.T, serverID string, ago time.Duration) {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	at := time.Now().UTC().Add(-ago)
+
+	var server model.Server
+	require.NoError(t, json.Unmarshal(b.docs[dl.FleetServers][serverID], &server))
+	server.SetTime(at)
+	data, err := json.Marshal(&server)
+	require.NoError(t, err)
+	b.docs[dl.FleetServers][serverID] = data
+
+	for id, data := range b.docs[dl.FleetPoliciesLeader] {
+		var l model.PolicyLeader
+		require.NoError(t, json.Unmarshal(data, &l))
+		if l.Server.ID != serverID {
+			continue
+		}
+		l.SetTime(at)
+		data, err := json.Marshal(&l)
+		require.NoError(t, err)
+		b.docs[dl.FleetPoliciesLeader][id] = data
+	}
+}
+
+func TestPinnedPolicyLeadership(t *testing.T) {
+	// Coordinators log from their own goroutines, possibly once the test completed; they are not logged to the test.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bulker := newLeasesBulk(model.Policy{PolicyID: "pinned", RevisionIdx: 1}, model.Policy{PolicyID: "free", RevisionIdx: 1})
+	leadership := config.Leadership{PinnedPolicies: []config.PolicyPin{{PolicyID: "pinned", ServerID: "server-1"}}}
+	newMonitor := func(serverID string) *monitorT {
+		m := NewMonitor(config.Fleet{}, leadership, "8.0.0", bulker, nil, newIdleCoordinator).(*monitorT)
+		m.agentMetadata = model.AgentMetadata{ID: serverID}
+		return m
+	}
+	pinned, other := newMonitor("server-1"), newMonitor("server-2")
+
+	// The other server defers to the running pinned server, which has not taken the lease yet.
+	require.NoError(t, pinned.ensureLeadership(ctx))
+	bulker.stop(t, "server-1", 0)
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.Contains(t, pinned.policies, "pinned")
+	require.NotContains(t, other.policies, "pinned")
+	require.Equal(t, "server-1", bulker.leader(t, "pinned"))
+
+	// Unpinned policies are not affected.
+	require.Contains(t, pinned.policies, "free")
+	require.NotContains(t, other.policies, "free")
+
+	// The pinned server stops: the other server takes over once its lease expired.
+	bulker.stop(t, "server-1", defaultLeaderInterval/2)
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.NotContains(t, other.policies, "pinned")
+
+	bulker.stop(t, "server-1", 2*defaultLeaderInterval)
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.Contains(t, other.policies, "pinned")
+	require.Equal(t, "server-2", bulker.leader(t, "pinned"))
+
+	// The pinned server is back: it waits for the other server to hand the policy over, they never both lead it.
+	pinned = newMonitor("server-1")
+	require.NoError(t, pinned.ensureLeadership(ctx))
+	require.NotContains(t, pinned.policies, "pinned")
+	require.Contains(t, other.policies, "pinned")
+	require.Equal(t, "server-2", bulker.leader(t, "pinned"))
+
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.NotContains(t, other.policies, "pinned")
+	require.Contains(t, other.policies, "free")
+	require.NotContains(t, pinned.policies, "pinned")
+
+	require.NoError(t, pinned.ensureLeadership(ctx))
+	require.Contains(t, pinned.policies, "pinned")
+	require.Equal(t, "server-1", bulker.leader(t, "pinned"))
+
+	// The other server keeps deferring to the pinned server.
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.NotContains(t, other.policies, "pinned")
+	require.Equal(t, "server-1", bulker.leader(t, "pinned"))
+}
+
+func TestPinnedPolicyLeadershipExpiredLease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bulker := newLeasesBulk(model.Policy{PolicyID: "pinned", RevisionIdx: 1})
+	leadership := config.Leadership{PinnedPolicies: []config.PolicyPin{{PolicyID: "pinned", ServerID: "server-1"}}}
+	newMonitor := func(serverID string) *monitorT {
+		m := NewMonitor(config.Fleet{}, leadership, "8.0.0", bulker, nil, newIdleCoordinator).(*monitorT)
+		m.agentMetadata = model.AgentMetadata{ID: serverID}
+		return m
+	}
+
+	other := newMonitor("server-2")
+	require.NoError(t, other.ensureLeadership(ctx))
+	require.Equal(t, "server-2", bulker.leader(t, "pinned"))
+
+	// The other server stops without handing the policy over, the pinned server takes the lease once expired.
+	pinned := newMonitor("server-1")
+	bulker.stop(t, "server-2", defaultLeaderInterval/2)
+	require.NoError(t, pinned.ensureLeadership(ctx))
+	require.NotContains(t, pinned.policies, "pinned")
+
+	bulker.stop(t, "server-2", 2*defaultLeaderInterval)
+	require.NoError(t, pinned.ensureLeadership(ctx))
+	require.Contains(t, pinned.policies, "pinned")
+	require.Equal(t, "server-1", bulker.leader(t, "pinned"))
+}
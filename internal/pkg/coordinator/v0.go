@@ -7,6 +7,7 @@ package coordinator
 import (
 	"context"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 
 	"github.com/rs/zerolog"
@@ -15,8 +16,10 @@ import (
 // coordinatorZeroT is V0 coordinator that just takes a subscribed policy and outputs the same policy.
 type coordinatorZeroT struct {
 	policy model.Policy
-	in     chan model.Policy
-	out    chan model.Policy
+	// hash is the output hash of the last distributed policy.
+	hash string
+	in   chan model.Policy
+	out  chan model.Policy
 }
 
 // NewCoordinatorZero creates a V0 coordinator.
@@ -35,7 +38,7 @@ func (c *coordinatorZeroT) Name() string {
 
 // Run runs the coordinator for the policy.
 func (c *coordinatorZeroT) Run(ctx context.Context) error {
-	err := c.updatePolicy(c.policy)
+	err := c.updatePolicy(ctx, c.policy)
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to handle policy")
 	}
@@ -43,7 +46,7 @@ func (c *coordinatorZeroT) Run(ctx context.Context) error {
 	for {
 		select {
 		case p := <-c.in:
-			err = c.updatePolicy(p)
+			err = c.updatePolicy(ctx, p)
 			if err != nil {
 				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to handle policy")
 				continue
@@ -66,16 +69,34 @@ func (c *coordinatorZeroT) Output() <-chan model.Policy {
 }
 
 // updatePolicy performs the working of incrementing the coordinator idx.
-func (c *coordinatorZeroT) updatePolicy(p model.Policy) error {
+//
+// A revision whose output hash matches the last distributed policy is skipped, so no-op revisions
+// do not cause agents to receive the policy again.
+func (c *coordinatorZeroT) updatePolicy(ctx context.Context, p model.Policy) error {
 	_, err := c.handlePolicy(p.Data)
 	if err != nil {
 		return err
 	}
-	if p.CoordinatorIdx == 0 {
-		p.CoordinatorIdx += 1
-		c.policy = p
-		c.out <- p
+	hash, err := p.Data.OutputHash()
+	if err != nil {
+		return err
+	}
+	if p.CoordinatorIdx != 0 {
+		// already distributed, remember what the agents are running
+		c.hash = hash
+		return nil
+	}
+	if hash != "" && hash == c.hash {
+		zerolog.Ctx(ctx).Debug().
+			Str(dl.FieldPolicyID, p.PolicyID).
+			Int64(dl.FieldRevisionIdx, p.RevisionIdx).
+			Msg("policy output unchanged, skipping revision")
+		return nil
 	}
+	p.CoordinatorIdx += 1
+	c.policy = p
+	c.hash = hash
+	c.out <- p
 	return nil
 }
 
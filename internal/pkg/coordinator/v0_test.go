@@ -90,3 +90,81 @@ func TestCoordinatorZero(t *testing.T) {
 		break
 	}
 }
+
+func TestCoordinatorZeroSkipsUnchangedOutput(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+
+	policyId := uuid.Must(uuid.NewV4()).String()
+	newData := func(rev int64, host string) *model.PolicyData {
+		return &model.PolicyData{
+			ID:       policyId,
+			Revision: rev,
+			Outputs: map[string]map[string]interface{}{
+				"default": {
+					"type":  "elasticsearch",
+					"hosts": []interface{}{host},
+				},
+			},
+		}
+	}
+
+	coord, err := NewCoordinatorZero(model.Policy{
+		PolicyID:    policyId,
+		Data:        newData(1, "https://es:9200"),
+		RevisionIdx: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		if err := coord.Run(ctx); err != nil && err != context.Canceled {
+			t.Error(err)
+			return
+		}
+	}()
+
+	select {
+	case newPolicy := <-coord.Output():
+		if newPolicy.RevisionIdx != 1 {
+			t.Fatalf("revision_idx should be set to 1, it was set to %d", newPolicy.RevisionIdx)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("never receive a new policy")
+	}
+
+	// revision bump with identical output; should not be redistributed
+	if err := coord.Update(ctx, model.Policy{
+		PolicyID:    policyId,
+		Data:        newData(2, "https://es:9200"),
+		RevisionIdx: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-coord.Output():
+		t.Fatal("should not have got a new policy")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// revision with a changed output; should be redistributed
+	if err := coord.Update(ctx, model.Policy{
+		PolicyID:    policyId,
+		Data:        newData(3, "https://other-es:9200"),
+		RevisionIdx: 3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case newPolicy := <-coord.Output():
+		if newPolicy.RevisionIdx != 3 {
+			t.Fatalf("revision_idx should be set to 3, it was set to %d", newPolicy.RevisionIdx)
+		}
+		if newPolicy.CoordinatorIdx != 1 {
+			t.Fatalf("coordinator_idx should be set to 1, it was set to %d", newPolicy.CoordinatorIdx)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("never receive a new policy")
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/rs/zerolog"
@@ -38,3 +40,42 @@ func createActionResult(ctx context.Context, bulker bulk.Bulk, index string, acr
 	}
 	return err
 }
+
+// prepareActionResultsByErrorCode selects the results that failed with errorCode, of the action if actionID is set, oldest first.
+// The .fleet-actions-results mapping of Elasticsearch does not index error_code, the field is read from the source of
+// each result with a runtime field.
+func prepareActionResultsByErrorCode(actionID, errorCode string, size int) []byte {
+	root := dsl.NewRoot()
+	root.Size(uint64(size))
+	root.Param("runtime_mappings", map[string]interface{}{
+		FieldErrorCode: map[string]interface{}{"type": "keyword"},
+	})
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldErrorCode, errorCode, nil)
+	if actionID != "" {
+		filter.Term(FieldActionID, actionID, nil)
+	}
+	root.Sort().SortOrder("@timestamp", dsl.SortAscend)
+	return root.MustMarshalJSON()
+}
+
+// FindActionResultsByErrorCode returns up to size results, oldest first, of agents that failed an action with the reason code.
+// An empty actionID matches the results of all actions, for instance to find the upgrades of a campaign failing for the same reason.
+// The code is matched against the source of every result in scope, set actionID whenever possible.
+func FindActionResultsByErrorCode(ctx context.Context, bulker bulk.Bulk, actionID, errorCode string, size int, opt ...Option) ([]model.ActionResult, error) {
+	o := newOption(FleetActionsResults, opt...)
+	res, err := bulker.Search(ctx, o.indexName, prepareActionResultsByErrorCode(actionID, errorCode, size))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for action results by error code: %w", err)
+	}
+	results := make([]model.ActionResult, len(res.Hits))
+	for i, hit := range res.Hits {
+		if err := hit.Unmarshal(&results[i]); err != nil {
+			return nil, fmt.Errorf("could not unmarshal ES document into model.ActionResult: %w", err)
+		}
+	}
+	return results, nil
+}
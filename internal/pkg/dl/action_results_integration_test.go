@@ -137,3 +137,64 @@ func TestActionsResultsStored(t *testing.T) {
 		}
 	}
 }
+
+func TestFindActionResultsByErrorCode(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	// The Fleet system data stream, so the results are searched with the mapping of Elasticsearch.
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetActionsResults)
+
+	actionID := uuid.Must(uuid.NewV4()).String()
+	now := time.Now().UTC()
+	var expected []model.ActionResult
+	for i, code := range []string{"DOWNLOAD_FAILED", "VERIFY_FAILED", "DOWNLOAD_FAILED", ""} {
+		result := model.ActionResult{
+			Timestamp: now.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+			AgentID:   uuid.Must(uuid.NewV4()).String(),
+			ActionID:  actionID,
+			Error:     "upgrade failed",
+			ErrorCode: code,
+		}
+		if err := createActionResult(ctx, bulker, index, result); err != nil {
+			t.Fatal(err)
+		}
+		if code == "DOWNLOAD_FAILED" {
+			expected = append(expected, result)
+		}
+	}
+	// Another action failing for the same reason.
+	other := model.ActionResult{
+		Timestamp: now.Format(time.RFC3339),
+		AgentID:   uuid.Must(uuid.NewV4()).String(),
+		ActionID:  uuid.Must(uuid.NewV4()).String(),
+		ErrorCode: "DOWNLOAD_FAILED",
+	}
+	if err := createActionResult(ctx, bulker, index, other); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := FindActionResultsByErrorCode(ctx, bulker, actionID, "DOWNLOAD_FAILED", 10, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents := func(results []model.ActionResult) []string {
+		ids := make([]string, len(results))
+		for i, r := range results {
+			ids[i] = r.AgentID
+		}
+		return ids
+	}
+	if diff := cmp.Diff(agents(expected), agents(results)); diff != "" {
+		t.Fatalf("unexpected results of the action: %v", diff)
+	}
+
+	results, err = FindActionResultsByErrorCode(ctx, bulker, "", "DOWNLOAD_FAILED", 10, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results of all actions, got %d", len(results))
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package dl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestCreateActionResultErrorCode(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Create", mock.Anything, FleetActionsResults, "action-1:agent-1", mock.MatchedBy(func(body []byte) bool {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return false
+		}
+		return doc["error"] == "failed to download the artifact" && doc[FieldErrorCode] == "DOWNLOAD_FAILED"
+	}), mock.Anything).Return("action-1:agent-1", nil).Once()
+
+	err := CreateActionResult(ctx, bulker, model.ActionResult{
+		ActionID:  "action-1",
+		AgentID:   "agent-1",
+		Error:     "failed to download the artifact",
+		ErrorCode: "DOWNLOAD_FAILED",
+	})
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+}
+
+func TestPrepareActionResultsByErrorCode(t *testing.T) {
+	assert.JSONEq(t,
+		`{"query":{"bool":{"filter":[{"term":{"error_code":"DOWNLOAD_FAILED"}},{"term":{"action_id":"action-1"}}]}},`+
+			`"runtime_mappings":{"error_code":{"type":"keyword"}},"size":10,"sort":["@timestamp"]}`,
+		string(prepareActionResultsByErrorCode("action-1", "DOWNLOAD_FAILED", 10)))
+	assert.JSONEq(t,
+		`{"query":{"bool":{"filter":[{"term":{"error_code":"DOWNLOAD_FAILED"}}]}},`+
+			`"runtime_mappings":{"error_code":{"type":"keyword"}},"size":10,"sort":["@timestamp"]}`,
+		string(prepareActionResultsByErrorCode("", "DOWNLOAD_FAILED", 10)))
+}
+
+func TestFindActionResultsByErrorCode(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	src, err := json.Marshal(model.ActionResult{ActionID: "action-1", AgentID: "agent-1", Error: "failed to download the artifact", ErrorCode: "DOWNLOAD_FAILED"})
+	require.NoError(t, err)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetActionsResults, prepareActionResultsByErrorCode("action-1", "DOWNLOAD_FAILED", 10), mock.Anything).
+		Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "action-1:agent-1", Source: src}}}}, nil)
+
+	results, err := FindActionResultsByErrorCode(ctx, bulker, "action-1", "DOWNLOAD_FAILED", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "agent-1", results[0].AgentID)
+	assert.Equal(t, "DOWNLOAD_FAILED", results[0].ErrorCode)
+
+	// Nothing failed if no action result was ever written.
+	bulker = ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetActionsResults, mock.Anything, mock.Anything).Return((*es.ResultT)(nil), es.ErrIndexNotFound)
+	results, err = FindActionResultsByErrorCode(ctx, bulker, "", "DOWNLOAD_FAILED", 10)
+	require.NoError(t, err)
+	require.Empty(t, results)
+}
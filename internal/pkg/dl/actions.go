@@ -24,7 +24,7 @@ const (
 	FieldExpiration = "expiration"
 	FieldSize       = "size"
 
-	maxAgentActionsFetchSize = 100
+	defaultAgentActionsFetchSize = 100
 )
 
 var (
@@ -79,8 +79,9 @@ func prepareFindAgentActions() *dsl.Tmpl {
 
 	filter.Terms(FieldAgents, tmpl.Bind(FieldAgents), nil)
 
-	// Select more actions per agent since the agents array is not loaded
-	root.Size(maxAgentActionsFetchSize)
+	// The window is bounded by size; actions are sorted by seq_no so the agent
+	// acks the oldest pending actions first and gets the rest on the next checkin.
+	root.WithSize(tmpl.Bind(FieldSize))
 	root.Source().Excludes(FieldAgents)
 
 	tmpl.MustResolve(root)
@@ -109,13 +110,19 @@ func FindAction(ctx context.Context, bulker bulk.Bulk, id string, opts ...Option
 	}, nil)
 }
 
-func FindAgentActions(ctx context.Context, bulker bulk.Bulk, minSeqNo, maxSeqNo sqn.SeqNo, agentID string) ([]model.Action, error) {
+// FindAgentActions returns up to size pending actions for the agent, oldest first.
+// A size of 0 or less uses the default of 100 actions.
+func FindAgentActions(ctx context.Context, bulker bulk.Bulk, minSeqNo, maxSeqNo sqn.SeqNo, agentID string, size int) ([]model.Action, error) {
 	const index = FleetActions
+	if size <= 0 {
+		size = defaultAgentActionsFetchSize
+	}
 	params := map[string]interface{}{
 		FieldSeqNo:      minSeqNo.Value(),
 		FieldMaxSeqNo:   maxSeqNo.Value(),
 		FieldExpiration: time.Now().UTC().Format(time.RFC3339),
 		FieldAgents:     []string{agentID},
+		FieldSize:       size,
 	}
 
 	res, err := findActionsHits(ctx, bulker, QueryAgentActions, index, params, maxSeqNo)
@@ -5,22 +5,38 @@
 package dl
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"golang.org/x/sync/errgroup"
 )
 
+// The access API key expiration and pending key fields must be mapped by the .fleet-agents system index of
+// Elasticsearch for QueryAgentByPendingAccessAPIKeyID to find the agents.
 const (
-	FieldAccessAPIKeyID = "access_api_key_id"
+	FieldAccessAPIKeyID                = "access_api_key_id"
+	FieldAccessAPIKeyExpiration        = "access_api_key_expiration"
+	FieldPendingAccessAPIKeyID         = "pending_access_api_key_id"
+	FieldPendingAccessAPIKeyExpiration = "pending_access_api_key_expiration"
 )
 
 var (
-	QueryAgentByAssessAPIKeyID = prepareAgentFindByAccessAPIKeyID()
-	QueryAgentByID             = prepareAgentFindByID()
-	QueryAgentByEnrollmentID   = prepareAgentFindByEnrollmentID()
+	QueryAgentByAssessAPIKeyID        = prepareAgentFindByAccessAPIKeyID()
+	QueryAgentByPendingAccessAPIKeyID = prepareAgentFindByPendingAccessAPIKeyID()
+	QueryAgentByID                    = prepareAgentFindByID()
+	QueryAgentByEnrollmentID          = prepareAgentFindByEnrollmentID()
+	QueryInactiveAgents               = prepareFindInactiveAgents()
+	QueryEnrollingAgents              = prepareFindEnrollingAgents()
+	QueryCountActiveAgentsOnPolicy    = prepareCountActiveAgentsOnPolicy()
 )
 
 func prepareAgentFindByID() *dsl.Tmpl {
@@ -31,6 +47,10 @@ func prepareAgentFindByAccessAPIKeyID() *dsl.Tmpl {
 	return prepareAgentFindByField(FieldAccessAPIKeyID)
 }
 
+func prepareAgentFindByPendingAccessAPIKeyID() *dsl.Tmpl {
+	return prepareAgentFindByField(FieldPendingAccessAPIKeyID)
+}
+
 func prepareAgentFindByEnrollmentID() *dsl.Tmpl {
 	return prepareAgentFindByField(FieldEnrollmentID)
 }
@@ -39,6 +59,47 @@ func prepareAgentFindByField(field string) *dsl.Tmpl {
 	return prepareFindByField(field, map[string]interface{}{"version": true})
 }
 
+// prepareFindInactiveAgents selects active agents that last checked in before the bound timestamp, oldest first.
+func prepareFindInactiveAgents() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActive, true, nil)
+	filter.Range(FieldLastCheckin, dsl.WithRangeLTE(tmpl.Bind(FieldLastCheckin)))
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldLastCheckin, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindEnrollingAgents selects active agents that enrolled before the bound timestamp but never checked in, oldest first.
+func prepareFindEnrollingAgents() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	query := root.Query().Bool()
+	filter := query.Filter()
+	filter.Term(FieldActive, true, nil)
+	filter.Range(FieldEnrolledAt, dsl.WithRangeLTE(tmpl.Bind(FieldEnrolledAt)))
+	query.MustNot().Exists(FieldLastCheckin)
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldEnrolledAt, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareCountActiveAgentsOnPolicy counts the active agents assigned to the bound policy, without returning them.
+func prepareCountActiveAgentsOnPolicy() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Size(0)
+	root.Param("track_total_hits", true)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActive, true, nil)
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func FindAgent(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, name string, v interface{}, opt ...Option) (model.Agent, error) {
 	o := newOption(FleetAgents, opt...)
 	res, err := SearchWithOneParam(ctx, bulker, tmpl, o.indexName, name, v)
@@ -57,3 +118,303 @@ func FindAgent(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, name strin
 
 	return agent, nil
 }
+
+// filterExistingAgentsConcurrency bounds the number of agent reads FilterExistingAgents has in flight.
+const filterExistingAgentsConcurrency = 100
+
+// FilterExistingAgents returns the subset of ids that still have an agent document, in the order they were passed.
+// Up to filterExistingAgentsConcurrency reads are issued concurrently so the bulker batches them into mget requests.
+func FilterExistingAgents(ctx context.Context, bulker bulk.Bulk, ids []string, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+
+	exists := make([]bool, len(ids))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(filterExistingAgentsConcurrency)
+	for i, id := range ids {
+		i, id := i, id
+		g.Go(func() error {
+			_, err := bulker.Read(gctx, o.indexName, id)
+			switch {
+			case err == nil:
+				exists[i] = true
+			case errors.Is(err, es.ErrElasticNotFound), errors.Is(err, es.ErrIndexNotFound):
+			default:
+				return fmt.Errorf("failed reading agent %s: %w", id, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if exists[i] {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+// FindInactiveAgents returns up to size active agents that have not checked in since lastCheckinBefore.
+func FindInactiveAgents(ctx context.Context, bulker bulk.Bulk, lastCheckinBefore time.Time, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	agents, err := searchAgents(ctx, bulker, QueryInactiveAgents, o.indexName, map[string]interface{}{
+		FieldLastCheckin: lastCheckinBefore.UTC().Format(time.RFC3339),
+		FieldSize:        size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed searching for inactive agents: %w", err)
+	}
+	return agents, nil
+}
+
+// CountActiveAgentsOnPolicy returns the number of active agents assigned to the policy.
+func CountActiveAgentsOnPolicy(ctx context.Context, bulker bulk.Bulk, policyID string, opt ...Option) (int64, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := SearchWithOneParam(ctx, bulker, QueryCountActiveAgentsOnPolicy, o.indexName, FieldPolicyID, policyID)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed counting agents on policy: %w", err)
+	}
+	return int64(res.Total.Value), nil
+}
+
+// FindEnrollingAgents returns up to size active agents that enrolled before enrolledBefore and never checked in.
+func FindEnrollingAgents(ctx context.Context, bulker bulk.Bulk, enrolledBefore time.Time, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	agents, err := searchAgents(ctx, bulker, QueryEnrollingAgents, o.indexName, map[string]interface{}{
+		FieldEnrolledAt: enrolledBefore.UTC().Format(time.RFC3339),
+		FieldSize:       size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed searching for enrolling agents: %w", err)
+	}
+	return agents, nil
+}
+
+func searchAgents(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}) ([]model.Agent, error) {
+	res, err := Search(ctx, bulker, tmpl, index, params)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	agents := make([]model.Agent, len(res.Hits))
+	for i, hit := range res.Hits {
+		if err := hit.Unmarshal(&agents[i]); err != nil {
+			return nil, fmt.Errorf("could not unmarshal ES document into model.Agent: %w", err)
+		}
+	}
+	return agents, nil
+}
+
+// tagAgentsScript appends the missing tags and leaves agents that already have all of them untouched.
+const tagAgentsScript = `if (ctx._source.tags == null) { ctx._source.tags = new ArrayList(); }
+boolean changed = false;
+for (tag in params.tags) { if (!ctx._source.tags.contains(tag)) { ctx._source.tags.add(tag); changed = true; } }
+if (changed) { ctx._source.updated_at = params.updated_at; } else { ctx.op = 'noop'; }`
+
+// tagAgentsConflictRetries bounds the runs of TagAgentsMatching over the agents that were updated concurrently.
+const tagAgentsConflictRetries = 3
+
+// ErrTagAgentsConflicts is returned when agents could not be tagged because they kept being updated concurrently.
+var ErrTagAgentsConflicts = errors.New("agents updated concurrently were not tagged")
+
+// TagAgentsMatching adds tags to every agent matching query, an Elasticsearch query clause such as
+// {"term":{"agent.version":"8.11.0"}}. Tags an agent already has are not duplicated.
+//
+// Agents updated concurrently are skipped by a run and tagged by the following one, as the agents already tagged
+// are left untouched. If some still conflict after tagAgentsConflictRetries runs ErrTagAgentsConflicts is returned.
+// It returns the number of agents that were updated, also on error.
+func TagAgentsMatching(ctx context.Context, bulker bulk.Bulk, query json.RawMessage, tags []string, opt ...Option) (int64, error) {
+	o := newOption(FleetAgents, opt...)
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	var updated int64
+	for run := 0; ; run++ {
+		res, err := tagAgentsMatching(ctx, bulker, o.indexName, query, tags)
+		if err != nil {
+			return updated, err
+		}
+		updated += res.Updated
+		if len(res.Failures) > 0 {
+			return updated, fmt.Errorf("tag agents: %d failures, first: %s", len(res.Failures), res.Failures[0])
+		}
+		if res.VersionConflicts == 0 {
+			return updated, nil
+		}
+		if run >= tagAgentsConflictRetries {
+			return updated, fmt.Errorf("%w: %d agents", ErrTagAgentsConflicts, res.VersionConflicts)
+		}
+	}
+}
+
+func tagAgentsMatching(ctx context.Context, bulker bulk.Bulk, index string, query json.RawMessage, tags []string) (*es.UpdateByQueryResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": query,
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": tagAgentsScript,
+			"params": map[string]interface{}{
+				FieldTags:      tags,
+				FieldUpdatedAt: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tag agents: %w", err)
+	}
+
+	client := bulker.Client()
+	res, err := client.API.UpdateByQuery([]string{index},
+		client.API.UpdateByQuery.WithBody(bytes.NewReader(body)),
+		client.API.UpdateByQuery.WithConflicts("proceed"),
+		client.API.UpdateByQuery.WithRefresh(true),
+		client.API.UpdateByQuery.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("tag agents: %w", err)
+	}
+	defer res.Body.Close()
+
+	var esres es.UpdateByQueryResponse
+	if res.IsError() {
+		// The body of an error may not be an Elasticsearch error, the status is translated regardless.
+		_ = json.NewDecoder(res.Body).Decode(&esres)
+		return nil, es.TranslateError(res.StatusCode, esres.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&esres); err != nil {
+		return nil, fmt.Errorf("tag agents: %w", err)
+	}
+	return &esres, nil
+}
+
+// Agent statuses reported by CountAgentsByStatus.
+const (
+	AgentStatusOnline      = "online"
+	AgentStatusOffline     = "offline"
+	AgentStatusError       = "error"
+	AgentStatusDegraded    = "degraded"
+	AgentStatusUnenrolling = "unenrolling"
+)
+
+const aggAgentStatus = "status"
+
+// prepareAgentStatusCounts counts the active agents in each status with a filters aggregation, the status of an agent
+// being derived from its checkin fields: unenrolling, then offline, then error or degraded, otherwise online.
+func prepareAgentStatusCounts(offlineBefore time.Time) []byte {
+	root := dsl.NewRoot()
+	root.Size(0)
+	root.Query().Bool().Filter().Term(FieldActive, true, nil)
+	filters := root.Aggs().Agg(aggAgentStatus).Filters()
+	checkedIn := dsl.WithRangeGTE(offlineBefore.UTC().Format(time.RFC3339Nano))
+
+	filters.Agg(AgentStatusUnenrolling).Exists(FieldUnenrollmentStartedAt)
+
+	offline := filters.Agg(AgentStatusOffline).Bool().MustNot()
+	offline.Bool().Filter().Exists(FieldUnenrollmentStartedAt)
+	offline.Range(FieldLastCheckin, checkedIn)
+
+	for _, status := range []string{AgentStatusError, AgentStatusDegraded} {
+		q := filters.Agg(status).Bool()
+		filter := q.Filter()
+		filter.Range(FieldLastCheckin, checkedIn)
+		filter.Term(FieldLastCheckinStatus, status, nil)
+		q.MustNot().Exists(FieldUnenrollmentStartedAt)
+	}
+
+	online := filters.Agg(AgentStatusOnline).Bool()
+	online.Filter().Range(FieldLastCheckin, checkedIn)
+	notOnline := online.MustNot()
+	notOnline.Bool().Filter().Exists(FieldUnenrollmentStartedAt)
+	notOnline.Terms(FieldLastCheckinStatus, []string{AgentStatusError, AgentStatusDegraded}, nil)
+	return root.MustMarshalJSON()
+}
+
+// CountAgentsByStatus returns the number of active agents in each status.
+// Agents that have not checked in since offlineBefore are offline.
+func CountAgentsByStatus(ctx context.Context, bulker bulk.Bulk, offlineBefore time.Time, opt ...Option) (map[string]int64, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := bulker.Search(ctx, o.indexName, prepareAgentStatusCounts(offlineBefore))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return map[string]int64{}, nil
+		}
+		return nil, err
+	}
+
+	agg, ok := res.Aggregations[aggAgentStatus]
+	if !ok {
+		return nil, ErrMissingAggregations
+	}
+	counts := make(map[string]int64, len(agg.Buckets))
+	for _, b := range agg.Buckets {
+		counts[b.Key] = b.DocCount
+	}
+	return counts, nil
+}
+
+func prepareAgentsOnPoliciesExcept(policyIDs []string, size int) []byte {
+	root := dsl.NewRoot()
+	root.Size(uint64(size))
+	query := root.Query().Bool()
+	query.Filter().Term(FieldActive, true, nil)
+	query.MustNot().Terms(FieldPolicyID, policyIDs, nil)
+	root.Sort().SortOrder(FieldPolicyID, dsl.SortAscend)
+	return root.MustMarshalJSON()
+}
+
+// FindAgentsOnLeaderlessPolicies returns up to size active agents, ordered by policy, assigned to a policy without an active leader.
+// A policy has no active leader if it has no leader document, or if the lease has not been renewed within leaderInterval as of now.
+// The options apply to the agents index.
+func FindAgentsOnLeaderlessPolicies(ctx context.Context, bulker bulk.Bulk, now time.Time, leaderInterval time.Duration, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+
+	leaders := map[string]model.PolicyLeader{}
+	var checkpoint LeadersCheckpoint
+	for {
+		change, err := GetLeadersChangedSince(ctx, bulker, checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading policy leaders: %w", err)
+		}
+		for policyID, l := range change.Leaders {
+			leaders[policyID] = l
+		}
+		if !change.Truncated {
+			break
+		}
+		checkpoint = change.Checkpoint
+	}
+	led := make([]string, 0, len(leaders))
+	for policyID, l := range leaders {
+		if l.Server == nil {
+			continue
+		}
+		if expired, err := l.Expired(now, leaderInterval); err != nil || expired {
+			continue
+		}
+		led = append(led, policyID)
+	}
+
+	res, err := bulker.Search(ctx, o.indexName, prepareAgentsOnPoliciesExcept(led, size))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents on leaderless policies: %w", err)
+	}
+	agents := make([]model.Agent, len(res.Hits))
+	for i, hit := range res.Hits {
+		if err := hit.Unmarshal(&agents[i]); err != nil {
+			return nil, fmt.Errorf("could not unmarshal ES document into model.Agent: %w", err)
+		}
+	}
+	return agents, nil
+}
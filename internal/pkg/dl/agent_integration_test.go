@@ -70,3 +70,46 @@ func TestFindAgent_NewModel(t *testing.T) {
 	assert.Equal(t, agentID, agent.Id)
 	assert.Equal(t, wantOutputs, agent.Outputs)
 }
+
+func TestTagAgentsMatching(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetAgents)
+
+	policyID := uuid.Must(uuid.NewV4()).String()
+	otherPolicyID := uuid.Must(uuid.NewV4()).String()
+	agents := map[string]model.Agent{
+		"untagged": {PolicyID: policyID, Active: true},
+		"tagged":   {PolicyID: policyID, Active: true, Tags: []string{"linux"}},
+		"other":    {PolicyID: otherPolicyID, Active: true, Tags: []string{"windows"}},
+	}
+	for id, agent := range agents {
+		body, err := json.Marshal(agent)
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, index, id, body, bulk.WithRefresh())
+		require.NoError(t, err)
+	}
+
+	query := json.RawMessage(`{"term":{"policy_id":"` + policyID + `"}}`)
+	updated, err := TagAgentsMatching(ctx, bulker, query, []string{"linux", "prod"}, WithIndexName(index))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated)
+
+	want := map[string][]string{
+		"untagged": {"linux", "prod"},
+		"tagged":   {"linux", "prod"},
+		"other":    {"windows"},
+	}
+	for id, tags := range want {
+		agent, err := FindAgent(ctx, bulker, QueryAgentByID, FieldID, id, WithIndexName(index))
+		require.NoError(t, err)
+		assert.Equal(t, tags, agent.Tags, id)
+	}
+
+	// Tagging again is a noop for agents that already have the tags.
+	updated, err = TagAgentsMatching(ctx, bulker, query, []string{"prod"}, WithIndexName(index))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+}
@@ -5,9 +5,28 @@
 package dl
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPrepareAgentFindByEnrollmentID(t *testing.T) {
@@ -16,3 +35,384 @@ func TestPrepareAgentFindByEnrollmentID(t *testing.T) {
 	query, _ := tmpl.RenderOne(FieldEnrollmentID, "1")
 	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"enrollment_id":"1"}}]}},"version":true}`, string(query[:]))
 }
+
+func TestFilterExistingAgents(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	t.Run("mixed existing and deleted agents", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Read", mock.Anything, FleetAgents, "agent1", mock.Anything).Return([]byte(`{}`), nil).Once()
+		bulker.On("Read", mock.Anything, FleetAgents, "deleted1", mock.Anything).Return([]byte(nil), es.ErrElasticNotFound).Once()
+		bulker.On("Read", mock.Anything, FleetAgents, "agent2", mock.Anything).Return([]byte(`{}`), nil).Once()
+		bulker.On("Read", mock.Anything, FleetAgents, "deleted2", mock.Anything).Return([]byte(nil), es.ErrElasticNotFound).Once()
+
+		ids, err := FilterExistingAgents(ctx, bulker, []string{"agent1", "deleted1", "agent2", "deleted2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"agent1", "agent2"}, ids)
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		errRead := errors.New("read failed")
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Read", mock.Anything, FleetAgents, "agent1", mock.Anything).Return([]byte(nil), errRead)
+
+		_, err := FilterExistingAgents(ctx, bulker, []string{"agent1"})
+		assert.ErrorIs(t, err, errRead)
+	})
+
+	t.Run("bounded concurrency", func(t *testing.T) {
+		var inFlight, maxInFlight int64
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Read", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+			n := atomic.AddInt64(&inFlight, 1)
+			for {
+				m := atomic.LoadInt64(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&inFlight, -1)
+		}).Return([]byte(`{}`), nil)
+
+		ids := make([]string, 3*filterExistingAgentsConcurrency)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("agent%d", i)
+		}
+		res, err := FilterExistingAgents(ctx, bulker, ids)
+		require.NoError(t, err)
+		assert.Equal(t, ids, res)
+		assert.LessOrEqual(t, atomic.LoadInt64(&maxInFlight), int64(filterExistingAgentsConcurrency))
+	})
+}
+
+func TestTagAgentsMatchingRequest(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	client, mocktrans := esutil.MockESClient(t)
+	var req struct {
+		Query  json.RawMessage `json:"query"`
+		Script struct {
+			Params struct {
+				Tags []string `json:"tags"`
+			} `json:"params"`
+		} `json:"script"`
+	}
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		assert.Equal(t, "/"+FleetAgents+"/_update_by_query", r.URL.Path)
+		assert.Equal(t, "proceed", r.URL.Query().Get("conflicts"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"total":3,"updated":2,"noops":1}`)),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Client").Return(client)
+
+	query := json.RawMessage(`{"term":{"policy_id":"policy-1"}}`)
+	updated, err := TagAgentsMatching(ctx, bulker, query, []string{"linux", "prod"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated)
+	assert.JSONEq(t, string(query), string(req.Query))
+	assert.Equal(t, []string{"linux", "prod"}, req.Script.Params.Tags)
+}
+
+func TestTagAgentsMatchingConflicts(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	query := json.RawMessage(`{"term":{"policy_id":"policy-1"}}`)
+
+	respond := func(mocktrans *esutil.MockTransport, status int, bodies ...string) *int {
+		calls := 0
+		mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+			body := bodies[len(bodies)-1]
+			if calls < len(bodies) {
+				body = bodies[calls]
+			}
+			calls++
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+			}, nil
+		}
+		return &calls
+	}
+
+	t.Run("conflicted agents are tagged by the next run", func(t *testing.T) {
+		client, mocktrans := esutil.MockESClient(t)
+		calls := respond(mocktrans, http.StatusOK,
+			`{"total":3,"updated":2,"version_conflicts":1}`,
+			`{"total":3,"updated":1,"noops":2}`)
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Client").Return(client)
+
+		updated, err := TagAgentsMatching(ctx, bulker, query, []string{"prod"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), updated)
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("persistent conflicts", func(t *testing.T) {
+		client, mocktrans := esutil.MockESClient(t)
+		calls := respond(mocktrans, http.StatusOK, `{"total":3,"updated":2,"version_conflicts":1}`, `{"total":3,"noops":2,"version_conflicts":1}`)
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Client").Return(client)
+
+		updated, err := TagAgentsMatching(ctx, bulker, query, []string{"prod"})
+		require.ErrorIs(t, err, ErrTagAgentsConflicts)
+		assert.Equal(t, int64(2), updated)
+		assert.Equal(t, tagAgentsConflictRetries+1, *calls)
+	})
+
+	t.Run("error without an Elasticsearch body", func(t *testing.T) {
+		client, mocktrans := esutil.MockESClient(t)
+		respond(mocktrans, http.StatusBadGateway, `<html>Bad Gateway</html>`)
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Client").Return(client)
+
+		_, err := TagAgentsMatching(ctx, bulker, query, []string{"prod"})
+		var esErr *es.ErrElastic
+		require.ErrorAs(t, err, &esErr)
+		assert.Equal(t, http.StatusBadGateway, esErr.Status)
+	})
+}
+
+// policyAgentsBulk answers the leaders and agents searches from in memory documents.
+type policyAgentsBulk struct {
+	*ftesting.MockBulk
+	leaders map[string]model.PolicyLeader
+	agents  []model.Agent
+}
+
+func (b *policyAgentsBulk) Search(_ context.Context, index string, body []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	res := &es.ResultT{}
+	if index == FleetPoliciesLeader {
+		return searchLeaders(b.leaders, body)
+	}
+
+	var query struct {
+		Query struct {
+			Bool struct {
+				MustNot []struct {
+					Terms map[string][]string `json:"terms"`
+				} `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &query); err != nil {
+		return nil, err
+	}
+	excluded := map[string]bool{}
+	for _, clause := range query.Query.Bool.MustNot {
+		for _, id := range clause.Terms[FieldPolicyID] {
+			excluded[id] = true
+		}
+	}
+	for _, agent := range b.agents {
+		if !agent.Active || excluded[agent.PolicyID] {
+			continue
+		}
+		src, err := json.Marshal(agent)
+		if err != nil {
+			return nil, err
+		}
+		res.Hits = append(res.Hits, es.HitT{ID: agent.Id, Source: src})
+	}
+	return res, nil
+}
+
+// searchLeaders answers the changed leaders query: leaders at or after the gte bound that are not excluded
+// as already seen, sorted by @timestamp with millisecond sort values, up to size.
+func searchLeaders(leaders map[string]model.PolicyLeader, body []byte) (*es.ResultT, error) {
+	type rangeT map[string]struct {
+		GTE string `json:"gte"`
+		LTE string `json:"lte"`
+	}
+	var query struct {
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range rangeT `json:"range"`
+				} `json:"filter"`
+				MustNot []struct {
+					Bool struct {
+						Filter []struct {
+							Terms map[string][]string `json:"terms"`
+							Range rangeT              `json:"range"`
+						} `json:"filter"`
+					} `json:"bool"`
+				} `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &query); err != nil {
+		return nil, err
+	}
+	millis := func(s string) (int64, error) {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UnixMilli(), err
+	}
+
+	res := &es.ResultT{}
+	for id, l := range leaders {
+		ts, err := l.Time()
+		if err != nil {
+			return nil, err
+		}
+		ms := ts.UnixMilli()
+		match := true
+		for _, f := range query.Query.Bool.Filter {
+			if r, ok := f.Range["@timestamp"]; ok {
+				since, err := millis(r.GTE)
+				if err != nil {
+					return nil, err
+				}
+				match = match && ms >= since
+			}
+		}
+		for _, mn := range query.Query.Bool.MustNot {
+			seen := false
+			until := int64(0)
+			for _, f := range mn.Bool.Filter {
+				for _, seenID := range f.Terms[FieldID] {
+					seen = seen || seenID == id
+				}
+				if r, ok := f.Range["@timestamp"]; ok {
+					if until, err = millis(r.LTE); err != nil {
+						return nil, err
+					}
+				}
+			}
+			match = match && !(seen && ms <= until)
+		}
+		if !match {
+			continue
+		}
+		src, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		res.Hits = append(res.Hits, es.HitT{ID: id, Source: src, Sort: []interface{}{float64(ms)}})
+	}
+	sort.Slice(res.Hits, func(i, j int) bool {
+		a, b := res.Hits[i].Sort[0].(float64), res.Hits[j].Sort[0].(float64)
+		if a != b {
+			return a < b
+		}
+		return res.Hits[i].ID < res.Hits[j].ID
+	})
+	if query.Size > 0 && len(res.Hits) > query.Size {
+		res.Hits = res.Hits[:query.Size]
+	}
+	return res, nil
+}
+
+func TestFindAgentsOnLeaderlessPolicies(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	const leaderInterval = 30 * time.Second
+	now := time.Now().UTC()
+	lease := func(ago time.Duration) model.PolicyLeader {
+		l := model.PolicyLeader{Server: &model.ServerMetadata{ID: "server-1"}}
+		l.SetTime(now.Add(-ago))
+		return l
+	}
+	agent := func(id, policyID string) model.Agent {
+		return model.Agent{ESDocument: model.ESDocument{Id: id}, Active: true, PolicyID: policyID}
+	}
+
+	bulker := &policyAgentsBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		leaders: map[string]model.PolicyLeader{
+			"led":   lease(leaderInterval / 2),
+			"stale": lease(2 * leaderInterval),
+		},
+		agents: []model.Agent{
+			agent("on-led", "led"),
+			agent("on-stale", "stale"),
+			agent("on-missing", "missing"),
+			{ESDocument: model.ESDocument{Id: "unenrolled"}, PolicyID: "missing"},
+		},
+	}
+
+	agents, err := FindAgentsOnLeaderlessPolicies(ctx, bulker, now, leaderInterval, 100)
+	require.NoError(t, err)
+	ids := make([]string, 0, len(agents))
+	for _, a := range agents {
+		ids = append(ids, a.Id)
+	}
+	assert.ElementsMatch(t, []string{"on-stale", "on-missing"}, ids)
+}
+
+func TestPrepareAgentsOnPoliciesExcept(t *testing.T) {
+	assert.JSONEq(t,
+		`{"query":{"bool":{"filter":[{"term":{"active":true}}],"must_not":[{"terms":{"policy_id":["led"]}}]}},"size":10,"sort":["policy_id"]}`,
+		string(prepareAgentsOnPoliciesExcept([]string{"led"}, 10)))
+}
+
+func TestCountActiveAgentsOnPolicy(t *testing.T) {
+	query, err := QueryCountActiveAgentsOnPolicy.RenderOne(FieldPolicyID, "policy-1")
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"query":{"bool":{"filter":[{"term":{"active":true}},{"term":{"policy_id":"policy-1"}}]}},"size":0,"track_total_hits":true}`,
+		string(query))
+
+	bulker := ftesting.NewMockBulk()
+	res := &es.ResultT{}
+	res.Total.Value = 42
+	bulker.On("Search", mock.Anything, FleetAgents, query, mock.Anything).Return(res, nil)
+	count, err := CountActiveAgentsOnPolicy(context.Background(), bulker, "policy-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+}
+
+func TestCountAgentsByStatus(t *testing.T) {
+	offlineBefore := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	query := prepareAgentStatusCounts(offlineBefore)
+	assert.NotContains(t, string(query), "script")
+
+	var body struct {
+		Aggs map[string]struct {
+			Filters struct {
+				Filters map[string]json.RawMessage `json:"filters"`
+				Keyed   bool                       `json:"keyed"`
+			} `json:"filters"`
+		} `json:"aggs"`
+	}
+	require.NoError(t, json.Unmarshal(query, &body))
+	filters := body.Aggs[aggAgentStatus].Filters
+	assert.False(t, filters.Keyed)
+	assert.Len(t, filters.Filters, 5)
+	assert.JSONEq(t, `{"exists":{"field":"unenrollment_started_at"}}`, string(filters.Filters[AgentStatusUnenrolling]))
+	assert.JSONEq(t,
+		`{"bool":{"filter":[{"range":{"last_checkin":{"gte":"2024-01-02T03:04:05Z"}}},{"term":{"last_checkin_status":"error"}}],"must_not":{"exists":{"field":"unenrollment_started_at"}}}}`,
+		string(filters.Filters[AgentStatusError]))
+	assert.JSONEq(t,
+		`{"bool":{"must_not":[{"bool":{"filter":{"exists":{"field":"unenrollment_started_at"}}}},{"range":{"last_checkin":{"gte":"2024-01-02T03:04:05Z"}}}]}}`,
+		string(filters.Filters[AgentStatusOffline]))
+
+	var agg es.Aggregation
+	require.NoError(t, json.Unmarshal([]byte(`{"buckets":[
+		{"key":"unenrolling","doc_count":1},
+		{"key":"offline","doc_count":2},
+		{"key":"error","doc_count":3},
+		{"key":"degraded","doc_count":0},
+		{"key":"online","doc_count":5}]}`), &agg))
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetAgents, query, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{aggAgentStatus: agg},
+	}, nil)
+	counts, err := CountAgentsByStatus(context.Background(), bulker, offlineBefore)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		AgentStatusUnenrolling: 1,
+		AgentStatusOffline:     2,
+		AgentStatusError:       3,
+		AgentStatusDegraded:    0,
+		AgentStatusOnline:      5,
+	}, counts)
+}
@@ -5,7 +5,8 @@
 package dl
 
 type queryOption struct {
-	indexName string
+	indexName    string
+	activeShards int
 }
 
 // Option for the operation being made
@@ -20,6 +21,15 @@ func WithIndexName(name string) Option {
 	}
 }
 
+// WithWaitForActiveShards makes the writes of the operation wait for n active shard copies
+//
+// 0 keeps the default of the index
+func WithWaitForActiveShards(n int) Option {
+	return func(opt *queryOption) {
+		opt.activeShards = n
+	}
+}
+
 func newOption(defaultIndex string, opts ...Option) queryOption {
 	o := queryOption{indexName: defaultIndex}
 	for _, opt := range opts {
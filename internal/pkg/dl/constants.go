@@ -28,6 +28,7 @@ const (
 	FieldActionSeqNo = "action_seq_no"
 
 	FieldActionID                      = "action_id"
+	FieldErrorCode                     = "error_code"
 	FieldAgent                         = "agent"
 	FieldAgentVersion                  = "version"
 	FieldCoordinatorIdx                = "coordinator_idx"
@@ -45,20 +46,24 @@ const (
 	FieldPolicyRevisionIdx             = "policy_revision_idx"
 	FieldRevisionIdx                   = "revision_idx"
 	FieldUnenrolledReason              = "unenrolled_reason"
+	FieldTags                          = "tags"
 	FiledType                          = "type"
 
-	FieldActive           = "active"
-	FieldUpdatedAt        = "updated_at"
-	FieldUnenrolledAt     = "unenrolled_at"
-	FieldUpgradedAt       = "upgraded_at"
-	FieldUpgradeStartedAt = "upgrade_started_at"
-	FieldUpgradeStatus    = "upgrade_status"
-	FieldUpgradeDetails   = "upgrade_details"
+	FieldActive                = "active"
+	FieldEnrolledAt            = "enrolled_at"
+	FieldUpdatedAt             = "updated_at"
+	FieldUnenrolledAt          = "unenrolled_at"
+	FieldUnenrollmentStartedAt = "unenrollment_started_at"
+	FieldUpgradedAt            = "upgraded_at"
+	FieldUpgradeStartedAt      = "upgrade_started_at"
+	FieldUpgradeStatus         = "upgrade_status"
+	FieldUpgradeDetails        = "upgrade_details"
 
-	FieldDecodedSha256 = "decoded_sha256"
-	FieldIdentifier    = "identifier"
-	FieldSharedID      = "shared_id"
-	FieldEnrollmentID  = "enrollment_id"
+	FieldDecodedSha256      = "decoded_sha256"
+	FieldIdentifier         = "identifier"
+	FieldSharedID           = "shared_id"
+	FieldEnrollmentID       = "enrollment_id"
+	FieldEnrollmentAPIKeyID = "enrollment_api_key_id"
 )
 
 // Private constants
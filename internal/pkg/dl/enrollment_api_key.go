@@ -7,22 +7,48 @@ package dl
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"github.com/rs/zerolog"
 )
 
 const (
 	FieldAPIKeyID = "api_key_id"
+
+	// maxEnrollmentKeys is the default index.max_result_window of Elasticsearch.
+	// ListEnrollmentKeys returns at most this many keys and logs a warning when there are more.
+	maxEnrollmentKeys = 10000
 )
 
 var (
 	QueryEnrollmentAPIKeyByID       = prepareFindActiveEnrollmentAPIKeyByID()
 	QueryEnrollmentAPIKeyByPolicyID = prepareFindActiveEnrollmentAPIKeyByPolicyID()
+
+	queryAllEnrollmentAPIKeys = prepareFindAllEnrollmentAPIKeys()
+	queryEnrollmentKeyUsage   = prepareEnrollmentKeyUsage()
 )
 
+// EnrollmentKeyUsage is an enrollment API key along with the usage statistics of the agents enrolled with it.
+//
+// The statistics are computed from the enrollment_api_key_id field of the agent documents, which is only set
+// by servers recording the enrollment key. Agents enrolled before are not attributed to any key, so a key
+// only used by them has no agents and no LastUsedAt.
+type EnrollmentKeyUsage struct {
+	model.EnrollmentAPIKey
+
+	// AgentCount is the number of agents enrolled with the key, including the unenrolled ones.
+	AgentCount int64
+	// LastUsedAt is the enrolled_at time of the last agent enrolled with the key, empty if no agent is attributed to the key.
+	LastUsedAt string
+}
+
 func prepareFindActiveEnrollmentAPIKeyByID() *dsl.Tmpl {
 	tmpl := dsl.NewTmpl()
 
@@ -47,6 +73,27 @@ func prepareFindActiveEnrollmentAPIKeyByPolicyID() *dsl.Tmpl {
 	return tmpl
 }
 
+func prepareFindAllEnrollmentAPIKeys() []byte {
+	root := dsl.NewRoot()
+	root.Size(maxEnrollmentKeys)
+	root.Query().MatchAll()
+	return root.MustMarshalJSON()
+}
+
+// prepareEnrollmentKeyUsage aggregates the agents by enrollment key. The .fleet-agents mapping of Elasticsearch
+// does not index enrollment_api_key_id, the field is read from the source of each agent with a runtime field.
+func prepareEnrollmentKeyUsage() []byte {
+	root := dsl.NewRoot()
+	root.Size(0)
+	root.Param("runtime_mappings", map[string]interface{}{
+		FieldEnrollmentAPIKeyID: map[string]interface{}{"type": "keyword"},
+	})
+	keyID := root.Aggs().Agg(FieldEnrollmentAPIKeyID)
+	keyID.Terms("field", FieldEnrollmentAPIKeyID, nil).Size(maxEnrollmentKeys)
+	keyID.Aggs().Agg(FieldEnrolledAt).Max().Param("field", FieldEnrolledAt)
+	return root.MustMarshalJSON()
+}
+
 func FindEnrollmentAPIKey(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, field string, id string) (rec model.EnrollmentAPIKey, err error) {
 	return findEnrollmentAPIKey(ctx, bulker, FleetEnrollmentAPIKeys, tmpl, field, id)
 }
@@ -94,3 +141,65 @@ func CreateEnrollmentAPIKey(ctx context.Context, bulker bulk.Bulk, key model.Enr
 	}
 	return bulker.Create(ctx, o.indexName, "", data, bulk.WithRefresh())
 }
+
+// ListEnrollmentKeys returns all the enrollment API keys with the number of agents enrolled with each key
+// and the time the key was last used to enroll an agent.
+//
+// The usage is aggregated from the source of every agent document, the listing is meant for on-demand use only.
+func ListEnrollmentKeys(ctx context.Context, bulker bulk.Bulk) ([]EnrollmentKeyUsage, error) {
+	return listEnrollmentKeys(ctx, bulker, FleetEnrollmentAPIKeys, FleetAgents)
+}
+
+func listEnrollmentKeys(ctx context.Context, bulker bulk.Bulk, keysIndex, agentsIndex string) ([]EnrollmentKeyUsage, error) {
+	res, err := bulker.Search(ctx, keysIndex, queryAllEnrollmentAPIKeys)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return []EnrollmentKeyUsage{}, nil
+		}
+		return nil, err
+	}
+
+	if len(res.Hits) >= maxEnrollmentKeys {
+		zerolog.Ctx(ctx).Warn().Int("limit", maxEnrollmentKeys).Msg("more enrollment API keys than the listing limit, only the first ones are listed")
+	}
+	keys := make([]EnrollmentKeyUsage, len(res.Hits))
+	for i := range res.Hits {
+		if err := res.Hits[i].Unmarshal(&keys[i].EnrollmentAPIKey); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	res, err = bulker.Search(ctx, agentsIndex, queryEnrollmentKeyUsage)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return keys, nil
+		}
+		return nil, err
+	}
+	agg, ok := res.Aggregations[FieldEnrollmentAPIKeyID]
+	if !ok {
+		return nil, ErrMissingAggregations
+	}
+	if agg.SumOtherDocCount > 0 {
+		zerolog.Ctx(ctx).Warn().Int64("agents", agg.SumOtherDocCount).Int("limit", maxEnrollmentKeys).Msg("more used enrollment API keys than the listing limit, the usage of some keys is missing")
+	}
+
+	usage := make(map[string]es.Bucket, len(agg.Buckets))
+	for _, b := range agg.Buckets {
+		usage[b.Key] = b
+	}
+	for i := range keys {
+		b, ok := usage[keys[i].APIKeyID]
+		if !ok {
+			continue
+		}
+		keys[i].AgentCount = b.DocCount
+		if last, ok := b.Metrics[FieldEnrolledAt]; ok {
+			keys[i].LastUsedAt = time.UnixMilli(int64(last.Value)).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return keys, nil
+}
@@ -146,3 +146,55 @@ func TestSearchEnrollmentAPIKeyByPolicyIDWithInactiveIDs(t *testing.T) {
 		t.Fatalf("expected content does not match: %v", diff)
 	}
 }
+
+func TestListEnrollmentKeysUsage(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	// The Fleet system indices, so the agents are aggregated with the mapping of Elasticsearch.
+	keysIndex, bulker := ftesting.SetupCleanIndex(ctx, t, FleetEnrollmentAPIKeys)
+	agentsIndex := ftesting.CleanIndex(ctx, t, bulker, FleetAgents)
+
+	policyID := uuid.Must(uuid.NewV4()).String()
+	used, err := storeRandomEnrollmentAPIKey(ctx, bulker, keysIndex, policyID, true)
+	if err != nil {
+		t.Fatalf("unable to store enrollment key: %v", err)
+	}
+	unused, err := storeRandomEnrollmentAPIKey(ctx, bulker, keysIndex, policyID, true)
+	if err != nil {
+		t.Fatalf("unable to store enrollment key: %v", err)
+	}
+
+	enrolledAt := []string{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"}
+	for _, ts := range enrolledAt {
+		body, err := json.Marshal(model.Agent{
+			Active:             true,
+			PolicyID:           policyID,
+			EnrolledAt:         ts,
+			EnrollmentAPIKeyID: used.APIKeyID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bulker.Create(ctx, agentsIndex, xid.New().String(), body, bulk.WithRefresh()); err != nil {
+			t.Fatalf("unable to store agent: %v", err)
+		}
+	}
+
+	keys, err := listEnrollmentKeys(ctx, bulker, keysIndex, agentsIndex)
+	if err != nil {
+		t.Fatalf("unable to list enrollment keys: %v", err)
+	}
+	usage := make(map[string][2]interface{}, len(keys))
+	for _, k := range keys {
+		usage[k.APIKeyID] = [2]interface{}{k.AgentCount, k.LastUsedAt}
+	}
+	diff := cmp.Diff(map[string][2]interface{}{
+		used.APIKeyID:   {int64(2), "2024-01-02T00:00:00Z"},
+		unused.APIKeyID: {int64(0), ""},
+	}, usage)
+	if diff != "" {
+		t.Fatalf("unexpected usage: %v", diff)
+	}
+}
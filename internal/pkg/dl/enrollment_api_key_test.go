@@ -0,0 +1,68 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package dl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestListEnrollmentKeys(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	hit := func(id string, v interface{}) es.HitT {
+		src, err := json.Marshal(v)
+		require.NoError(t, err)
+		return es.HitT{ID: id, Source: src}
+	}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetEnrollmentAPIKeys, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit("1", model.EnrollmentAPIKey{APIKeyID: "key-1", Name: "linux", PolicyID: "policy-1", Active: true}),
+		hit("2", model.EnrollmentAPIKey{APIKeyID: "key-2", Name: "windows", PolicyID: "policy-2", Active: true}),
+		hit("3", model.EnrollmentAPIKey{APIKeyID: "key-3", Name: "unused", PolicyID: "policy-1", Active: true}),
+	}}}, nil)
+	bulker.On("Search", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{FieldEnrollmentAPIKeyID: {Buckets: []es.Bucket{{
+			Key:      "key-1",
+			DocCount: 12,
+			Metrics:  map[string]es.Aggregation{FieldEnrolledAt: {Value: 1704153600000}},
+		}, {
+			Key:      "key-2",
+			DocCount: 3,
+			Metrics:  map[string]es.Aggregation{FieldEnrolledAt: {Value: 1704067200000}},
+		}}}},
+	}, nil)
+
+	keys, err := ListEnrollmentKeys(ctx, bulker)
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+
+	usage := func(k EnrollmentKeyUsage) [3]interface{} {
+		return [3]interface{}{k.APIKeyID, k.AgentCount, k.LastUsedAt}
+	}
+	require.Equal(t, [3]interface{}{"key-1", int64(12), "2024-01-02T00:00:00Z"}, usage(keys[0]))
+	require.Equal(t, [3]interface{}{"key-2", int64(3), "2024-01-01T00:00:00Z"}, usage(keys[1]))
+	require.Equal(t, [3]interface{}{"key-3", int64(0), ""}, usage(keys[2]))
+	require.Equal(t, "unused", keys[2].Name)
+	bulker.AssertExpectations(t)
+}
+
+func TestPrepareEnrollmentKeyUsage(t *testing.T) {
+	require.JSONEq(t, `{"aggs":{"enrollment_api_key_id":{"aggs":{"enrolled_at":{"max":{"field":"enrolled_at"}}},"terms":{"field":"enrollment_api_key_id","size":10000}}},`+
+		`"runtime_mappings":{"enrollment_api_key_id":{"type":"keyword"}},"size":0}`,
+		string(queryEnrollmentKeyUsage))
+}
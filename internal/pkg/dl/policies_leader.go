@@ -5,9 +5,13 @@
 package dl
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,14 +19,48 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/rs/zerolog"
 )
 
+const (
+	aggLeaseAge = "lease_age"
+
+	// changedLeadersFetchSize is the default index.max_result_window of Elasticsearch.
+	changedLeadersFetchSize = 10000
+
+	exportLeadersPageSize = 1000
+	// exportLeadersKeepAlive is how long the point in time of an export is kept between two pages.
+	exportLeadersKeepAlive = "1m"
+)
+
+// ErrPolicyLeadershipNotAvailable is returned when a policy is led by another server whose lease has not expired.
+var ErrPolicyLeadershipNotAvailable = errors.New("policy leadership not available")
+
+// takeLeadershipIfAvailableScript takes the lease if it has no server, is held by the same server or
+// has expired. Otherwise the update is a noop.
+const takeLeadershipIfAvailableScript = `def l = ctx._source;
+if (l.server == null || l.server.id == params.server.id || l['@timestamp'] == null ||
+    ZonedDateTime.parse(l['@timestamp']).toInstant().toEpochMilli() < params.expired_before) {
+  l.server = params.server;
+  l['@timestamp'] = params.timestamp;
+} else {
+  ctx.op = 'noop';
+}`
+
 var (
 	tmplSearchPolicyLeaders     *dsl.Tmpl
 	initSearchPolicyLeadersOnce sync.Once
 )
 
+// LeaseAgeBucket is the number of policy leases whose age is within (From, To].
+// To is zero for the last, open-ended, bucket.
+type LeaseAgeBucket struct {
+	From  time.Duration
+	To    time.Duration
+	Count int64
+}
+
 func prepareSearchPolicyLeaders() (*dsl.Tmpl, error) {
 	tmpl := dsl.NewTmpl()
 	root := dsl.NewRoot()
@@ -49,6 +87,7 @@ func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, op
 	if err != nil {
 		return
 	}
+	logQuery(ctx, tmplSearchPolicyLeaders, o.indexName, map[string]interface{}{FieldID: ids})
 	res, err := bulker.Search(ctx, o.indexName, data)
 	if err != nil {
 		if errors.Is(err, es.ErrIndexNotFound) {
@@ -70,6 +109,102 @@ func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, op
 	return leaders, nil
 }
 
+// LeadersCheckpoint is the position GetLeadersChangedSince resumes from.
+// The zero value, on the first run, reads all the leaders.
+type LeadersCheckpoint struct {
+	// Time is the @timestamp, as sorted by Elasticsearch, of the last returned leader.
+	Time time.Time
+	// IDs are the policies of the returned leaders with the Time @timestamp.
+	// They are not returned again by the next call unless their lease changes.
+	IDs []string
+}
+
+// LeadersChange is a page of the leaders changed since a checkpoint.
+type LeadersChange struct {
+	// Leaders are keyed by policy ID.
+	Leaders map[string]model.PolicyLeader
+	// Checkpoint is passed to the next call to read the leaders changed after this page.
+	Checkpoint LeadersCheckpoint
+	// Truncated is set when the page is full: more leaders may have changed, the next call from Checkpoint returns them.
+	Truncated bool
+}
+
+func prepareLeadersChangedSince(since LeadersCheckpoint, size int) []byte {
+	root := dsl.NewRoot()
+	root.Size(uint64(size))
+	if since.Time.IsZero() {
+		root.Query().MatchAll()
+	} else {
+		ts := since.Time.UTC().Format(time.RFC3339Nano)
+		query := root.Query().Bool()
+		query.Filter().Range("@timestamp", dsl.WithRangeGTE(ts))
+		if len(since.IDs) > 0 {
+			// Skip the leaders already returned, unless their lease changed since.
+			seen := query.MustNot().Bool().Filter()
+			seen.Terms(FieldID, since.IDs, nil)
+			seen.Range("@timestamp", dsl.WithRangeLTE(ts))
+		}
+	}
+	root.Sort().SortOrder("@timestamp", dsl.SortAscend)
+	return root.MustMarshalJSON()
+}
+
+// GetLeadersChangedSince returns the leaders whose lease was taken, renewed or released at or after the checkpoint,
+// oldest first, along with the checkpoint of the next call.
+//
+// Leases changed within the same millisecond as the checkpoint are included, except the ones already returned.
+// At most changedLeadersFetchSize leaders are returned, Truncated is set when the page is full and more may follow.
+func GetLeadersChangedSince(ctx context.Context, bulker bulk.Bulk, since LeadersCheckpoint, opt ...Option) (LeadersChange, error) {
+	return getLeadersChangedSince(ctx, bulker, since, changedLeadersFetchSize, opt...)
+}
+
+func getLeadersChangedSince(ctx context.Context, bulker bulk.Bulk, since LeadersCheckpoint, size int, opt ...Option) (LeadersChange, error) {
+	o := newOption(FleetPoliciesLeader, opt...)
+	change := LeadersChange{
+		Leaders:    map[string]model.PolicyLeader{},
+		Checkpoint: LeadersCheckpoint{Time: since.Time, IDs: append([]string(nil), since.IDs...)},
+	}
+	res, err := bulker.Search(ctx, o.indexName, prepareLeadersChangedSince(since, size))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
+			return change, nil
+		}
+		return LeadersChange{}, err
+	}
+	change.Truncated = len(res.Hits) >= size
+
+	for _, hit := range res.Hits {
+		ts, err := hitSortTime(hit)
+		if err != nil {
+			return LeadersChange{}, err
+		}
+		var l model.PolicyLeader
+		if err := hit.Unmarshal(&l); err != nil {
+			return LeadersChange{}, err
+		}
+		change.Leaders[hit.ID] = l
+
+		if !ts.Equal(change.Checkpoint.Time) {
+			change.Checkpoint = LeadersCheckpoint{Time: ts}
+		}
+		change.Checkpoint.IDs = append(change.Checkpoint.IDs, hit.ID)
+	}
+	return change, nil
+}
+
+// hitSortTime returns the first sort value of a hit sorted by a date field.
+func hitSortTime(hit es.HitT) (time.Time, error) {
+	if len(hit.Sort) == 0 {
+		return time.Time{}, fmt.Errorf("hit %s has no sort value", hit.ID)
+	}
+	ms, ok := hit.Sort[0].(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("hit %s has an unexpected sort value %v", hit.ID, hit.Sort[0])
+	}
+	return time.UnixMilli(int64(ms)).UTC(), nil
+}
+
 // TakePolicyLeadership tries to take leadership of a policy
 func TakePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID, version string, opt ...Option) error {
 	o := newOption(FleetPoliciesLeader, opt...)
@@ -101,22 +236,81 @@ func TakePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serve
 		if err != nil {
 			return err
 		}
-		err = bulker.Update(ctx, o.indexName, policyID, data, bulk.WithRefresh())
+		err = bulker.Update(ctx, o.indexName, policyID, data, bulk.WithRefresh(), bulk.WithWaitForActiveShards(o.activeShards))
 	} else {
 		data, err = json.Marshal(&l)
 		if err != nil {
 			return err
 		}
-		_, err = bulker.Create(ctx, o.indexName, policyID, data, bulk.WithRefresh())
+		_, err = bulker.Create(ctx, o.indexName, policyID, data, bulk.WithRefresh(), bulk.WithWaitForActiveShards(o.activeShards))
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// TakePolicyLeadershipIfAvailable takes leadership of a policy only if there is no leader, the
+// current lease has not been renewed within leaderInterval or it is already held by serverID.
+//
+// The availability check is done by the update script so concurrent servers can not both take the lease.
+// ErrPolicyLeadershipNotAvailable is returned if the policy is led by another server.
+func TakePolicyLeadershipIfAvailable(ctx context.Context, bulker bulk.Bulk, policyID, serverID, version string, leaderInterval time.Duration, opt ...Option) error {
+	o := newOption(FleetPoliciesLeader, opt...)
+
+	now := time.Now().UTC()
+	l := model.PolicyLeader{
+		Server: &model.ServerMetadata{
+			ID:      serverID,
+			Version: version,
+		},
 	}
+	l.SetTime(now)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": takeLeadershipIfAvailableScript,
+			"params": map[string]interface{}{
+				"server":         l.Server,
+				"timestamp":      l.Timestamp,
+				"expired_before": now.Add(-leaderInterval).UnixMilli(),
+			},
+		},
+		"upsert": l,
+	})
+	if err != nil {
+		return err
+	}
+	if err = bulker.Update(ctx, o.indexName, policyID, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3), bulk.WithWaitForActiveShards(o.activeShards)); err != nil {
+		return err
+	}
+
+	// A noop update is not an error, confirm which server holds the lease.
+	data, err := bulker.Read(ctx, o.indexName, policyID, bulk.WithRefresh())
 	if err != nil {
 		return err
 	}
+	var current model.PolicyLeader
+	if err = json.Unmarshal(data, &current); err != nil {
+		return err
+	}
+	if current.Server == nil || current.Server.ID != serverID {
+		return ErrPolicyLeadershipNotAvailable
+	}
 	return nil
 }
 
 // ReleasePolicyLeadership releases leadership of a policy
 func ReleasePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID string, releaseInterval time.Duration, opt ...Option) error {
+	return ReleasePolicyLeadershipAt(ctx, bulker, policyID, serverID, releaseInterval, time.Now().UTC(), opt...)
+}
+
+// ReleasePolicyLeadershipAt performs a soft release of the leadership of a policy.
+//
+// The lease timestamp is set so that it expires at releaseAt, other servers can only take over
+// the policy after that time. This gives successors time to be ready during rolling restarts.
+func ReleasePolicyLeadershipAt(ctx context.Context, bulker bulk.Bulk, policyID, serverID string, releaseInterval time.Duration, releaseAt time.Time, opt ...Option) error {
 	o := newOption(FleetPoliciesLeader, opt...)
 	data, err := bulker.Read(ctx, o.indexName, policyID, bulk.WithRefresh())
 	if errors.Is(err, es.ErrElasticNotFound) {
@@ -135,7 +329,7 @@ func ReleasePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, se
 		// not leader anymore; nothing to do
 		return nil
 	}
-	released := time.Now().UTC().Add(-releaseInterval)
+	released := releaseAt.UTC().Add(-releaseInterval)
 	l.SetTime(released)
 	data, err = json.Marshal(&struct {
 		Doc model.PolicyLeader `json:"doc"`
@@ -145,10 +339,246 @@ func ReleasePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, se
 	if err != nil {
 		return err
 	}
-	err = bulker.Update(ctx, o.indexName, policyID, data, bulk.WithRefresh())
+	err = bulker.Update(ctx, o.indexName, policyID, data, bulk.WithRefresh(), bulk.WithWaitForActiveShards(o.activeShards))
 	if errors.Is(err, es.ErrElasticVersionConflict) {
 		// another leader took over; nothing to worry about
 		return nil
 	}
 	return err
 }
+
+func prepareLeaseAgeHistogram(bounds []time.Duration) []byte {
+	ranges := make([]dsl.DateRange, 0, len(bounds)+1)
+	var from time.Duration
+	for i := 0; i <= len(bounds); i++ {
+		// The age range (from, to] translates to timestamps in [now-to, now-from), date ranges exclude their upper bound.
+		r := dsl.DateRange{Key: strconv.Itoa(i)}
+		if i < len(bounds) {
+			r.From = dateMathAgo(bounds[i])
+		}
+		if i > 0 {
+			r.To = dateMathAgo(from)
+		}
+		ranges = append(ranges, r)
+		if i < len(bounds) {
+			from = bounds[i]
+		}
+	}
+
+	root := dsl.NewRoot()
+	root.Size(0)
+	root.Aggs().Agg(aggLeaseAge).DateRange("@timestamp", ranges)
+	return root.MustMarshalJSON()
+}
+
+func dateMathAgo(d time.Duration) string {
+	return fmt.Sprintf("now-%ds", int64(d/time.Second))
+}
+
+// LeaseAgeHistogram returns the distribution of the age of all policy leases.
+// bounds are the ascending, inclusive, upper bounds of the buckets, a last bucket holds all the leases older than the last bound.
+// Bounds are truncated to the second.
+func LeaseAgeHistogram(ctx context.Context, bulker bulk.Bulk, bounds []time.Duration, opt ...Option) ([]LeaseAgeBucket, error) {
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return nil, fmt.Errorf("lease age bounds must be ascending")
+		}
+	}
+
+	buckets := make([]LeaseAgeBucket, len(bounds)+1)
+	for i := range buckets {
+		if i > 0 {
+			buckets[i].From = bounds[i-1]
+		}
+		if i < len(bounds) {
+			buckets[i].To = bounds[i]
+		}
+	}
+
+	o := newOption(FleetPoliciesLeader, opt...)
+	res, err := bulker.Search(ctx, o.indexName, prepareLeaseAgeHistogram(bounds))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
+			return buckets, nil
+		}
+		return nil, err
+	}
+
+	agg, ok := res.Aggregations[aggLeaseAge]
+	if !ok {
+		return nil, ErrMissingAggregations
+	}
+	for _, b := range agg.Buckets {
+		i, err := strconv.Atoi(b.Key)
+		if err != nil || i < 0 || i >= len(buckets) {
+			return nil, fmt.Errorf("unexpected lease age bucket %q", b.Key)
+		}
+		buckets[i].Count = b.DocCount
+	}
+	return buckets, nil
+}
+
+// IndexStats is the number of documents and the store size of the primary shards of an index.
+type IndexStats struct {
+	DocCount       int64
+	StoreSizeBytes int64
+}
+
+// PolicyLeadersIndexStats returns the number of documents and the store size of the policy leaders index.
+// Stats are zero if the index does not exist yet.
+func PolicyLeadersIndexStats(ctx context.Context, bulker bulk.Bulk, opt ...Option) (IndexStats, error) {
+	o := newOption(FleetPoliciesLeader, opt...)
+	client := bulker.Client()
+	res, err := client.Indices.Stats(
+		client.Indices.Stats.WithIndex(o.indexName),
+		client.Indices.Stats.WithMetric("docs", "store"),
+		client.Indices.Stats.WithContext(ctx))
+	if err != nil {
+		return IndexStats{}, fmt.Errorf("policy leaders index stats: %w", err)
+	}
+	defer res.Body.Close()
+
+	var esres es.IndexStatsResponse
+	if err := json.NewDecoder(res.Body).Decode(&esres); err != nil {
+		return IndexStats{}, fmt.Errorf("policy leaders index stats: %w", err)
+	}
+	if res.IsError() {
+		err := es.TranslateError(res.StatusCode, esres.Error)
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return IndexStats{}, nil
+		}
+		return IndexStats{}, err
+	}
+	return IndexStats{
+		DocCount:       esres.All.Primaries.Docs.Count,
+		StoreSizeBytes: esres.All.Primaries.Store.SizeInBytes,
+	}, nil
+}
+
+func prepareExportLeaders(size uint64, pitID string, searchAfter []interface{}) []byte {
+	root := dsl.NewRoot()
+	root.Size(size)
+	root.Query().MatchAll()
+	root.Param("pit", map[string]interface{}{"id": pitID, "keep_alive": exportLeadersKeepAlive})
+	root.Sort().SortOrder("_shard_doc", dsl.SortAscend)
+	if len(searchAfter) > 0 {
+		root.Param("search_after", searchAfter)
+	}
+	return root.MustMarshalJSON()
+}
+
+// exportedLeader is a line of the leaders export.
+type exportedLeader struct {
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// ExportLeaders writes all the policy leader documents to w as newline delimited JSON, one {"_id", "_source"} object per line.
+// Documents are read a page at a time from a point in time of the index so the export does not hold the whole index
+// in memory; each leader is written once, with its state when the export started.
+// Nothing is written if the index does not exist yet.
+func ExportLeaders(ctx context.Context, bulker bulk.Bulk, w io.Writer, opt ...Option) error {
+	o := newOption(FleetPoliciesLeader, opt...)
+	enc := json.NewEncoder(w)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	client := bulker.Client()
+	pitID, err := openPointInTime(ctx, client, o.indexName, exportLeadersKeepAlive)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
+			return nil
+		}
+		return fmt.Errorf("export leaders: %w", err)
+	}
+	defer func() {
+		if err := closePointInTime(ctx, client, pitID); err != nil {
+			zerolog.Ctx(ctx).Debug().Err(err).Str("index", o.indexName).Msg("unable to close the point in time of the leaders export")
+		}
+	}()
+
+	var searchAfter []interface{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res, err := searchPointInTime(ctx, client, prepareExportLeaders(exportLeadersPageSize, pitID, searchAfter))
+		if err != nil {
+			return fmt.Errorf("export leaders: %w", err)
+		}
+		if res.PitID != "" {
+			pitID = res.PitID
+		}
+		hits := res.Hits.Hits
+		for _, hit := range hits {
+			if err := enc.Encode(exportedLeader{ID: hit.ID, Source: hit.Source}); err != nil {
+				return fmt.Errorf("export leaders: %w", err)
+			}
+		}
+		if len(hits) < exportLeadersPageSize {
+			return nil
+		}
+		searchAfter = hits[len(hits)-1].Sort
+		if len(searchAfter) == 0 {
+			return errors.New("export leaders: missing sort values on page hit")
+		}
+	}
+}
+
+// openPointInTime opens a point in time of index and returns its ID.
+func openPointInTime(ctx context.Context, client *elasticsearch.Client, index, keepAlive string) (string, error) {
+	res, err := client.OpenPointInTime([]string{index}, keepAlive, client.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var esres es.PointInTimeResponse
+	if res.IsError() {
+		_ = json.NewDecoder(res.Body).Decode(&esres)
+		return "", es.TranslateError(res.StatusCode, esres.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&esres); err != nil {
+		return "", err
+	}
+	return esres.ID, nil
+}
+
+// searchPointInTime runs a search on the point in time given in body.
+func searchPointInTime(ctx context.Context, client *elasticsearch.Client, body []byte) (*es.Response, error) {
+	res, err := client.Search(client.Search.WithBody(bytes.NewReader(body)), client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var esres es.Response
+	if res.IsError() {
+		_ = json.NewDecoder(res.Body).Decode(&esres)
+		return nil, es.TranslateError(res.StatusCode, esres.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&esres); err != nil {
+		return nil, err
+	}
+	return &esres, nil
+}
+
+// closePointInTime releases the point in time before its keep alive expires.
+func closePointInTime(ctx context.Context, client *elasticsearch.Client, pitID string) error {
+	body, err := json.Marshal(map[string]string{"id": pitID})
+	if err != nil {
+		return err
+	}
+	res, err := client.ClosePointInTime(client.ClosePointInTime.WithBody(bytes.NewReader(body)), client.ClosePointInTime.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return es.TranslateError(res.StatusCode, nil)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ package dl
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -56,6 +57,52 @@ func TestSearchPolicyLeaders(t *testing.T) {
 	}, ftesting.RetryCount(3))
 }
 
+func TestGetLeadersChangedSince(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPoliciesLeader)
+
+	serverID := uuid.Must(uuid.NewV4()).String()
+	stale := uuid.Must(uuid.NewV4()).String()
+	if err := TakePolicyLeadership(ctx, bulker, stale, serverID, testVer, WithIndexName(index)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	checkpoint := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	changed := uuid.Must(uuid.NewV4()).String()
+	if err := TakePolicyLeadership(ctx, bulker, changed, serverID, testVer, WithIndexName(index)); err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := GetLeadersChangedSince(ctx, bulker, LeadersCheckpoint{Time: checkpoint}, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := change.Leaders[changed]; !ok || len(change.Leaders) != 1 {
+		t.Fatalf("expected only the leader changed after the checkpoint, got %v", change.Leaders)
+	}
+
+	// Resuming from the returned checkpoint does not return the same leader again.
+	next, err := GetLeadersChangedSince(ctx, bulker, change.Checkpoint, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Leaders) != 0 {
+		t.Fatalf("expected no leader changed after the returned checkpoint, got %v", next.Leaders)
+	}
+
+	change, err = GetLeadersChangedSince(ctx, bulker, LeadersCheckpoint{}, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Leaders) != 2 {
+		t.Fatalf("expected all the leaders without a checkpoint, got %v", change.Leaders)
+	}
+}
+
 func TestTakePolicyLeadership(t *testing.T) {
 	ctx, cn := context.WithCancel(context.Background())
 	defer cn()
@@ -92,6 +139,53 @@ func TestTakePolicyLeadership(t *testing.T) {
 	}
 }
 
+func TestTakePolicyLeadershipIfAvailable(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPoliciesLeader)
+
+	serverID := uuid.Must(uuid.NewV4()).String()
+	otherServerID := uuid.Must(uuid.NewV4()).String()
+	policyID := uuid.Must(uuid.NewV4()).String()
+
+	// no leader; takes the lease
+	err := TakePolicyLeadershipIfAvailable(ctx, bulker, policyID, otherServerID, testVer, 30*time.Second, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh lease held by another server; declines
+	err = TakePolicyLeadershipIfAvailable(ctx, bulker, policyID, serverID, testVer, 30*time.Second, WithIndexName(index))
+	if !errors.Is(err, ErrPolicyLeadershipNotAvailable) {
+		t.Fatalf("expected ErrPolicyLeadershipNotAvailable, got %v", err)
+	}
+
+	// expired lease; takes over
+	err = ReleasePolicyLeadership(ctx, bulker, policyID, otherServerID, 30*time.Second, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = TakePolicyLeadershipIfAvailable(ctx, bulker, policyID, serverID, testVer, 30*time.Second, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := bulker.Read(ctx, index, policyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leader model.PolicyLeader
+	err = json.Unmarshal(data, &leader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader.Server.ID != serverID {
+		t.Fatal("server.id should match")
+	}
+}
+
 func TestReleasePolicyLeadership(t *testing.T) {
 	ctx, cn := context.WithCancel(context.Background())
 	defer cn()
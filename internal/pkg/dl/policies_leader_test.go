@@ -0,0 +1,424 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package dl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleasePolicyLeadershipAt(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	const (
+		policyID       = "policy-1"
+		serverID       = "server-1"
+		leaderInterval = 30 * time.Second
+	)
+
+	current := model.PolicyLeader{Server: &model.ServerMetadata{ID: serverID, Version: "8.12.0"}}
+	current.SetTime(time.Now().UTC())
+	data, err := json.Marshal(&current)
+	require.NoError(t, err)
+
+	var released model.PolicyLeader
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Read", mock.Anything, FleetPoliciesLeader, policyID, mock.Anything).Return(data, nil).Once()
+	bulker.On("Update", mock.Anything, FleetPoliciesLeader, policyID, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc model.PolicyLeader `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		released = body.Doc
+	}).Return(nil).Once()
+
+	releaseAt := time.Now().UTC().Add(time.Minute)
+	err = ReleasePolicyLeadershipAt(ctx, bulker, policyID, serverID, leaderInterval, releaseAt)
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+
+	expired, err := released.Expired(releaseAt.Add(-time.Second), leaderInterval)
+	require.NoError(t, err)
+	assert.False(t, expired, "lease should not be claimable before the scheduled release")
+
+	expired, err = released.Expired(releaseAt.Add(time.Second), leaderInterval)
+	require.NoError(t, err)
+	assert.True(t, expired, "lease should be claimable after the scheduled release")
+}
+
+func TestPrepareLeaseAgeHistogram(t *testing.T) {
+	query := prepareLeaseAgeHistogram([]time.Duration{10 * time.Second, time.Minute})
+	assert.JSONEq(t, `{
+		"size": 0,
+		"aggs": {"lease_age": {"date_range": {"field": "@timestamp", "ranges": [
+			{"key": "0", "from": "now-10s"},
+			{"key": "1", "from": "now-60s", "to": "now-10s"},
+			{"key": "2", "to": "now-60s"}
+		]}}}
+	}`, string(query))
+}
+
+func TestLeaseAgeHistogram(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bounds := []time.Duration{10 * time.Second, time.Minute}
+
+	var resp es.Response
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"hits": {"hits": []},
+		"aggregations": {"lease_age": {"buckets": [
+			{"key": "2", "to": 1700000000000, "to_as_string": "2023-11-14T22:13:20.000Z", "doc_count": 1},
+			{"key": "1", "from": 1700000000000, "to": 1700000050000, "doc_count": 2},
+			{"key": "0", "from": 1700000050000, "doc_count": 7}
+		]}}
+	}`), &resp))
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetPoliciesLeader, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: resp.Hits, Aggregations: resp.Aggregations}, nil).Once()
+
+	buckets, err := LeaseAgeHistogram(ctx, bulker, bounds)
+	require.NoError(t, err)
+	assert.Equal(t, []LeaseAgeBucket{
+		{From: 0, To: 10 * time.Second, Count: 7},
+		{From: 10 * time.Second, To: time.Minute, Count: 2},
+		{From: time.Minute, To: 0, Count: 1},
+	}, buckets)
+	bulker.AssertExpectations(t)
+
+	_, err = LeaseAgeHistogram(ctx, bulker, []time.Duration{time.Minute, time.Second})
+	assert.Error(t, err, "bounds must be ascending")
+}
+
+// leadersBulk answers the changed leaders query from an in memory set of leaders.
+type leadersBulk struct {
+	*ftesting.MockBulk
+	leaders map[string]model.PolicyLeader
+}
+
+func (b *leadersBulk) Search(_ context.Context, _ string, body []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	return searchLeaders(b.leaders, body)
+}
+
+func TestGetLeadersChangedSince(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	checkpoint := time.Now().UTC().Truncate(time.Millisecond)
+	leader := func(d time.Duration) model.PolicyLeader {
+		l := model.PolicyLeader{Server: &model.ServerMetadata{ID: "server-1"}}
+		l.SetTime(checkpoint.Add(d))
+		return l
+	}
+	bulker := &leadersBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		leaders: map[string]model.PolicyLeader{
+			"stale":   leader(-time.Minute),
+			"renewed": leader(time.Second),
+			"taken":   leader(time.Minute),
+		},
+	}
+
+	change, err := GetLeadersChangedSince(ctx, bulker, LeadersCheckpoint{Time: checkpoint})
+	require.NoError(t, err)
+	assert.Len(t, change.Leaders, 2)
+	assert.Contains(t, change.Leaders, "renewed")
+	assert.Contains(t, change.Leaders, "taken")
+	assert.False(t, change.Truncated)
+	assert.Equal(t, LeadersCheckpoint{Time: checkpoint.Add(time.Minute), IDs: []string{"taken"}}, change.Checkpoint)
+
+	// The first run has no checkpoint and reads all the leaders.
+	change, err = GetLeadersChangedSince(ctx, bulker, LeadersCheckpoint{})
+	require.NoError(t, err)
+	assert.Len(t, change.Leaders, 3)
+	assert.JSONEq(t, `{"size": 10000, "query": {"match_all": {}}, "sort": ["@timestamp"]}`, string(prepareLeadersChangedSince(LeadersCheckpoint{}, changedLeadersFetchSize)))
+	assert.JSONEq(t, `{"size": 10, "sort": ["@timestamp"], "query": {"bool": {
+		"filter": [{"range": {"@timestamp": {"gte": "2024-01-02T00:00:00.001Z"}}}],
+		"must_not": [{"bool": {"filter": [{"terms": {"_id": ["a", "b"]}}, {"range": {"@timestamp": {"lte": "2024-01-02T00:00:00.001Z"}}}]}}]
+	}}}`, string(prepareLeadersChangedSince(LeadersCheckpoint{
+		Time: time.Date(2024, 1, 2, 0, 0, 0, int(time.Millisecond), time.UTC),
+		IDs:  []string{"a", "b"},
+	}, 10)))
+
+	// Nothing changed since the last checkpoint.
+	change, err = GetLeadersChangedSince(ctx, bulker, change.Checkpoint)
+	require.NoError(t, err)
+	assert.Empty(t, change.Leaders)
+	assert.Equal(t, LeadersCheckpoint{Time: checkpoint.Add(time.Minute), IDs: []string{"taken"}}, change.Checkpoint)
+}
+
+func TestGetLeadersChangedSinceTruncated(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	leader := func(ts time.Time) model.PolicyLeader {
+		l := model.PolicyLeader{Server: &model.ServerMetadata{ID: "server-1"}}
+		l.SetTime(ts)
+		return l
+	}
+	// Three leases share the same instant and straddle the page boundary.
+	bulker := &leadersBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		leaders: map[string]model.PolicyLeader{
+			"a": leader(now),
+			"b": leader(now.Add(time.Millisecond)),
+			"c": leader(now.Add(time.Millisecond)),
+			"d": leader(now.Add(time.Millisecond)),
+			"e": leader(now.Add(time.Second)),
+		},
+	}
+
+	var checkpoint LeadersCheckpoint
+	var pages [][]string
+	for i := 0; i < 10; i++ {
+		change, err := getLeadersChangedSince(ctx, bulker, checkpoint, 3)
+		require.NoError(t, err)
+		var ids []string
+		for id := range change.Leaders {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		pages = append(pages, ids)
+		checkpoint = change.Checkpoint
+		if !change.Truncated {
+			break
+		}
+	}
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"d", "e"}}, pages)
+	assert.Equal(t, LeadersCheckpoint{Time: now.Add(time.Second), IDs: []string{"e"}}, checkpoint)
+
+	// A lease already returned is returned again once it changes.
+	bulker.leaders["e"] = leader(now.Add(2 * time.Second))
+	change, err := getLeadersChangedSince(ctx, bulker, checkpoint, 3)
+	require.NoError(t, err)
+	assert.Contains(t, change.Leaders, "e")
+	checkpoint = change.Checkpoint
+	assert.Equal(t, LeadersCheckpoint{Time: now.Add(2 * time.Second), IDs: []string{"e"}}, checkpoint)
+}
+
+func TestTakePolicyLeadershipIfAvailable(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	const (
+		policyID       = "policy-1"
+		serverID       = "server-1"
+		leaderInterval = 30 * time.Second
+	)
+
+	leaderDoc := func(t *testing.T, id string, ts time.Time) []byte {
+		l := model.PolicyLeader{Server: &model.ServerMetadata{ID: id, Version: "8.12.0"}}
+		l.SetTime(ts)
+		data, err := json.Marshal(&l)
+		require.NoError(t, err)
+		return data
+	}
+	// matchScript validates the conditional update sent to Elasticsearch.
+	matchScript := func(body []byte) bool {
+		var req struct {
+			Script struct {
+				Params struct {
+					Server        model.ServerMetadata `json:"server"`
+					ExpiredBefore int64                `json:"expired_before"`
+				} `json:"params"`
+			} `json:"script"`
+			Upsert model.PolicyLeader `json:"upsert"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return false
+		}
+		expiredBefore := time.Now().Add(-leaderInterval).UnixMilli()
+		return req.Script.Params.Server.ID == serverID &&
+			req.Upsert.Server != nil && req.Upsert.Server.ID == serverID &&
+			req.Script.Params.ExpiredBefore <= expiredBefore && req.Script.Params.ExpiredBefore > expiredBefore-5000
+	}
+
+	t.Run("declines a fresh lease", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, FleetPoliciesLeader, policyID, mock.MatchedBy(matchScript), mock.Anything).Return(nil).Once()
+		// the script was a noop, the other server still holds the lease
+		bulker.On("Read", mock.Anything, FleetPoliciesLeader, policyID, mock.Anything).Return(leaderDoc(t, "server-2", time.Now()), nil).Once()
+
+		err := TakePolicyLeadershipIfAvailable(ctx, bulker, policyID, serverID, "8.12.0", leaderInterval)
+		assert.ErrorIs(t, err, ErrPolicyLeadershipNotAvailable)
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("takes an expired lease", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, FleetPoliciesLeader, policyID, mock.MatchedBy(matchScript), mock.Anything).Return(nil).Once()
+		bulker.On("Read", mock.Anything, FleetPoliciesLeader, policyID, mock.Anything).Return(leaderDoc(t, serverID, time.Now()), nil).Once()
+
+		err := TakePolicyLeadershipIfAvailable(ctx, bulker, policyID, serverID, "8.12.0", leaderInterval)
+		assert.NoError(t, err)
+		bulker.AssertExpectations(t)
+	})
+}
+
+// pointInTimeLeaders serves an in memory list of leaders as Elasticsearch serves a point in time of the index:
+// searches page through the leaders as they were when the point in time was opened, ordered by shard and document.
+type pointInTimeLeaders struct {
+	t        *testing.T
+	leaders  []model.PolicyLeader
+	snapshot []model.PolicyLeader
+	// renew is called after the first page, while the export runs.
+	renew func(leaders []model.PolicyLeader)
+
+	pages  int
+	closed string
+}
+
+func (p *pointInTimeLeaders) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := `{}`
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/"+FleetPoliciesLeader+"/_pit":
+		assert.Equal(p.t, exportLeadersKeepAlive, r.URL.Query().Get("keep_alive"))
+		p.snapshot = append([]model.PolicyLeader{}, p.leaders...)
+		body = `{"id":"pit-0"}`
+	case r.Method == http.MethodPost && r.URL.Path == "/_search":
+		var query struct {
+			Size int `json:"size"`
+			Pit  struct {
+				ID string `json:"id"`
+			} `json:"pit"`
+			SearchAfter []int `json:"search_after"`
+		}
+		require.NoError(p.t, json.NewDecoder(r.Body).Decode(&query))
+		// Each response carries the point in time ID to use for the next page.
+		assert.Equal(p.t, fmt.Sprintf("pit-%d", p.pages), query.Pit.ID)
+		p.pages++
+		var res es.Response
+		res.PitID = fmt.Sprintf("pit-%d", p.pages)
+		for doc, l := range p.snapshot {
+			if len(query.SearchAfter) > 0 && doc <= query.SearchAfter[0] {
+				continue
+			}
+			if len(res.Hits.Hits) == query.Size {
+				break
+			}
+			src, err := json.Marshal(l)
+			require.NoError(p.t, err)
+			res.Hits.Hits = append(res.Hits.Hits, es.HitT{ID: l.Id, Source: src, Sort: []interface{}{doc}})
+		}
+		if p.pages == 1 {
+			p.renew(p.leaders)
+		}
+		b, err := json.Marshal(res)
+		require.NoError(p.t, err)
+		body = string(b)
+	case r.Method == http.MethodDelete && r.URL.Path == "/_pit":
+		var req struct {
+			ID string `json:"id"`
+		}
+		require.NoError(p.t, json.NewDecoder(r.Body).Decode(&req))
+		p.closed = req.ID
+		body = `{"succeeded":true,"num_freed":1}`
+	default:
+		p.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+	}, nil
+}
+
+func TestExportLeaders(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	const count = 2*exportLeadersPageSize + 10
+
+	started := time.Now().UTC().Add(-time.Minute)
+	pit := &pointInTimeLeaders{t: t}
+	for i := 0; i < count; i++ {
+		l := model.PolicyLeader{Server: &model.ServerMetadata{ID: "server-1"}}
+		l.Id = fmt.Sprintf("policy-%d", i)
+		l.SetTime(started)
+		pit.leaders = append(pit.leaders, l)
+	}
+	// A lease is renewed while the export runs, the renewal is not exported.
+	pit.renew = func(leaders []model.PolicyLeader) {
+		leaders[0].SetTime(time.Now().UTC())
+		leaders[0].Server = &model.ServerMetadata{ID: "server-2"}
+	}
+	client, mocktrans := esutil.MockESClient(t)
+	mocktrans.RoundTripFn = pit.RoundTrip
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Client").Return(client)
+
+	var buf bytes.Buffer
+	err := ExportLeaders(ctx, bulker, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, pit.pages)
+	assert.Equal(t, "pit-3", pit.closed)
+
+	written := make(map[string]int, count)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line exportedLeader
+		require.NoError(t, dec.Decode(&line))
+		var l model.PolicyLeader
+		require.NoError(t, json.Unmarshal(line.Source, &l))
+		assert.Equal(t, "server-1", l.Server.ID)
+		written[line.ID]++
+	}
+	require.Len(t, written, count)
+	for id, n := range written {
+		require.Equal(t, 1, n, "leader %s", id)
+	}
+
+	assert.JSONEq(t, `{"size": 10, "query": {"match_all": {}}, "pit": {"id": "pit-1", "keep_alive": "1m"}, "sort": ["_shard_doc"], "search_after": [42]}`,
+		string(prepareExportLeaders(10, "pit-1", []interface{}{42})))
+}
+
+func TestExportLeadersCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	cancel()
+
+	var buf bytes.Buffer
+	err := ExportLeaders(ctx, ftesting.NewMockBulk(), &buf)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, buf.Len())
+}
+
+func TestTakePolicyLeadershipWaitForActiveShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	defer cancel()
+
+	client, mocktrans := esutil.MockESClient(t)
+	var shards string
+	mocktrans.RoundTripFn = func(r *http.Request) (*http.Response, error) {
+		body := `{"docs":[{"_index":".fleet-policies-leader","_id":"policy-1","found":false}]}`
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			shards = r.URL.Query().Get("wait_for_active_shards")
+			body = `{"items":[{"create":{"_index":".fleet-policies-leader","_id":"policy-1","status":201,"result":"created"}}]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	bulker := bulk.NewBulker(client, nil, bulk.WithFlushThresholdCount(1))
+	go func() {
+		_ = bulker.Run(ctx)
+	}()
+
+	err := TakePolicyLeadership(ctx, bulker, "policy-1", "server-1", "8.0.0", WithWaitForActiveShards(2))
+	require.NoError(t, err)
+	assert.Equal(t, "2", shards)
+}
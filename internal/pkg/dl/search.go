@@ -6,17 +6,62 @@ package dl
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+
+	"github.com/rs/zerolog"
 )
 
+const redactedValue = "[redacted]"
+
+// logQueries gates the debug logging of rendered query bodies.
+var logQueries atomic.Bool
+
+// sensitiveParams are the template parameters that are redacted when a query body is logged.
+var sensitiveParams = map[string]struct{}{
+	FieldPolicyOutputAPIKey: {},
+}
+
+// SetLogQueries enables or disables debug logging of the rendered query bodies of dl searches.
+func SetLogQueries(enabled bool) {
+	logQueries.Store(enabled)
+}
+
+// logQuery logs the query rendered from tmpl with sensitive parameters redacted.
+// It is a no-op unless enabled with SetLogQueries and the logger is at debug level.
+func logQuery(ctx context.Context, tmpl *dsl.Tmpl, index string, params map[string]interface{}) {
+	if !logQueries.Load() {
+		return
+	}
+	ev := zerolog.Ctx(ctx).Debug()
+	if !ev.Enabled() {
+		return
+	}
+
+	redacted := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if _, ok := sensitiveParams[k]; ok {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	query, err := tmpl.Render(redacted)
+	if err != nil {
+		ev.Discard()
+		return
+	}
+	ev.Str("index", index).RawJSON("query", query).Msg("dl search query")
+}
+
 func Search(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}, opts ...bulk.Opt) (*es.HitsT, error) {
 	query, err := tmpl.Render(params)
 	if err != nil {
 		return nil, err
 	}
+	logQuery(ctx, tmpl, index, params)
 
 	res, err := bulker.Search(ctx, index, query, opts...)
 	if err != nil {
@@ -31,6 +76,8 @@ func SearchWithOneParam(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, i
 	if err != nil {
 		return nil, err
 	}
+	logQuery(ctx, tmpl, index, map[string]interface{}{name: v})
+
 	res, err := bulker.Search(ctx, index, query)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,69 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package dl
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLogQueries(t *testing.T) {
+	t.Cleanup(func() { SetLogQueries(false) })
+
+	newCtx := func(buf *bytes.Buffer, lvl zerolog.Level) context.Context {
+		log := zerolog.New(buf).Level(lvl)
+		return log.WithContext(context.Background())
+	}
+	newBulker := func() *ftesting.MockBulk {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		return bulker
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		SetLogQueries(false)
+		var buf bytes.Buffer
+		_, err := SearchPolicyLeaders(newCtx(&buf, zerolog.DebugLevel), newBulker(), []string{"policy-1"})
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("enabled below debug level", func(t *testing.T) {
+		SetLogQueries(true)
+		var buf bytes.Buffer
+		_, err := SearchPolicyLeaders(newCtx(&buf, zerolog.InfoLevel), newBulker(), []string{"policy-1"})
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		SetLogQueries(true)
+		var buf bytes.Buffer
+		_, err := SearchPolicyLeaders(newCtx(&buf, zerolog.DebugLevel), newBulker(), []string{"policy-1", "policy-2"})
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), `"level":"debug"`)
+		assert.Contains(t, buf.String(), `"index":".fleet-policies-leader"`)
+		assert.Contains(t, buf.String(), `"query":{"query":{"terms":{"_id":["policy-1","policy-2"]}}}`)
+	})
+
+	t.Run("sensitive values are redacted", func(t *testing.T) {
+		SetLogQueries(true)
+		var buf bytes.Buffer
+		tmpl := prepareFindByField(FieldPolicyOutputAPIKey, nil)
+		_, err := SearchWithOneParam(newCtx(&buf, zerolog.DebugLevel), newBulker(), tmpl, FleetEnrollmentAPIKeys, FieldPolicyOutputAPIKey, "secret-value")
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), redactedValue)
+		assert.NotContains(t, buf.String(), "secret-value")
+	})
+}
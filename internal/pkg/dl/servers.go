@@ -57,3 +57,16 @@ func EnsureServer(ctx context.Context, bulker bulk.Bulk, version string, agent m
 	}
 	return bulker.Update(ctx, o.indexName, agent.ID, data, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
 }
+
+// GetServer returns the server document of the Fleet Server with the given agent ID.
+// es.ErrElasticNotFound is returned if the server never ran.
+func GetServer(ctx context.Context, bulker bulk.Bulk, serverID string, opts ...Option) (model.Server, error) {
+	var server model.Server
+	o := newOption(FleetServers, opts...)
+	data, err := bulker.Read(ctx, o.indexName, serverID)
+	if err != nil {
+		return server, err
+	}
+	err = json.Unmarshal(data, &server)
+	return server, err
+}
@@ -15,3 +15,28 @@ func (n *Node) Agg(name string) *Node {
 func (n *Node) Max() *Node {
 	return n.findOrCreateChildByName(kKeywordMax)
 }
+
+// Filters returns the named filters of a filters aggregation, the query of each bucket is added with Agg(name).
+// Buckets are returned as a list, keyed by the name of their filter.
+func (n *Node) Filters() *Node {
+	childNode := n.findOrCreateChildByName(kKeywordFilters)
+	childNode.Param(kKeywordKeyed, false)
+	return childNode.findOrCreateChildByName(kKeywordFilters)
+}
+
+// DateRange is a single range of a date_range aggregation.
+// From and To accept date math such as "now-30s"; an empty value leaves that end of the range open.
+type DateRange struct {
+	Key  string `json:"key,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func (n *Node) DateRange(field string, ranges []DateRange) *Node {
+	childNode := n.findOrCreateChildByName(kKeywordDateRange)
+	childNode.nodeMap = nodeMapT{
+		kKeywordField:  &Node{leaf: field},
+		kKeywordRanges: &Node{leaf: ranges},
+	}
+	return childNode
+}
@@ -5,29 +5,34 @@
 package dsl
 
 const (
-	kKeywordAggs        = "aggs"
-	kKeywordBool        = "bool"
-	kKeywordBoost       = "boost"
-	kKeywordExcludes    = "excludes"
-	kKeywordExists      = "exists"
-	kKeywordField       = "field"
-	kKeywordFilter      = "filter"
-	kKeywordGreaterThan = "gt"
-	kKeywordIncludes    = "includes"
-	kKeywordLessThanEq  = "lte"
-	kKeywordMatchAll    = "match_all"
-	kKeywordMatchNone   = "match_none"
-	kKeywordMax         = "max"
-	kKeywordMust        = "must"
-	kKeywordMustNot     = "must_not"
-	kKeywordNULL        = "null"
-	kKeywordParams      = "params"
-	kKeywordQuery       = "query"
-	kKeywordScript      = "script"
-	kKeywordSize        = "size"
-	kKeywordSort        = "sort"
-	kKeywordSource      = "_source"
-	kKeywordTerm        = "term"
-	kKeywordTerms       = "terms"
-	kKeywordTopHits     = "top_hits"
+	kKeywordAggs          = "aggs"
+	kKeywordBool          = "bool"
+	kKeywordBoost         = "boost"
+	kKeywordDateRange     = "date_range"
+	kKeywordExcludes      = "excludes"
+	kKeywordExists        = "exists"
+	kKeywordField         = "field"
+	kKeywordFilter        = "filter"
+	kKeywordFilters       = "filters"
+	kKeywordGreaterThan   = "gt"
+	kKeywordGreaterThanEq = "gte"
+	kKeywordIncludes      = "includes"
+	kKeywordKeyed         = "keyed"
+	kKeywordLessThanEq    = "lte"
+	kKeywordMatchAll      = "match_all"
+	kKeywordMatchNone     = "match_none"
+	kKeywordMax           = "max"
+	kKeywordMust          = "must"
+	kKeywordMustNot       = "must_not"
+	kKeywordNULL          = "null"
+	kKeywordParams        = "params"
+	kKeywordQuery         = "query"
+	kKeywordRanges        = "ranges"
+	kKeywordScript        = "script"
+	kKeywordSize          = "size"
+	kKeywordSort          = "sort"
+	kKeywordSource        = "_source"
+	kKeywordTerm          = "term"
+	kKeywordTerms         = "terms"
+	kKeywordTopHits       = "top_hits"
 )
@@ -8,7 +8,11 @@ func (n *Node) Query() *Node {
 	return n.findOrCreateChildByName(kKeywordQuery)
 }
 
+// Bool returns the bool query of n, or appends a new bool query if n is a list of clauses such as must_not.
 func (n *Node) Bool() *Node {
+	if n.nodeList != nil {
+		return n.appendOrSetChildNode(kKeywordBool)
+	}
 	return n.findOrCreateChildByName(kKeywordBool)
 }
 
@@ -12,6 +12,12 @@ func WithRangeGT(v interface{}) RangeOpt {
 	}
 }
 
+func WithRangeGTE(v interface{}) RangeOpt {
+	return func(nmap nodeMapT) {
+		nmap[kKeywordGreaterThanEq] = &Node{leaf: v}
+	}
+}
+
 func WithRangeLTE(v interface{}) RangeOpt {
 	return func(nmap nodeMapT) {
 		nmap[kKeywordLessThanEq] = &Node{leaf: v}
@@ -0,0 +1,54 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package es
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithUserAgent(t *testing.T) {
+	bi := build.Info{
+		Version:   "8.12.0",
+		Commit:    "abc123",
+		BuildTime: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	userAgents := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var cfg config.Config
+	cfg.Output.Elasticsearch.InitDefaults()
+	cfg.Output.Elasticsearch.Protocol = "http"
+	cfg.Output.Elasticsearch.Hosts = []string{srv.Listener.Addr().String()}
+
+	client, err := NewClient(context.Background(), &cfg, false, WithUserAgent("Fleet-Server", bi))
+	require.NoError(t, err)
+
+	res, err := client.Info()
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	expected := fmt.Sprintf("Elastic-Fleet-Server/8.12.0 (%s; %s; abc123; %s)", runtime.GOOS, runtime.GOARCH, bi.BuildTime)
+	require.Equal(t, expected, <-userAgents)
+}
@@ -16,6 +16,11 @@ const (
 	timeoutErrorType         = "timeout_exception"
 	indexNotFoundErrorType   = "index_not_found_exception"
 	versionConflictErrorType = "version_conflict_engine_exception"
+
+	unavailableShardsErrorType = "unavailable_shards_exception"
+
+	illegalArgumentErrorType = "illegal_argument_exception"
+	noWriteIndexReason       = "no write index is defined"
 )
 
 // TODO: Why do we have both ErrElastic and ErrorT?  Very strange.
@@ -35,6 +40,10 @@ func (e *ErrElastic) Unwrap() error {
 		return ErrIndexNotFound
 	} else if e.Type == timeoutErrorType {
 		return ErrTimeout
+	} else if e.Type == unavailableShardsErrorType {
+		return ErrUnavailableShards
+	} else if e.Type == illegalArgumentErrorType && strings.Contains(e.Reason, noWriteIndexReason) {
+		return ErrNoWriteIndex
 	}
 
 	return nil
@@ -74,6 +83,8 @@ var (
 	ErrIndexNotFound          = errors.New("index not found")
 	ErrTimeout                = errors.New("timeout")
 	ErrNotFound               = errors.New("not found")
+	ErrUnavailableShards      = errors.New("not enough active shard copies")
+	ErrNoWriteIndex           = errors.New("no write index for alias")
 
 	knownErrorTypes = [3]string{
 		timeoutErrorType,
@@ -0,0 +1,54 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Subsystems recording the last Elasticsearch error they encountered.
+const (
+	SubsystemBulk       = "bulk"
+	SubsystemLeadership = "leadership"
+	SubsystemMonitor    = "monitor"
+)
+
+// LastError is the last Elasticsearch error a subsystem encountered.
+type LastError struct {
+	Subsystem string
+	Message   string
+	Timestamp time.Time
+}
+
+// lastErrors is the last error of each subsystem. The set of subsystems is fixed so it is read without locking.
+var lastErrors = map[string]*atomic.Pointer[LastError]{
+	SubsystemBulk:       {},
+	SubsystemLeadership: {},
+	SubsystemMonitor:    {},
+}
+
+// RecordError records err as the last Elasticsearch error of subsystem.
+// It is safe for concurrent use; nil errors and unknown subsystems are ignored.
+func RecordError(subsystem string, err error) {
+	last, ok := lastErrors[subsystem]
+	if !ok || err == nil {
+		return
+	}
+	last.Store(&LastError{Subsystem: subsystem, Message: err.Error(), Timestamp: time.Now().UTC()})
+}
+
+// LastErrors returns the last error of each subsystem that encountered one, ordered by subsystem.
+func LastErrors() []LastError {
+	errs := make([]LastError, 0, len(lastErrors))
+	for _, last := range lastErrors {
+		if e := last.Load(); e != nil {
+			errs = append(errs, *e)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Subsystem < errs[j].Subsystem })
+	return errs
+}
@@ -0,0 +1,53 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package es
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordError(t *testing.T) {
+	for _, last := range lastErrors {
+		last.Store(nil)
+	}
+	require.Empty(t, LastErrors())
+
+	before := time.Now().UTC()
+	RecordError(SubsystemMonitor, errors.New("first"))
+	RecordError(SubsystemMonitor, errors.New("second"))
+	RecordError(SubsystemBulk, ErrElasticVersionConflict)
+	RecordError(SubsystemLeadership, nil)
+	RecordError("unknown", errors.New("ignored"))
+
+	errs := LastErrors()
+	require.Len(t, errs, 2)
+	require.Equal(t, SubsystemBulk, errs[0].Subsystem)
+	require.Equal(t, ErrElasticVersionConflict.Error(), errs[0].Message)
+	require.Equal(t, SubsystemMonitor, errs[1].Subsystem)
+	require.Equal(t, "second", errs[1].Message)
+	require.False(t, errs[1].Timestamp.Before(before))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				RecordError(SubsystemLeadership, fmt.Errorf("error %d-%d", i, j))
+				_ = LastErrors()
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Len(t, LastErrors(), 3)
+}
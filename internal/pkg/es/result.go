@@ -34,6 +34,7 @@ type HitT struct {
 	Source  json.RawMessage        `json:"_source"`
 	Score   *float64               `json:"_score"`
 	Fields  map[string]interface{} `json:"fields"`
+	Sort    []interface{}          `json:"sort,omitempty"`
 }
 
 func (hit *HitT) Unmarshal(v interface{}) error {
@@ -62,6 +63,8 @@ type Bucket struct {
 	Key          string           `json:"key"`
 	DocCount     int64            `json:"doc_count"`
 	Aggregations map[string]HitsT `json:"-"`
+	// Metrics are the single value metric sub-aggregations of the bucket, such as max.
+	Metrics map[string]Aggregation `json:"-"`
 }
 
 type _bucket Bucket
@@ -83,6 +86,7 @@ func (b *Bucket) UnmarshalJSON(data []byte) error {
 	delete(aggs, "key")
 	delete(aggs, "doc_count")
 	b2.Aggregations = make(map[string]HitsT)
+	b2.Metrics = make(map[string]Aggregation)
 	for name, value := range aggs {
 		vMap, ok := value.(map[string]interface{})
 		if !ok {
@@ -90,6 +94,9 @@ func (b *Bucket) UnmarshalJSON(data []byte) error {
 		}
 		hMap, ok := vMap["hits"]
 		if !ok {
+			if v, ok := vMap["value"].(float64); ok {
+				b2.Metrics[name] = Aggregation{Value: v}
+			}
 			continue
 		}
 		data, err := json.Marshal(hMap)
@@ -126,6 +133,14 @@ type Response struct {
 	} `json:"_shards"`
 	Hits         HitsT                  `json:"hits"`
 	Aggregations map[string]Aggregation `json:"aggregations,omitempty"`
+	PitID        string                 `json:"pit_id,omitempty"`
+
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// PointInTimeResponse is the response of opening a point in time.
+type PointInTimeResponse struct {
+	ID string `json:"id"`
 
 	Error json.RawMessage `json:"error,omitempty"`
 }
@@ -139,6 +154,35 @@ type DeleteByQueryResponse struct {
 	Error json.RawMessage `json:"error,omitempty"`
 }
 
+type UpdateByQueryResponse struct {
+	Status           int    `json:"status"`
+	Took             uint64 `json:"took"`
+	TimedOut         bool   `json:"timed_out"`
+	Total            int64  `json:"total"`
+	Updated          int64  `json:"updated"`
+	Noops            int64  `json:"noops"`
+	VersionConflicts int64  `json:"version_conflicts"`
+
+	Failures []json.RawMessage `json:"failures,omitempty"`
+	Error    json.RawMessage   `json:"error,omitempty"`
+}
+
+// IndexStatsResponse is the subset of the index stats response of the documents and store of the primary shards.
+type IndexStatsResponse struct {
+	All struct {
+		Primaries struct {
+			Docs struct {
+				Count int64 `json:"count"`
+			} `json:"docs"`
+			Store struct {
+				SizeInBytes int64 `json:"size_in_bytes"`
+			} `json:"store"`
+		} `json:"primaries"`
+	} `json:"_all"`
+
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
 type ResultT struct {
 	HitsT
 	Aggregations map[string]Aggregation
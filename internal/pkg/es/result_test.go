@@ -73,3 +73,17 @@ func TestHitUnmarshal(t *testing.T) {
 	}
 
 }
+
+func TestBucketUnmarshal(t *testing.T) {
+	var b Bucket
+	err := json.Unmarshal([]byte(`{"key":"key-1","doc_count":2,"enrolled_at":{"value":1704153600000,"value_as_string":"2024-01-02T00:00:00.000Z"},"latest":{"hits":{"hits":[{"_id":"1"}]}}}`), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Aggregation{Value: 1704153600000}, b.Metrics["enrolled_at"]); diff != "" {
+		t.Fatal(diff)
+	}
+	if len(b.Aggregations["latest"].Hits) != 1 {
+		t.Fatalf("expected the latest top hits, got %v", b.Aggregations)
+	}
+}
@@ -15,8 +15,8 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/idgen"
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/gofrs/uuid"
 	"go.elastic.co/apm/v2"
 )
 
@@ -42,16 +42,32 @@ type Uploader struct {
 
 	chunkClient *elasticsearch.Client
 	bulker      bulk.Bulk
+	idGen       idgen.Generator
 }
 
-func New(chunkClient *elasticsearch.Client, bulker bulk.Bulk, cache cache.Cache, sizeLimit int64, timeLimit time.Duration) *Uploader {
-	return &Uploader{
+// Opt is a functional option used to configure an Uploader.
+type Opt func(*Uploader)
+
+// WithIDGenerator sets the generator of upload operation IDs, it defaults to idgen.Default.
+func WithIDGenerator(g idgen.Generator) Opt {
+	return func(u *Uploader) {
+		u.idGen = g
+	}
+}
+
+func New(chunkClient *elasticsearch.Client, bulker bulk.Bulk, cache cache.Cache, sizeLimit int64, timeLimit time.Duration, opts ...Opt) *Uploader {
+	u := &Uploader{
 		chunkClient: chunkClient,
 		bulker:      bulker,
 		sizeLimit:   sizeLimit,
 		timeLimit:   timeLimit,
 		cache:       cache,
+		idGen:       idgen.Default,
+	}
+	for _, opt := range opts {
+		opt(u)
 	}
+	return u
 }
 
 // Start an upload operation
@@ -78,12 +94,11 @@ func (u *Uploader) Begin(ctx context.Context, data JSDict) (file.Info, error) {
 		return file.Info{}, ErrFileSizeTooLarge
 	}
 
-	uid, err := uuid.NewV4()
+	id, err := u.idGen.NewID()
 	vSpan.End()
 	if err != nil {
 		return file.Info{}, fmt.Errorf("unable to generate upload operation ID: %w", err)
 	}
-	id := uid.String()
 
 	// grab required fields that were checked already in validation step
 	agentID, _ := data.Str("agent_id")
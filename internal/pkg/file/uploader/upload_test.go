@@ -7,6 +7,7 @@ package uploader
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/idgen"
 	itesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 
 	"github.com/stretchr/testify/assert"
@@ -96,6 +98,29 @@ func TestUploadBeginReturnsCorrectInfo(t *testing.T) {
 	assert.WithinDuration(t, time.Now(), info.Start, time.Minute)
 }
 
+func TestUploadBeginIDGenerator(t *testing.T) {
+	data := makeUploadRequestDict(map[string]interface{}{
+		"action_id": "abc",
+		"agent_id":  "XYZ",
+		"src":       "mysource",
+		"file.size": 2048,
+	})
+
+	fakeBulk := itesting.NewMockBulk()
+	fakeBulk.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	var n int
+	u := New(nil, fakeBulk, c, 2048, time.Hour, WithIDGenerator(idgen.Func(func() (string, error) {
+		n++
+		return fmt.Sprintf("upload-%d", n), nil
+	})))
+	info, err := u.Begin(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "upload-1", info.ID)
+}
+
 // Happy-path case, where everything expected is provided
 // tests the document sent to elasticsearch passes through
 // the correct fields from input
@@ -0,0 +1,89 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// minInactivityTimeout keeps the auto unenroll well clear of the agent offline threshold.
+	minInactivityTimeout = 24 * time.Hour
+
+	inactiveAgentsBatchSize = 100
+	unenrolledReasonTimeout = "timeout"
+)
+
+func getInactiveAgentsUnenrollFunc(bulker bulk.Bulk, inactivityTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return unenrollInactiveAgents(ctx, dl.FleetAgents, bulker, inactivityTimeout, time.Now())
+	}
+}
+
+// unenrollInactiveAgents soft unenrolls active agents that have not checked in within inactivityTimeout of now
+// and invalidates their API keys.
+func unenrollInactiveAgents(ctx context.Context, index string, bulker bulk.Bulk, inactivityTimeout time.Duration, now time.Time) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "inactive agents unenroll").Dur("inactivity_timeout", inactivityTimeout).Logger()
+
+	lastCheckinBefore := now.Add(-inactivityTimeout)
+	unenrolledAt := now.UTC().Format(time.RFC3339)
+
+	var count int
+	for {
+		agents, err := dl.FindInactiveAgents(ctx, bulker, lastCheckinBefore, inactiveAgentsBatchSize, dl.WithIndexName(index))
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to find inactive agents")
+			return err
+		}
+
+		for i := range agents {
+			if err := unenrollAgent(ctx, index, bulker, &agents[i], unenrolledAt); err != nil {
+				log.Debug().Err(err).Str("agent_id", agents[i].Id).Msg("failed to unenroll inactive agent")
+				return err
+			}
+			log.Info().Str("agent_id", agents[i].Id).Str("last_checkin", agents[i].LastCheckin).Msg("unenrolled inactive agent")
+		}
+		count += len(agents)
+
+		if len(agents) < inactiveAgentsBatchSize {
+			break
+		}
+	}
+	log.Debug().Int("count", count).Msg("unenrolled inactive agents")
+	return nil
+}
+
+func unenrollAgent(ctx context.Context, index string, bulker bulk.Bulk, agent *model.Agent, unenrolledAt string) error {
+	if apiKeys := agent.APIKeyIDs(); len(apiKeys) > 0 {
+		if err := bulker.APIKeyInvalidate(ctx, apiKeys...); err != nil {
+			return fmt.Errorf("invalidate apikey: %w", err)
+		}
+	}
+
+	body, err := bulk.UpdateFields{
+		dl.FieldActive:           false,
+		dl.FieldUnenrolledAt:     unenrolledAt,
+		dl.FieldUnenrolledReason: unenrolledReasonTimeout,
+		dl.FieldUpdatedAt:        unenrolledAt,
+	}.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	// Refresh so the next batch no longer sees this agent as active.
+	if err := bulker.Update(ctx, index, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// agentsBulk answers the agents cleanup queries from an in memory set of agents.
+type agentsBulk struct {
+	*ftesting.MockBulk
+	agents []model.Agent
+}
+
+func (b *agentsBulk) Search(ctx context.Context, index string, body []byte, opts ...bulk.Opt) (*es.ResultT, error) {
+	var query struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]struct {
+						LTE string `json:"lte"`
+					} `json:"range"`
+				} `json:"filter"`
+				MustNot struct {
+					Exists *struct {
+						Field string `json:"field"`
+					} `json:"exists"`
+				} `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &query); err != nil {
+		return nil, err
+	}
+
+	fields := func(agent model.Agent) map[string]string {
+		return map[string]string{
+			dl.FieldLastCheckin: agent.LastCheckin,
+			dl.FieldEnrolledAt:  agent.EnrolledAt,
+		}
+	}
+	match := func(agent model.Agent) bool {
+		if !agent.Active {
+			return false
+		}
+		values := fields(agent)
+		for _, f := range query.Query.Bool.Filter {
+			for field, r := range f.Range {
+				if v := values[field]; v == "" || v > r.LTE {
+					return false
+				}
+			}
+		}
+		if f := query.Query.Bool.MustNot.Exists; f != nil && values[f.Field] != "" {
+			return false
+		}
+		return true
+	}
+
+	res := &es.ResultT{}
+	for _, agent := range b.agents {
+		if !match(agent) {
+			continue
+		}
+		src, err := json.Marshal(agent)
+		if err != nil {
+			return nil, err
+		}
+		res.Hits = append(res.Hits, es.HitT{ID: agent.Id, Source: src})
+	}
+	return res, nil
+}
+
+func TestUnenrollInactiveAgents(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	now := time.Now()
+	checkin := func(ago time.Duration) string {
+		return now.Add(-ago).UTC().Format(time.RFC3339)
+	}
+
+	bulker := &agentsBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		agents: []model.Agent{
+			{ESDocument: model.ESDocument{Id: "dead"}, Active: true, LastCheckin: checkin(72 * time.Hour), AccessAPIKeyID: "dead-key"},
+			{ESDocument: model.ESDocument{Id: "offline"}, Active: true, LastCheckin: checkin(time.Hour), AccessAPIKeyID: "offline-key"},
+			{ESDocument: model.ESDocument{Id: "unenrolled"}, Active: false, LastCheckin: checkin(96 * time.Hour), AccessAPIKeyID: "unenrolled-key"},
+		},
+	}
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"dead-key"}).Return(nil).Once()
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "dead", mock.MatchedBy(func(body []byte) bool {
+		var doc struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return false
+		}
+		return doc.Doc[dl.FieldActive] == false && doc.Doc[dl.FieldUnenrolledReason] == unenrolledReasonTimeout
+	}), mock.Anything).Return(nil).Once()
+
+	err := unenrollInactiveAgents(ctx, dl.FleetAgents, bulker, 48*time.Hour, now)
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+}
+
+func TestSchedules(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	require.Len(t, Schedules(bulker, config.GC{}), 1)
+	require.Len(t, Schedules(bulker, config.GC{InactivityUnenrollTimeout: 30 * 24 * time.Hour}), 2)
+	require.Len(t, Schedules(bulker, config.GC{InactivityUnenrollTimeout: 30 * 24 * time.Hour, EnrollmentTimeout: 24 * time.Hour}), 3)
+}
@@ -0,0 +1,75 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+
+	"github.com/rs/zerolog"
+)
+
+// minEnrollmentTimeout leaves agents that are slow to come up time to complete their first checkin.
+const minEnrollmentTimeout = time.Hour
+
+func getEnrollingAgentsGCFunc(bulker bulk.Bulk, enrollmentTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return cleanupEnrollingAgents(ctx, dl.FleetAgents, bulker, enrollmentTimeout, time.Now())
+	}
+}
+
+// cleanupEnrollingAgents deletes agents that enrolled more than enrollmentTimeout before now but never
+// checked in, and invalidates the API keys created for them.
+func cleanupEnrollingAgents(ctx context.Context, index string, bulker bulk.Bulk, enrollmentTimeout time.Duration, now time.Time) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet stuck enrollments cleanup").Dur("enrollment_timeout", enrollmentTimeout).Logger()
+
+	enrolledBefore := now.Add(-enrollmentTimeout)
+
+	var count int
+	for {
+		agents, err := dl.FindEnrollingAgents(ctx, bulker, enrolledBefore, inactiveAgentsBatchSize, dl.WithIndexName(index))
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to find enrolling agents")
+			return err
+		}
+
+		for i := range agents {
+			if err := deleteEnrollingAgent(ctx, index, bulker, &agents[i]); err != nil {
+				log.Debug().Err(err).Str("agent_id", agents[i].Id).Msg("failed to delete enrolling agent")
+				return err
+			}
+			log.Info().Str("agent_id", agents[i].Id).Str("enrolled_at", agents[i].EnrolledAt).Msg("deleted agent that never completed enrollment")
+		}
+		count += len(agents)
+
+		if len(agents) < inactiveAgentsBatchSize {
+			break
+		}
+	}
+	log.Debug().Int("count", count).Msg("deleted stuck enrolling agents")
+	return nil
+}
+
+func deleteEnrollingAgent(ctx context.Context, index string, bulker bulk.Bulk, agent *model.Agent) error {
+	if apiKeys := agent.APIKeyIDs(); len(apiKeys) > 0 {
+		if err := bulker.APIKeyInvalidate(ctx, apiKeys...); err != nil {
+			return fmt.Errorf("invalidate apikey: %w", err)
+		}
+	}
+
+	// Refresh so the next batch no longer sees this agent.
+	if err := bulker.Delete(ctx, index, agent.Id, bulk.WithRefresh()); err != nil && !errors.Is(err, es.ErrElasticNotFound) {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestCleanupEnrollingAgents(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	now := time.Now()
+	ago := func(d time.Duration) string {
+		return now.Add(-d).UTC().Format(time.RFC3339)
+	}
+
+	bulker := &agentsBulk{
+		MockBulk: ftesting.NewMockBulk(),
+		agents: []model.Agent{
+			{ESDocument: model.ESDocument{Id: "stuck"}, Active: true, EnrolledAt: ago(6 * time.Hour), AccessAPIKeyID: "stuck-key"},
+			{ESDocument: model.ESDocument{Id: "enrolling"}, Active: true, EnrolledAt: ago(time.Minute), AccessAPIKeyID: "enrolling-key"},
+			{ESDocument: model.ESDocument{Id: "enrolled"}, Active: true, EnrolledAt: ago(6 * time.Hour), LastCheckin: ago(time.Minute), AccessAPIKeyID: "enrolled-key"},
+		},
+	}
+
+	agents, err := dl.FindEnrollingAgents(ctx, bulker, now.Add(-2*time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, agents, 1)
+	require.Equal(t, "stuck", agents[0].Id)
+
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"stuck-key"}).Return(nil).Once()
+	bulker.On("Delete", mock.Anything, dl.FleetAgents, "stuck", mock.Anything).Return(nil).Once()
+
+	err = cleanupEnrollingAgents(ctx, dl.FleetAgents, bulker, 2*time.Hour, now)
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+}
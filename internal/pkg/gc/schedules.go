@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
 )
 
@@ -16,8 +17,11 @@ const (
 	defaultCleanupIntervalAfterExpired = "30d" // cleanup with expiration older than 30 days from now
 )
 
-// Schedules returns the GC schedules
-func Schedules(bulker bulk.Bulk, scheduleInterval time.Duration, cleanupIntervalAfterExpired string) []scheduler.Schedule {
+// Schedules returns the GC schedules.
+// The agent cleanups only run when their timeout is set in cfg.
+func Schedules(bulker bulk.Bulk, cfg config.GC) []scheduler.Schedule {
+	scheduleInterval := cfg.ScheduleInterval
+	cleanupIntervalAfterExpired := cfg.CleanupAfterExpiredInterval
 	if scheduleInterval == 0 {
 		scheduleInterval = defaultScheduleInterval
 	}
@@ -25,11 +29,34 @@ func Schedules(bulker bulk.Bulk, scheduleInterval time.Duration, cleanupInterval
 		cleanupIntervalAfterExpired = defaultCleanupIntervalAfterExpired
 	}
 
-	return []scheduler.Schedule{
+	schedules := []scheduler.Schedule{
 		{
 			Name:     "fleet actions cleanup",
 			Interval: scheduleInterval,
 			WorkFn:   getActionsGCFunc(bulker, cleanupIntervalAfterExpired),
 		},
 	}
+
+	if inactivityTimeout := cfg.InactivityUnenrollTimeout; inactivityTimeout > 0 {
+		if inactivityTimeout < minInactivityTimeout {
+			inactivityTimeout = minInactivityTimeout
+		}
+		schedules = append(schedules, scheduler.Schedule{
+			Name:     "fleet inactive agents unenroll",
+			Interval: scheduleInterval,
+			WorkFn:   getInactiveAgentsUnenrollFunc(bulker, inactivityTimeout),
+		})
+	}
+
+	if enrollmentTimeout := cfg.EnrollmentTimeout; enrollmentTimeout > 0 {
+		if enrollmentTimeout < minEnrollmentTimeout {
+			enrollmentTimeout = minEnrollmentTimeout
+		}
+		schedules = append(schedules, scheduler.Schedule{
+			Name:     "fleet stuck enrollments cleanup",
+			Interval: scheduleInterval,
+			WorkFn:   getEnrollingAgentsGCFunc(bulker, enrollmentTimeout),
+		})
+	}
+	return schedules
 }
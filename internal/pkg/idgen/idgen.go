@@ -0,0 +1,45 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package idgen generates the IDs of the documents fleet-server creates, such as enrolled agents.
+package idgen
+
+import (
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+// Generator generates unique document IDs.
+type Generator interface {
+	NewID() (string, error)
+}
+
+// Func adapts an ordinary function to a Generator.
+type Func func() (string, error)
+
+// NewID calls f.
+func (f Func) NewID() (string, error) {
+	return f()
+}
+
+// Default is the generator used when none is configured, it returns random (version 4) UUIDs.
+var Default Generator = Func(func() (string, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("unable to generate uuid: %w", err)
+	}
+	return u.String(), nil
+})
+
+// WithPrefix returns a generator that prepends prefix to the IDs generated by g.
+func WithPrefix(prefix string, g Generator) Generator {
+	return Func(func() (string, error) {
+		id, err := g.NewID()
+		if err != nil {
+			return "", err
+		}
+		return prefix + id, nil
+	})
+}
@@ -0,0 +1,34 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package idgen
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefault(t *testing.T) {
+	id, err := Default.NewID()
+	require.NoError(t, err)
+	u, err := uuid.FromString(id)
+	require.NoError(t, err)
+	require.Equal(t, byte(uuid.V4), u.Version())
+}
+
+func TestWithPrefix(t *testing.T) {
+	g := WithPrefix("tenant-a-", Func(func() (string, error) { return "1", nil }))
+	id, err := g.NewID()
+	require.NoError(t, err)
+	require.Equal(t, "tenant-a-1", id)
+
+	errGen := errors.New("generator failed")
+	_, err = WithPrefix("tenant-a-", Func(func() (string, error) { return "", errGen })).NewID()
+	require.ErrorIs(t, err, errGen)
+}
@@ -7,7 +7,10 @@ package limit
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -17,9 +20,18 @@ var (
 	ErrMaxLimit  = errors.New("max limit")
 )
 
+// SetRetryAfter sets the Retry-After header to d rounded up to the second.
+// The header is not set if d is not positive.
+func SetRetryAfter(h http.Header, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	h.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10))
+}
+
 // writeError recreates the behaviour of api/error.go.
 // It is defined separately here to stop a circular import
-func writeError(log *zerolog.Logger, w http.ResponseWriter, err error) error {
+func writeError(log *zerolog.Logger, w http.ResponseWriter, err error, retryAfter time.Duration) error {
 	resp := struct {
 		Status  int    `json:"statusCode"`
 		Error   string `json:"error"`
@@ -45,6 +57,7 @@ func writeError(log *zerolog.Logger, w http.ResponseWriter, err error) error {
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
+	SetRetryAfter(w.Header(), retryAfter)
 	w.WriteHeader(http.StatusTooManyRequests)
 	_, wErr = w.Write(p)
 	return wErr
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
@@ -39,11 +40,12 @@ func TestWriteError(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			log := testlog.SetLogger(t)
-			err := writeError(&log, w, tt.err)
+			err := writeError(&log, w, tt.err, 1500*time.Millisecond)
 			require.NoError(t, err)
 			resp := w.Result()
 			defer resp.Body.Close()
 			require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			require.Equal(t, "2", resp.Header.Get("Retry-After"))
 
 			var body struct {
 				Status int    `json:"statusCode"`
@@ -57,3 +59,12 @@ func TestWriteError(t *testing.T) {
 		})
 	}
 }
+
+func TestSetRetryAfter(t *testing.T) {
+	h := http.Header{}
+	SetRetryAfter(h, 0)
+	require.Empty(t, h.Get("Retry-After"))
+
+	SetRetryAfter(h, 5*time.Second)
+	require.Equal(t, "5", h.Get("Retry-After"))
+}
@@ -26,12 +26,26 @@ type StatIncer interface {
 }
 
 type Limiter struct {
-	rateLimit *rate.Limiter
-	maxLimit  *semaphore.Weighted
+	rateLimit  *rate.Limiter
+	maxLimit   *semaphore.Weighted
+	retryAfter time.Duration
 }
 
-func NewLimiter(cfg *config.Limit) *Limiter {
+// Opt is an option for a Limiter.
+type Opt func(*Limiter)
+
+// WithRetryAfter sets the delay advertised in the Retry-After header when a limit is reached.
+func WithRetryAfter(d time.Duration) Opt {
+	return func(l *Limiter) {
+		l.retryAfter = d
+	}
+}
+
+func NewLimiter(cfg *config.Limit, opts ...Opt) *Limiter {
 	l := &Limiter{}
+	for _, opt := range opts {
+		opt(l)
+	}
 
 	if cfg == nil {
 		return l
@@ -82,7 +96,7 @@ func (l *Limiter) Wrap(name string, si StatIncer, ll zerolog.Level) func(http.Ha
 			lf, err := l.acquire()
 			if err != nil {
 				hlog.FromRequest(r).WithLevel(ll).Str("route", name).Err(err).Msg("limit reached")
-				if wErr := writeError(hlog.FromRequest(r), w, err); wErr != nil {
+				if wErr := writeError(hlog.FromRequest(r), w, err, l.retryAfter); wErr != nil {
 					hlog.FromRequest(r).Error().Err(wErr).Msg("fail writing error response")
 				}
 				if si != nil {
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -37,10 +38,11 @@ func stubHandle() http.Handler {
 
 func Test_Limiter_Wrap(t *testing.T) {
 	tests := []struct {
-		name   string
-		l      *Limiter
-		stats  func() *mockIncer
-		status int
+		name       string
+		l          *Limiter
+		stats      func() *mockIncer
+		status     int
+		retryAfter string
 	}{{
 		name: "no limits",
 		l:    &Limiter{},
@@ -53,7 +55,8 @@ func Test_Limiter_Wrap(t *testing.T) {
 	}, {
 		name: "max limit",
 		l: &Limiter{
-			maxLimit: semaphore.NewWeighted(0),
+			maxLimit:   semaphore.NewWeighted(0),
+			retryAfter: 5 * time.Second,
 		},
 		stats: func() *mockIncer {
 			m := &mockIncer{}
@@ -61,11 +64,13 @@ func Test_Limiter_Wrap(t *testing.T) {
 			m.On("IncError", ErrMaxLimit).Once()
 			return m
 		},
-		status: http.StatusTooManyRequests,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "5",
 	}, {
 		name: "rate limit",
 		l: &Limiter{
-			rateLimit: rate.NewLimiter(rate.Limit(0), 0),
+			rateLimit:  rate.NewLimiter(rate.Limit(0), 0),
+			retryAfter: 10 * time.Second,
 		},
 		stats: func() *mockIncer {
 			m := &mockIncer{}
@@ -73,7 +78,8 @@ func Test_Limiter_Wrap(t *testing.T) {
 			m.On("IncError", ErrRateLimit).Once()
 			return m
 		},
-		status: http.StatusTooManyRequests,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "10",
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -86,6 +92,7 @@ func Test_Limiter_Wrap(t *testing.T) {
 			resp := w.Result()
 			resp.Body.Close()
 			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After"))
 			mi.AssertExpectations(t)
 		})
 	}
@@ -5,6 +5,9 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"maps"
 	"time"
 )
@@ -19,6 +22,16 @@ func (m *PolicyLeader) SetTime(t time.Time) {
 	m.Timestamp = t.Format(time.RFC3339Nano)
 }
 
+// Expired returns true if the leadership has not been held within leaderInterval as of now,
+// meaning another server can take over the lease.
+func (m *PolicyLeader) Expired(now time.Time, leaderInterval time.Duration) (bool, error) {
+	t, err := m.Time()
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(t) > leaderInterval, nil
+}
+
 // Time returns the time for the server.
 func (m *Server) Time() (time.Time, error) {
 	return time.Parse(time.RFC3339Nano, m.Timestamp)
@@ -29,6 +42,16 @@ func (m *Server) SetTime(t time.Time) {
 	m.Timestamp = t.Format(time.RFC3339Nano)
 }
 
+// Expired returns true if the server has not updated its document within interval as of now,
+// meaning it is no longer running.
+func (m *Server) Expired(now time.Time, interval time.Duration) (bool, error) {
+	t, err := m.Time()
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(t) > interval, nil
+}
+
 // CheckDifferentVersion returns Agent version if it is different from ver, otherwise return empty string
 func (a *Agent) CheckDifferentVersion(ver string) string {
 	if a == nil {
@@ -52,6 +75,9 @@ func (a *Agent) APIKeyIDs() []string {
 	if a.AccessAPIKeyID != "" {
 		keys = append(keys, a.AccessAPIKeyID)
 	}
+	if a.PendingAccessAPIKeyID != "" {
+		keys = append(keys, a.PendingAccessAPIKeyID)
+	}
 
 	for _, output := range a.Outputs {
 		if output.APIKeyID != "" {
@@ -68,6 +94,25 @@ func (a *Agent) APIKeyIDs() []string {
 
 }
 
+// OutputHash returns a stable hash of the agent-facing policy output.
+// The revision and signature are excluded as they change on every revision even if the output does not.
+// An empty string is returned for nil policy data.
+func (d *PolicyData) OutputHash() (string, error) {
+	if d == nil {
+		return "", nil
+	}
+	out := *d
+	out.Revision = 0
+	out.Signed = nil
+	// encoding/json sorts map keys, so the encoding is stable for equal data.
+	p, err := json.Marshal(&out)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(p)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func ClonePolicyData(d *PolicyData) *PolicyData {
 	if d == nil {
 		return nil
@@ -122,6 +122,14 @@ func TestAgentAPIKeyIDs(t *testing.T) {
 				"access_api_key_id", "p1_api_key_id", "p2_api_key_id",
 				"p1_to_retire_key", "p2_to_retire_key"},
 		},
+		{
+			name: "with pending access API key",
+			agent: Agent{
+				AccessAPIKeyID:        "access_api_key_id",
+				PendingAccessAPIKeyID: "pending_access_api_key_id",
+			},
+			want: []string{"access_api_key_id", "pending_access_api_key_id"},
+		},
 		{
 			name: "API key empty",
 			agent: Agent{
@@ -159,3 +167,35 @@ func TestAgentAPIKeyIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyDataOutputHash(t *testing.T) {
+	base := &PolicyData{
+		ID:       "policy",
+		Revision: 1,
+		Outputs: map[string]map[string]interface{}{
+			"default": {"type": "elasticsearch", "hosts": []interface{}{"https://es:9200"}},
+		},
+		Signed: &Signed{Data: "data1", Signature: "sig1"},
+	}
+	h1, err := base.OutputHash()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, h1)
+
+	bumped := ClonePolicyData(base)
+	bumped.Revision = 2
+	bumped.Signed = &Signed{Data: "data2", Signature: "sig2"}
+	h2, err := bumped.OutputHash()
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2, "revision and signature should not change the hash")
+
+	changed := ClonePolicyData(base)
+	changed.Outputs["default"]["hosts"] = []interface{}{"https://other-es:9200"}
+	h3, err := changed.OutputHash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, h1, h3)
+
+	var nilData *PolicyData
+	h4, err := nilData.OutputHash()
+	assert.NoError(t, err)
+	assert.Empty(t, h4)
+}
@@ -102,6 +102,9 @@ type ActionResult struct {
 	// The action error message.
 	Error string `json:"error,omitempty"`
 
+	// The machine readable reason code of the action error.
+	ErrorCode string `json:"error_code,omitempty"`
+
 	// Date/time the action was started
 	StartedAt string `json:"started_at,omitempty"`
 
@@ -113,6 +116,9 @@ type ActionResult struct {
 type Agent struct {
 	ESDocument
 
+	// Date/time the API key the Elastic Agent must used to contact Fleet Server expires
+	AccessAPIKeyExpiration string `json:"access_api_key_expiration,omitempty"`
+
 	// ID of the API key the Elastic Agent must used to contact Fleet Server
 	AccessAPIKeyID string `json:"access_api_key_id,omitempty"`
 
@@ -138,6 +144,9 @@ type Agent struct {
 	// Date/time the Elastic Agent enrolled
 	EnrolledAt string `json:"enrolled_at"`
 
+	// ID of the enrollment API key used to enroll the Elastic Agent
+	EnrollmentAPIKeyID string `json:"enrollment_api_key_id,omitempty"`
+
 	// Enrollment ID
 	EnrollmentID string `json:"enrollment_id,omitempty"`
 
@@ -162,6 +171,12 @@ type Agent struct {
 	// Packages array
 	Packages []string `json:"packages,omitempty"`
 
+	// Date/time the API key delivered to the Elastic Agent to replace its expiring access API key expires
+	PendingAccessAPIKeyExpiration string `json:"pending_access_api_key_expiration,omitempty"`
+
+	// ID of the API key delivered to the Elastic Agent to replace its expiring access API key
+	PendingAccessAPIKeyID string `json:"pending_access_api_key_id,omitempty"`
+
 	// The current policy coordinator for the Elastic Agent
 	PolicyCoordinatorIdx int64 `json:"policy_coordinator_idx,omitempty"`
 
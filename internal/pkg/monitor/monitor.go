@@ -221,6 +221,7 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 		checkpoint, err := gcheckpt.Query(sCtx, m.monCli, m.index)
 		span.End()
 		if err != nil {
+			es.RecordError(es.SubsystemMonitor, err)
 			m.log.Warn().Err(err).Msg("failed to initialize the global checkpoints, will retry")
 			err = sleep.WithContext(ctx, retryDelay)
 			if err != nil {
@@ -284,6 +285,7 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 				return err
 			} else {
 				// Log the error and keep trying
+				es.RecordError(es.SubsystemMonitor, err)
 				m.log.Info().Err(err).Msg("failed on waiting for global checkpoints advance")
 			}
 
@@ -321,6 +323,7 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 			// Fetch the documents between the last known checkpoint and the new checkpoint value received from "wait advance".
 			hits, err := m.fetch(ctx, checkpoint, newCheckpoint)
 			if err != nil {
+				es.RecordError(es.SubsystemMonitor, err)
 				m.log.Error().Err(err).Msg("failed checking new documents")
 				if m.tracer != nil {
 					trans.End()
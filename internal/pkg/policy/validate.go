@@ -0,0 +1,116 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// ValidatePolicyData checks the policy assembled for an agent before it is delivered and normalizes it in place.
+//
+// It catches output the agent would reject: outputs and inputs without a type, inputs using an output that is not
+// in the policy, streams that are not objects, non-object agent or fleet sections and secret references that were
+// not resolved. All problems are reported in a single error wrapping ErrInvalidPolicy.
+// The secret references are removed, they are only needed by fleet-server.
+func ValidatePolicyData(data *model.PolicyData) error {
+	var problems []string
+	invalid := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if !isObjectOrEmpty(data.Agent) {
+		invalid("agent must be an object")
+	}
+	if !isObjectOrEmpty(data.Fleet) {
+		invalid("fleet must be an object")
+	}
+
+	if len(data.Outputs) == 0 {
+		invalid("no outputs")
+	}
+	for name, output := range data.Outputs {
+		if s, _ := output[FieldOutputType].(string); s == "" {
+			invalid("output %q: missing type", name)
+		}
+	}
+
+	for i, input := range data.Inputs {
+		name := fmt.Sprintf("input %d", i)
+		if id, ok := input["id"].(string); ok && id != "" {
+			name = fmt.Sprintf("input %q", id)
+		}
+
+		if s, _ := input["type"].(string); s == "" {
+			invalid("%s: missing type", name)
+		}
+		if v, ok := input["use_output"]; ok {
+			s, _ := v.(string)
+			if _, ok := data.Outputs[s]; !ok {
+				invalid("%s: use_output %v is not a policy output", name, v)
+			}
+		}
+		if v, ok := input["streams"]; ok && v != nil {
+			streams, ok := v.([]interface{})
+			if !ok {
+				invalid("%s: streams must be a list", name)
+			}
+			for j, stream := range streams {
+				if _, ok := stream.(map[string]interface{}); !ok {
+					invalid("%s: stream %d must be an object", name, j)
+				}
+			}
+		}
+		if ref, ok := findSecretRef(input); ok {
+			invalid("%s: unresolved secret reference %s", name, ref)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w %q: %s", ErrInvalidPolicy, data.ID, strings.Join(problems, "; "))
+	}
+
+	data.SecretReferences = nil
+	return nil
+}
+
+func isObjectOrEmpty(raw json.RawMessage) bool {
+	if len(raw) == 0 || string(raw) == "null" {
+		return true
+	}
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(raw, &obj) == nil
+}
+
+// findSecretRef returns the first secret reference left in v.
+func findSecretRef(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		if m := secretRegex.FindString(val); m != "" {
+			return m, true
+		}
+	case map[string]interface{}:
+		for _, e := range val {
+			if ref, ok := findSecretRef(e); ok {
+				return ref, true
+			}
+		}
+	case []interface{}:
+		for _, e := range val {
+			if ref, ok := findSecretRef(e); ok {
+				return ref, true
+			}
+		}
+	}
+	return "", false
+}
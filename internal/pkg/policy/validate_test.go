@@ -0,0 +1,91 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePolicyData(t *testing.T) {
+	validPolicy := func() *model.PolicyData {
+		return &model.PolicyData{
+			ID:    "policy-id",
+			Agent: json.RawMessage(`{"monitoring":{"enabled":true}}`),
+			Outputs: map[string]map[string]interface{}{
+				"default": {"type": OutputTypeElasticsearch},
+			},
+			Inputs: []map[string]interface{}{{
+				"id":         "system-1",
+				"type":       "logfile",
+				"use_output": "default",
+				"streams":    []interface{}{map[string]interface{}{"id": "system.auth", "paths": []interface{}{"/var/log/auth.log*"}}},
+			}, {
+				"type": "fleet-server",
+			}},
+			SecretReferences: []model.SecretReferencesItems{{ID: "ref1"}},
+		}
+	}
+
+	t.Run("valid policy", func(t *testing.T) {
+		data := validPolicy()
+		require.NoError(t, ValidatePolicyData(data))
+		require.Nil(t, data.SecretReferences)
+	})
+
+	tests := []struct {
+		name   string
+		modify func(*model.PolicyData)
+		errMsg string
+	}{{
+		name: "output without type",
+		modify: func(d *model.PolicyData) {
+			d.Outputs["default"] = map[string]interface{}{"hosts": []interface{}{"localhost:9200"}}
+		},
+		errMsg: `output "default": missing type`,
+	}, {
+		name:   "input without type",
+		modify: func(d *model.PolicyData) { delete(d.Inputs[1], "type") },
+		errMsg: "input 1: missing type",
+	}, {
+		name:   "input using unknown output",
+		modify: func(d *model.PolicyData) { d.Inputs[0]["use_output"] = "remote" },
+		errMsg: `input "system-1": use_output remote is not a policy output`,
+	}, {
+		name:   "malformed streams",
+		modify: func(d *model.PolicyData) { d.Inputs[0]["streams"] = []interface{}{"system.auth"} },
+		errMsg: `input "system-1": stream 0 must be an object`,
+	}, {
+		name:   "unresolved secret",
+		modify: func(d *model.PolicyData) { d.Inputs[1]["password"] = "$co.elastic.secret{ref1}" },
+		errMsg: "input 1: unresolved secret reference $co.elastic.secret{ref1}",
+	}, {
+		name:   "agent section not an object",
+		modify: func(d *model.PolicyData) { d.Agent = json.RawMessage(`["monitoring"]`) },
+		errMsg: "agent must be an object",
+	}, {
+		name:   "no outputs",
+		modify: func(d *model.PolicyData) { d.Outputs = nil },
+		errMsg: "no outputs",
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := validPolicy()
+			tc.modify(data)
+			err := ValidatePolicyData(data)
+			require.ErrorIs(t, err, ErrInvalidPolicy)
+			require.ErrorContains(t, err, `invalid policy "policy-id"`)
+			require.ErrorContains(t, err, tc.errMsg)
+			require.NotNil(t, data.SecretReferences)
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	libapi "github.com/elastic/elastic-agent-libs/api"
 	"github.com/elastic/fleet-server/v7/internal/pkg/state"
 
 	"go.elastic.co/apm/v2"
@@ -34,6 +35,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/profile"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/telemetry"
 	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
 
 	"github.com/hashicorp/go-version"
@@ -371,6 +373,15 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 		return err
 	}
 
+	if metricsServer != nil {
+		if cfg.Inputs[0].Server.Admin.Enabled {
+			api.AttachAdminEndpoints(ctx, metricsServer, bulker, f.cache)
+		}
+		if srv := &cfg.Inputs[0].Server; srv.Autoscaling.Enabled {
+			api.AttachAutoscalingEndpoint(ctx, metricsServer, srv.Autoscaling, srv.Metrics.AgentStatusInterval > 0, bulker)
+		}
+	}
+
 	// Execute the bulker engine in a goroutine with its orphaned context.
 	// Create an error channel for the case where the bulker exits
 	// unexpectedly (ie. not cancelled by the bulkCancel context).
@@ -403,6 +414,18 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 		return
 	})
 
+	if shutdown, err := f.initOTel(ctx, cfg.Inputs[0].Server.Instrumentation.OTel); err != nil {
+		return err
+	} else if shutdown != nil {
+		go func() {
+			<-ctx.Done()
+			zerolog.Ctx(ctx).Info().Msg("flushing otel tracer provider...")
+			if err := shutdown(context.Background()); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("unable to shutdown otel tracer provider")
+			}
+		}()
+	}
+
 	if tracer != nil {
 		go func() {
 			<-ctx.Done()
@@ -412,14 +435,14 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 		}()
 	}
 
-	if err = f.runSubsystems(ctx, cfg, g, bulker, tracer); err != nil {
+	if err = f.runSubsystems(ctx, cfg, g, bulker, tracer, metricsServer); err != nil {
 		return err
 	}
 
 	return g.Wait()
 }
 
-func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgroup.Group, bulker bulk.Bulk, tracer *apm.Tracer) (err error) {
+func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgroup.Group, bulker bulk.Bulk, tracer *apm.Tracer, metricsServer *libapi.Server) (err error) {
 	esCli := bulker.Client()
 
 	// Version check is not performed in standalone mode because it is expected that
@@ -448,9 +471,18 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 		}
 	}
 
+	dl.SetLogQueries(cfg.Inputs[0].Server.Debug.LogQueries)
+
 	// Run scheduler for periodic GC/cleanup
 	gcCfg := cfg.Inputs[0].Server.GC
-	sched, err := scheduler.New(gc.Schedules(bulker, gcCfg.ScheduleInterval, gcCfg.CleanupAfterExpiredInterval))
+	schedules := gc.Schedules(bulker, gcCfg)
+	if interval := cfg.Inputs[0].Server.Metrics.AgentStatusInterval; interval > 0 {
+		schedules = append(schedules, api.AgentStatusSchedule(bulker, interval))
+	}
+	if interval := cfg.Inputs[0].Server.Metrics.LeaderIndexInterval; interval > 0 {
+		schedules = append(schedules, api.LeaderIndexStatsSchedule(bulker, interval))
+	}
+	sched, err := scheduler.New(schedules)
 	if err != nil {
 		return fmt.Errorf("failed to create elasticsearch GC: %w", err)
 	}
@@ -475,7 +507,7 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 	}
 
 	g.Go(loggedRunFunc(ctx, "Policy index monitor", pim.Run))
-	cord := coordinator.NewMonitor(cfg.Fleet, f.bi.Version, bulker, pim, coordinator.NewCoordinatorZero)
+	cord := coordinator.NewMonitor(cfg.Fleet, cfg.Inputs[0].Server.Leadership, f.bi.Version, bulker, pim, coordinator.NewCoordinatorZero)
 	g.Go(loggedRunFunc(ctx, "Coordinator policy monitor", cord.Run))
 
 	// Policy monitor
@@ -507,7 +539,7 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 	}
 	g.Go(loggedRunFunc(ctx, "Revision monitor", am.Run))
 
-	ad = action.NewDispatcher(am, cfg.Inputs[0].Server.Limits.ActionLimit.Interval, cfg.Inputs[0].Server.Limits.ActionLimit.Burst)
+	ad = action.NewDispatcher(am, cfg.Inputs[0].Server.Limits.ActionLimit.Interval, cfg.Inputs[0].Server.Limits.ActionLimit.Burst, cfg.Inputs[0].Server.Checkin.DispatchBatch())
 	g.Go(loggedRunFunc(ctx, "Revision dispatcher", ad.Run))
 	tr, err = action.NewTokenResolver(bulker)
 	if err != nil {
@@ -518,6 +550,10 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 	g.Go(loggedRunFunc(ctx, "Bulk checkin", bc.Run))
 
 	ct := api.NewCheckinT(f.verCon, &cfg.Inputs[0].Server, f.cache, bc, pm, am, ad, tr, bulker)
+	if metricsServer != nil && cfg.Inputs[0].Server.Admin.Enabled {
+		api.AttachCheckinDryRunEndpoint(ctx, metricsServer, ct)
+		api.AttachDeliveredPolicyEndpoint(ctx, metricsServer, ct)
+	}
 	et, err := api.NewEnrollerT(f.verCon, &cfg.Inputs[0].Server, bulker, f.cache)
 	if err != nil {
 		return err
@@ -590,6 +626,18 @@ func (f *Fleet) initTracer(ctx context.Context, cfg config.Instrumentation) (*ap
 	})
 }
 
+const envOTelTracesExporter = "OTEL_TRACES_EXPORTER"
+
+// initOTel installs the OpenTelemetry tracer provider if enabled and returns its shutdown func.
+func (f *Fleet) initOTel(ctx context.Context, cfg config.InstrumentationOTel) (func(context.Context) error, error) {
+	if !cfg.Enabled && os.Getenv(envOTelTracesExporter) != "otlp" {
+		return nil, nil
+	}
+
+	zerolog.Ctx(ctx).Info().Msg("fleet-server otel tracing is enabled")
+	return telemetry.Init(ctx, f.bi)
+}
+
 func elasticsearchOptions(instumented bool, bi build.Info) []es.ConfigOption {
 	options := []es.ConfigOption{es.WithUserAgent(kUAFleetServer, bi)}
 	if instumented {
@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package telemetry exports OpenTelemetry traces for the fleet-server hot paths.
+//
+// Spans are always created through the global tracer provider, which is a noop
+// unless Init has been called.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+)
+
+const tracerName = "github.com/elastic/fleet-server"
+
+// Attribute keys set on fleet-server spans.
+const (
+	AttrAgentID  = attribute.Key("fleet.agent.id")
+	AttrPolicyID = attribute.Key("fleet.policy.id")
+	AttrIndex    = attribute.Key("db.elasticsearch.index")
+)
+
+// Tracer returns the fleet-server tracer from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start starts a span named name as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a span named name as a child of any span in ctx, linked to the spans in links.
+// It traces work done on behalf of several requests at once, such as a batched Elasticsearch write.
+func StartLinked(ctx context.Context, name string, links []trace.Link, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithLinks(links...), trace.WithAttributes(attrs...))
+}
+
+// Middleware continues the trace of the caller: the spans of a request carrying a W3C traceparent header
+// are children of the remote span it identifies.
+func Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Init installs a global tracer provider exporting spans with OTLP over HTTP.
+//
+// The exporter is configured with the standard OTEL_EXPORTER_OTLP_* environment variables.
+// The returned function flushes and stops the provider.
+func Init(ctx context.Context, bi build.Info) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create otlp trace exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("fleet-server"),
+		semconv.ServiceVersion(bi.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create otel resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
@@ -624,6 +624,17 @@ func NewAgentCheckinRequestWithBody(server string, id string, params *AgentCheck
 			req.Header.Set("elastic-api-version", headerParam3)
 		}
 
+		if params.IfNoneMatch != nil {
+			var headerParam4 string
+
+			headerParam4, err = runtime.StyleParamWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, *params.IfNoneMatch)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("If-None-Match", headerParam4)
+		}
+
 	}
 
 	return req, nil